@@ -0,0 +1,183 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+)
+
+// Sizes are the square edge lengths, in pixels, every avatar is stored in
+var Sizes = []int{32, 64, 128, 256}
+
+// DefaultSize is served when the client doesn't ask for a specific size
+const DefaultSize = 128
+
+const (
+	// MaxUploadSize is the largest avatar file accepted, in bytes
+	MaxUploadSize = 5 << 20
+	// MaxDimension guards against decompression bombs, no side of an
+	// uploaded image may be larger than this
+	MaxDimension = 4096
+	// MinDimension is the smallest side an uploaded image may have
+	MinDimension = 32
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("image must be a jpeg, png or gif")
+	ErrTooLarge          = fmt.Errorf("image must be at most %dx%d pixels", MaxDimension, MaxDimension)
+	ErrTooSmall          = fmt.Errorf("image must be at least %dx%d pixels", MinDimension, MinDimension)
+	ErrInvalidCrop       = errors.New("crop area is outside of the image")
+)
+
+// Crop is the square region of the uploaded image to use as the avatar.
+// A zero Size means crop the largest centered square
+type Crop struct {
+	X, Y, Size int
+}
+
+// Process validates the uploaded image, crops it and returns it resized into
+// every size in Sizes, encoded as png. Re-encoding also drops any metadata
+// the original file carried
+func Process(r io.Reader, crop Crop) (map[int][]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxUploadSize {
+		return nil, fmt.Errorf("image must be at most %d bytes", MaxUploadSize)
+	}
+
+	// check format and dimensions before decoding the whole image
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if format != "jpeg" && format != "png" && format != "gif" {
+		return nil, ErrUnsupportedFormat
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return nil, ErrTooLarge
+	}
+	if config.Width < MinDimension || config.Height < MinDimension {
+		return nil, ErrTooSmall
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	square, err := cropSquare(img, crop)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[int][]byte, len(Sizes))
+	for _, size := range Sizes {
+		encoded, err := encodePNG(resize(square, size))
+		if err != nil {
+			return nil, err
+		}
+		results[size] = encoded
+	}
+	return results, nil
+}
+
+// cropSquare copies the square region of img described by crop into a new
+// RGBA image, so later steps don't depend on the source color model
+func cropSquare(img image.Image, crop Crop) (*image.RGBA, error) {
+	b := img.Bounds()
+	if crop.Size == 0 {
+		side := b.Dx()
+		if b.Dy() < side {
+			side = b.Dy()
+		}
+		crop.X = (b.Dx() - side) / 2
+		crop.Y = (b.Dy() - side) / 2
+		crop.Size = side
+	}
+
+	rect := image.Rect(crop.X, crop.Y, crop.X+crop.Size, crop.Y+crop.Size).Add(b.Min)
+	if crop.X < 0 || crop.Y < 0 || crop.Size < MinDimension || !rect.In(b) {
+		return nil, ErrInvalidCrop
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, crop.Size, crop.Size))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst, nil
+}
+
+// resize scales the square src to size x size. Every destination pixel is
+// the average of the source pixels it covers, which keeps downscaled
+// avatars smooth; when upscaling it falls back to the nearest pixel
+func resize(src *image.RGBA, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	srcSize := src.Bounds().Dx()
+
+	for y := 0; y < size; y++ {
+		y0 := y * srcSize / size
+		y1 := (y + 1) * srcSize / size
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0 := x * srcSize / size
+			x1 := (x + 1) * srcSize / size
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := src.RGBAAt(sx, sy)
+					r += uint32(c.R)
+					g += uint32(c.G)
+					b += uint32(c.B)
+					a += uint32(c.A)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Key returns the storage key of a user's avatar in the given size
+func Key(userId string, size int) string {
+	return fmt.Sprintf("avatars/%s/%d.png", userId, size)
+}
+
+// URL returns the address the backend serves a user's avatar from. It is
+// absolute when PUBLIC_URL is set, since the client runs on another origin
+func URL(userId string) string {
+	return os.Getenv("PUBLIC_URL") + "/api/user/avatar/" + userId
+}
+
+// NearestSize returns the smallest stored size that is at least size, or the
+// largest one available
+func NearestSize(size int) int {
+	for _, s := range Sizes {
+		if s >= size {
+			return s
+		}
+	}
+	return Sizes[len(Sizes)-1]
+}
@@ -0,0 +1,84 @@
+package avatar
+
+import (
+	"crypto/md5"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+)
+
+// identiconGrid is the number of cells on each side of an identicon
+const identiconGrid = 5
+
+// Identicon generates a symmetric 5x5 block pattern derived from seed, so
+// every user gets a stable avatar until they upload their own
+func Identicon(seed string, size int) ([]byte, error) {
+	sum := md5.Sum([]byte(seed))
+	fg := seedColor(sum)
+	bg := color.RGBA{240, 240, 240, 255}
+
+	// the left three columns come from the hash, the right two mirror them
+	var cells [identiconGrid][identiconGrid]bool
+	for i := 0; i < identiconGrid*3; i++ {
+		row, col := i/3, i%3
+		on := sum[i]%2 == 0
+		cells[row][col] = on
+		cells[row][identiconGrid-1-col] = on
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	// keep a margin of half a cell around the pattern
+	cell := size / (identiconGrid + 1)
+	margin := (size - cell*identiconGrid) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := bg
+			cx, cy := (x-margin)/cell, (y-margin)/cell
+			if x >= margin && y >= margin && cx < identiconGrid && cy < identiconGrid && cells[cy][cx] {
+				c = fg
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return encodePNG(img)
+}
+
+// Initials generates an svg avatar showing up to two initials of name on a
+// background color derived from seed
+func Initials(name, seed string, size int) []byte {
+	bg := seedColor(md5.Sum([]byte(seed)))
+
+	var initials []rune
+	for _, word := range strings.Fields(name) {
+		r := []rune(word)[0]
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			initials = append(initials, unicode.ToUpper(r))
+		}
+		if len(initials) == 2 {
+			break
+		}
+	}
+	if len(initials) == 0 {
+		initials = []rune{'?'}
+	}
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="%[1]d" viewBox="0 0 100 100">`+
+		`<rect width="100" height="100" fill="#%02x%02x%02x"/>`+
+		`<text x="50" y="50" dy=".35em" text-anchor="middle" font-family="sans-serif" font-size="42" fill="#ffffff">%s</text>`+
+		`</svg>`, size, bg.R, bg.G, bg.B, html.EscapeString(string(initials)))
+	return []byte(svg)
+}
+
+// seedColor picks a saturated color from the tail of the hash, dark enough
+// for white text to stay readable on it
+func seedColor(sum [md5.Size]byte) color.RGBA {
+	return color.RGBA{
+		R: 40 + sum[13]%160,
+		G: 40 + sum[14]%160,
+		B: 40 + sum[15]%160,
+		A: 255,
+	}
+}
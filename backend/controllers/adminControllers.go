@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/helpers"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// resetTokenLifetime is how long a forced password reset token can be used
+const resetTokenLifetime = 24 * time.Hour
+
+// AdminGetUsers lists every user with their account state, optionally those
+// whose name, a word of their name or email starts with the search text, or
+// only suspended ones with suspended=true. Results are paginated with page
+// and limit, the total is sent in X-Total-Count
+func AdminGetUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.D{}
+		if query := searchText(strings.ToLower(strings.TrimSpace(c.Query("search")))); query != "" {
+			filter = append(filter, bson.E{"searchKeys", bson.D{{"$regex", "^" + regexp.QuoteMeta(query)}}})
+		}
+		if c.Query("suspended") == "true" {
+			filter = append(filter, bson.E{"suspended", true})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		userCollection := database.OpenCollection(database.Client, "user")
+
+		total, err := userCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
+		}
+
+		opts := options.Find().
+			SetProjection(bson.D{
+				{"_id", 1},
+				{"name", 1},
+				{"email", 1},
+				{"pic", 1},
+				{"isAdmin", 1},
+				{"suspended", 1},
+				{"created_at", 1},
+			}).
+			SetSort(bson.D{{"name", 1}, {"_id", 1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := userCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// AdminSuspendUser locks the user out: their requests are rejected and their
+// websocket connections closed. Admins can't be suspended
+func AdminSuspendUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		user, ok := findUser(ctx, c, userId)
+		if !ok {
+			return
+		}
+		if user.IsAdmin {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Admins can't be suspended"})
+			return
+		}
+
+		if err := setSuspended(ctx, userId, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while updating user data"})
+			log.Println(err)
+			return
+		}
+
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditUserSuspended, Target: userId})
+		c.JSON(http.StatusOK, gin.H{"_id": userId, "suspended": true})
+	}
+}
+
+// AdminUnsuspendUser lets a suspended user back in
+func AdminUnsuspendUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := findUser(ctx, c, userId); !ok {
+			return
+		}
+
+		if err := setSuspended(ctx, userId, false); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while updating user data"})
+			log.Println(err)
+			return
+		}
+
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditUserUnsuspended, Target: userId})
+		c.JSON(http.StatusOK, gin.H{"_id": userId, "suspended": false})
+	}
+}
+
+// AdminForcePasswordReset revokes every token of the user and blocks their
+// login until they set a new password. The reset token in the response is
+// shown only once, the admin hands it to the user
+func AdminForcePasswordReset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := findUser(ctx, c, userId); !ok {
+			return
+		}
+
+		token, err := helpers.RandomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to generate token"})
+			log.Println(err)
+			return
+		}
+		expiresAt := time.Now().Add(resetTokenLifetime)
+
+		userCollection := database.OpenCollection(database.Client, "user")
+		update := bson.D{{"$set", bson.D{
+			{"resetTokenHash", helpers.HashToken(token)},
+			{"resetExpiresAt", expiresAt},
+			{"tokensValidAfter", time.Now().Truncate(time.Second)},
+			{"updated_at", time.Now()},
+		}}}
+		if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", userId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while updating user data"})
+			log.Println(err)
+			return
+		}
+		websocket.Disconnect(userId)
+
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditTokensRevoked, Target: userId, Details: "forced password reset"})
+		c.JSON(http.StatusOK, gin.H{"resetToken": token, "expiresAt": expiresAt})
+	}
+}
+
+// AdminDeleteChat deletes any chat along with its messages, telling its members
+// and the webhooks of its workspace
+func AdminDeleteChat() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var chat models.Chat
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		err = chatCollection.FindOne(ctx, bson.D{{"_id", chatId}}).Decode(&chat)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		if err := deleteChat(ctx, chatId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting chat"})
+			log.Println(err)
+			return
+		}
+
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditChatDeleted, Chat: chatId, Details: "deleted by admin"})
+		websocket.Publish(chatId.Hex(), map[string]interface{}{"messageType": "chatDeleted"})
+		// the webhooks of the chat went with it, the ones of its workspace
+		// are still told
+		data := gin.H{"chatId": chatId, "deletedBy": c.MustGet("_id").(primitive.ObjectID)}
+		if err := queueWebhookEvent(ctx, models.EventChatDeleted, chat, data); err != nil {
+			log.Println("error while queueing webhook event: ", err)
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// AdminGetStats returns counts of users, chats, messages and connections
+func AdminGetStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// chats deleted for everyone are only kept until they're purged
+		notDeleted := bson.E{"deleted_at", bson.D{{"$exists", false}}}
+
+		counts := []struct {
+			name       string
+			collection string
+			filter     bson.D
+		}{
+			{"users", "user", bson.D{}},
+			{"suspendedUsers", "user", bson.D{{"suspended", true}}},
+			{"admins", "user", bson.D{{"isAdmin", true}}},
+			{"chats", "chat", bson.D{notDeleted}},
+			{"groupChats", "chat", bson.D{{"isGroupChat", true}, notDeleted}},
+			{"channels", "chat", bson.D{{"isGroupChat", true}, {"visibility", models.VisibilityPublic}, notDeleted}},
+			{"messages", "message", bson.D{}},
+			{"messagesLastDay", "message", bson.D{{"created_at", bson.D{{"$gte", time.Now().Add(-24 * time.Hour)}}}}},
+			{"workspaces", "workspace", bson.D{}},
+			{"openReports", "report", bson.D{{"status", models.ReportOpen}}},
+		}
+
+		stats := gin.H{}
+		for _, count := range counts {
+			collection := database.OpenCollection(database.Client, count.collection)
+			n, err := collection.CountDocuments(ctx, count.filter)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+				log.Println(err)
+				return
+			}
+			stats[count.name] = n
+		}
+		stats["connectedClients"] = websocket.ConnectedClients()
+
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+// setSuspended suspends or lets the user back in, closing the websocket
+// connections of suspended users
+func setSuspended(ctx context.Context, userId primitive.ObjectID, suspended bool) error {
+	userCollection := database.OpenCollection(database.Client, "user")
+
+	update := bson.D{{"$set", bson.D{{"suspended", true}, {"updated_at", time.Now()}}}}
+	if !suspended {
+		update = bson.D{
+			{"$unset", bson.D{{"suspended", ""}}},
+			{"$set", bson.D{{"updated_at", time.Now()}}},
+		}
+	}
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", userId}}, update); err != nil {
+		return err
+	}
+
+	if suspended {
+		websocket.Disconnect(userId)
+	}
+	return nil
+}
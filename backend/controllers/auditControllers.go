@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetAuditLog lists audit entries, newest first. It can be narrowed with the
+// action, actor, target and chat query values and an after and before date.
+// Results are paginated with page and limit, the total is sent in
+// X-Total-Count
+func GetAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+		filter, ok := auditFilter(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		auditCollection := database.OpenCollection(database.Client, "audit")
+
+		total, err := auditCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{"created_at", -1}, {"_id", -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := auditCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		entries := []models.AuditEntry{}
+		if err := cursor.All(ctx, &entries); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// ExportAuditLog streams every audit entry matching the same query values as
+// GetAuditLog as JSON lines, oldest first
+func ExportAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := auditFilter(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		auditCollection := database.OpenCollection(database.Client, "audit")
+		opts := options.Find().SetSort(bson.D{{"created_at", 1}, {"_id", 1}})
+		cursor, err := auditCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		defer cursor.Close(ctx)
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		c.Status(http.StatusOK)
+
+		// the status is sent already, so a failure can only cut the export short
+		encoder := json.NewEncoder(c.Writer)
+		for cursor.Next(ctx) {
+			var entry models.AuditEntry
+			if err := cursor.Decode(&entry); err != nil {
+				log.Println("error while exporting audit log: ", err)
+				return
+			}
+			if err := encoder.Encode(entry); err != nil {
+				log.Println("error while exporting audit log: ", err)
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			log.Println("error while exporting audit log: ", err)
+		}
+	}
+}
+
+// auditFilter builds the query for audit entries from the query values,
+// writing an error response and returning false when they are invalid
+func auditFilter(c *gin.Context) (bson.D, bool) {
+	filter := bson.D{}
+	if action := c.Query("action"); action != "" {
+		filter = append(filter, bson.E{"action", action})
+	}
+	for _, key := range []string{"actor", "target", "chat"} {
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key + " id"})
+			return nil, false
+		}
+		filter = append(filter, bson.E{key, id})
+	}
+
+	after, ok := parseDateQuery(c, "after")
+	if !ok {
+		return nil, false
+	}
+	before, ok := parseDateQuery(c, "before")
+	if !ok {
+		return nil, false
+	}
+	created := bson.D{}
+	if !after.IsZero() {
+		created = append(created, bson.E{"$gte", after})
+	}
+	if !before.IsZero() {
+		created = append(created, bson.E{"$lt", before})
+	}
+	if len(created) > 0 {
+		filter = append(filter, bson.E{"created_at", created})
+	}
+	return filter, true
+}
+
+// recordAudit appends the entry to the audit log. The actor defaults to the
+// requesting user and the IP is the client's, as forwarded by the trusted
+// proxies only. Failures are only logged, as the action has already been
+// taken
+func recordAudit(ctx context.Context, c *gin.Context, entry models.AuditEntry) {
+	if entry.Actor.IsZero() {
+		if userId, ok := c.Get("_id"); ok {
+			entry.Actor = userId.(primitive.ObjectID)
+		}
+	}
+	entry.IP = c.ClientIP()
+	entry.Created_at = time.Now()
+
+	auditCollection := database.OpenCollection(database.Client, "audit")
+	if _, err := auditCollection.InsertOne(ctx, entry); err != nil {
+		log.Println("error while recording audit entry: ", err)
+	}
+}
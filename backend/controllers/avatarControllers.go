@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/avatar"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UploadAvatar receives an image in the "avatar" form field, crops it to the
+// optional x, y and size form values, and stores it in every avatar size
+func UploadAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, exists := c.Get("_id")
+		if !exists {
+			log.Panic("User details not available")
+		}
+		userId := id.(primitive.ObjectID)
+
+		// leave some room for the multipart envelope around the file
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatar.MaxUploadSize+1<<20)
+
+		fileHeader, err := c.FormFile("avatar")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "avatar file is required"})
+			return
+		}
+		if fileHeader.Size > avatar.MaxUploadSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "avatar file is too large"})
+			return
+		}
+
+		var crop avatar.Crop
+		for field, dst := range map[string]*int{"x": &crop.X, "y": &crop.Y, "size": &crop.Size} {
+			value := c.PostForm(field)
+			if value == "" {
+				continue
+			}
+			if *dst, err = strconv.Atoi(value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "crop values must be integers"})
+				return
+			}
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "error while reading avatar file"})
+			log.Println(err)
+			return
+		}
+		defer file.Close()
+
+		images, err := avatar.Process(file, crop)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		for size, data := range images {
+			if err := storage.Store.Put(ctx, avatar.Key(userId.Hex(), size), data); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error while storing avatar"})
+				log.Println(err)
+				return
+			}
+		}
+
+		// the version query busts caches holding the previous avatar
+		pic := fmt.Sprintf("%s?v=%d", avatar.URL(userId.Hex()), time.Now().Unix())
+		if err := setUserPic(ctx, userId, pic); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while updating user"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"pic": pic})
+	}
+}
+
+// DeleteAvatar removes the uploaded avatar, so the generated one is served again
+func DeleteAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, exists := c.Get("_id")
+		if !exists {
+			log.Panic("User details not available")
+		}
+		userId := id.(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		for _, size := range avatar.Sizes {
+			if err := storage.Store.Delete(ctx, avatar.Key(userId.Hex(), size)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error while deleting avatar"})
+				log.Println(err)
+				return
+			}
+		}
+
+		pic := fmt.Sprintf("%s?v=%d", avatar.URL(userId.Hex()), time.Now().Unix())
+		if err := setUserPic(ctx, userId, pic); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while updating user"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"pic": pic})
+	}
+}
+
+// GetAvatar serves the avatar of a user in the requested size. Users without
+// an uploaded avatar get a generated identicon, or their initials when
+// style=initials is given. It doesn't require a token so it can be used
+// directly as an image source
+func GetAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		size := avatar.DefaultSize
+		if s := c.Query("size"); s != "" {
+			if size, err = strconv.Atoi(s); err != nil || size <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "size must be a positive integer"})
+				return
+			}
+		}
+		size = avatar.NearestSize(size)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		c.Header("Cache-Control", "public, max-age=3600")
+
+		data, err := storage.Store.Get(ctx, avatar.Key(userId.Hex(), size))
+		if err == nil {
+			c.Data(http.StatusOK, "image/png", data)
+			return
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while reading avatar"})
+			log.Println(err)
+			return
+		}
+
+		if c.Query("style") == "initials" {
+			var user models.User
+			userCollection := database.OpenCollection(database.Client, "user")
+			err := userCollection.FindOne(ctx, bson.M{"_id": userId}).Decode(&user)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			} else if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+				log.Println(err)
+				return
+			}
+
+			c.Data(http.StatusOK, "image/svg+xml", avatar.Initials(user.Name, userId.Hex(), size))
+			return
+		}
+
+		data, err = avatar.Identicon(userId.Hex(), size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while generating avatar"})
+			log.Println(err)
+			return
+		}
+		c.Data(http.StatusOK, "image/png", data)
+	}
+}
+
+func setUserPic(ctx context.Context, userId primitive.ObjectID, pic string) error {
+	userCollection := database.OpenCollection(database.Client, "user")
+	update := bson.D{{"$set", bson.D{{"pic", pic}, {"updated_at", time.Now()}}}}
+	_, err := userCollection.UpdateOne(ctx, bson.D{{"_id", userId}}, update)
+	return err
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxTopicLength       = 250
+	maxDescriptionLength = 1000
+)
+
+// GetChannels lists the public group chats of the active workspace, optionally those whose name
+// contains the search text, with their member counts. Results are paginated
+// with page and limit, the total is sent in X-Total-Count
+func GetChannels() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.D{
+			{"isGroupChat", true},
+			{"visibility", models.VisibilityPublic},
+			workspaceScope(activeWorkspace(c)),
+		}
+		if query := searchText(strings.TrimSpace(c.Query("search"))); query != "" {
+			filter = append(filter, bson.E{"chatName", bson.D{
+				{"$regex", regexp.QuoteMeta(query)},
+				{"$options", "i"},
+			}})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chatCollection := database.OpenCollection(database.Client, "chat")
+
+		total, err := chatCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		matchStage := bson.D{{"$match", filter}}
+		sortStage := bson.D{{"$sort", bson.D{{"chatName", 1}, {"_id", 1}}}}
+		skipStage := bson.D{{"$skip", (page - 1) * limit}}
+		limitStage := bson.D{{"$limit", limit}}
+		projectStage := bson.D{
+			{
+				"$project", bson.D{
+					{"chatName", 1},
+					{"topic", 1},
+					{"description", 1},
+					{"members", bson.D{{"$size", "$users"}}},
+					{"isMember", bson.D{{"$in", bson.A{userId, "$users"}}}},
+				},
+			},
+		}
+
+		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, sortStage, skipStage, limitStage, projectStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// JoinChannel adds the user to a public group chat, no admin is involved
+func JoinChannel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := findChannel(ctx, c, chatId)
+		if !ok {
+			return
+		}
+
+		if chat.RoleOf(userId) != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You're already a member of this channel"})
+			return
+		}
+		if !requireWorkspaceMembers(ctx, c, chat.Workspace, userId) {
+			return
+		}
+
+		if err := addGroupMember(ctx, chatId, userId); errors.Is(err, errAlreadyMember) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You're already a member of this channel"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		postSystemMessage(ctx, chatId, models.SystemEvent{
+			Kind:  models.EventMemberJoined,
+			Actor: userId,
+		})
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMemberJoined, Target: userId, Chat: chatId, Details: "public channel"})
+
+		sendGroupChat(ctx, c, chatId)
+	}
+}
+
+// LeaveChannel removes the user from a public group chat
+func LeaveChannel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := findChannel(ctx, c, chatId)
+		if !ok {
+			return
+		}
+
+		if chat.RoleOf(userId) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You're not a member of this channel"})
+			return
+		}
+
+		exitGroup(ctx, c, chatId, userId)
+	}
+}
+
+// UpdateGroupInfo lets admins of a group set its topic, description and
+// visibility. Fields missing from the request are left unchanged
+func UpdateGroupInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		cId, ok := reqData["chatId"].(string)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chatId is required"})
+			return
+		}
+		chatId, err := primitive.ObjectIDFromHex(cId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin)
+		if !ok {
+			return
+		}
+
+		set := bson.D{}
+		var changed []string
+		for _, field := range []string{"topic", "description", "visibility"} {
+			value, exists := reqData[field]
+			if !exists {
+				continue
+			}
+			str, ok := value.(string)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": field + " must be a string"})
+				return
+			}
+			set = append(set, bson.E{field, str})
+			changed = append(changed, field)
+
+			switch field {
+			case "topic":
+				chat.Topic = str
+			case "description":
+				chat.Description = str
+			case "visibility":
+				chat.Visibility = str
+			}
+		}
+		if len(set) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+			return
+		}
+		if chat.Visibility == "" {
+			chat.Visibility = models.VisibilityPrivate
+		}
+		if msg := validateGroupInfo(chat.Visibility, chat.Topic, chat.Description); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, bson.D{{"$set", set}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		postSystemMessage(ctx, chatId, models.SystemEvent{
+			Kind:  models.EventGroupUpdated,
+			Actor: c.MustGet("_id").(primitive.ObjectID),
+			Value: strings.Join(changed, ", "),
+		})
+
+		sendGroupChat(ctx, c, chatId)
+	}
+}
+
+// validateGroupInfo returns a message describing what's wrong with the
+// group details, or "" when they are valid
+func validateGroupInfo(visibility, topic, description string) string {
+	if visibility != models.VisibilityPrivate && visibility != models.VisibilityPublic {
+		return "visibility must be private or public"
+	}
+	if len([]rune(topic)) > maxTopicLength {
+		return fmt.Sprintf("topic can be at most %d characters", maxTopicLength)
+	}
+	if len([]rune(description)) > maxDescriptionLength {
+		return fmt.Sprintf("description can be at most %d characters", maxDescriptionLength)
+	}
+	return ""
+}
+
+// findChannel loads a public group chat, writing an error response and
+// returning false when there's no such channel
+func findChannel(ctx context.Context, c *gin.Context, chatId primitive.ObjectID) (models.Chat, bool) {
+	var chat models.Chat
+
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	filter := bson.D{{"_id", chatId}, {"isGroupChat", true}, {"visibility", models.VisibilityPublic}}
+	err := chatCollection.FindOne(ctx, filter).Decode(&chat)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return chat, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return chat, false
+	}
+	return chat, true
+}
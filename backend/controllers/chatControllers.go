@@ -10,9 +10,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/pmohanj/web-chat-app/database"
 	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/search"
+	"github.com/pmohanj/web-chat-app/websocket"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // AddChatUser lets the user to add a user to chat with
@@ -45,8 +48,16 @@ func AddChatUser() gin.HandlerFunc {
 			log.Panic(err)
 		}
 
+		// direct chats stay within the active workspace
+		workspaceId := activeWorkspace(c)
+		if !requireWorkspaceMembers(ctx, c, workspaceId, addingUser, userToBeAdded) {
+			return
+		}
+
 		filter := bson.D{
 			{"isGroupChat", false},
+			workspaceScope(workspaceId),
+			{"deleted_at", bson.D{{"$exists", false}}},
 			{"$and",
 				bson.A{
 					bson.D{{"users", bson.D{{"$elemMatch", bson.D{{"$eq", addingUser}}}}}},
@@ -62,6 +73,8 @@ func AddChatUser() gin.HandlerFunc {
 			matchStage := bson.D{
 				{
 					"$match", bson.D{{"isGroupChat", false},
+						workspaceScope(workspaceId),
+						{"deleted_at", bson.D{{"$exists", false}}},
 						{"$and",
 							bson.A{
 								bson.D{{"users", bson.D{{"$elemMatch", bson.D{{"$eq", addingUser}}}}}},
@@ -75,7 +88,7 @@ func AddChatUser() gin.HandlerFunc {
 				"updated_at", "users.created_at", "users.updated_at")
 
 			var res []bson.M
-			cur, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+			cur, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("users")})
 			if err != nil {
 				log.Panic(err)
 			}
@@ -97,11 +110,17 @@ func AddChatUser() gin.HandlerFunc {
 			log.Panic(err)
 		}
 
-		// No chat existed, so create a chat for the users
+		// No chat existed, only create one if the other user accepts it
+		if !canStartDM(ctx, c, addingUser, userToBeAdded) {
+			return
+		}
+
+		// create a chat for the users
 		createChat := models.Chat{
 			ChatName:    "sender",
 			IsGroupChat: false,
 			Users:       []primitive.ObjectID{addingUser, userToBeAdded},
+			Workspace:   workspaceId,
 		}
 
 		insId, err := chatCollection.InsertOne(ctx, createChat)
@@ -121,7 +140,7 @@ func AddChatUser() gin.HandlerFunc {
 		projectStage := ProjectStage("users.password", "created_at",
 			"updated_at", "users.created_at", "users.updated_at")
 
-		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("users")})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "err while retreving created chat"})
 			log.Println(err)
@@ -150,30 +169,84 @@ func GetUserChats() gin.HandlerFunc {
 					{
 						"users", bson.D{{"$elemMatch", bson.D{{"$eq", userId}}}},
 					},
+					workspaceScope(activeWorkspace(c)),
+					{"deleted_at", bson.D{{"$exists", false}}},
 				},
 			},
 		}
 
 		lookupStage := LookUpStage("user", "users", "_id", "users")
 
+		latestMessagePipeline := bson.A{
+			bson.D{{"$match", bson.D{{"$expr", bson.D{{"$eq", bson.A{"$_id", "$$latestMessage"}}}}}}},
+		}
+		for _, stage := range tombstoneStage() {
+			latestMessagePipeline = append(latestMessagePipeline, stage)
+		}
 		lookupStageLatestMessage := bson.D{
 			{
 				"$lookup", bson.D{
 					{"from", "message"},
-					{"localField", "latestMessage"},
-					{"foreignField", "_id"},
+					{"let", bson.D{{"latestMessage", "$latestMessage"}}},
+					{"pipeline", latestMessagePipeline},
 					{"as", "latestMessage"},
 				},
 			},
 		}
 
+		// chats the user deleted for themselves come back with a new message
+		clearedField := "$cleared." + userId.Hex()
+		clearedStage := bson.D{
+			{
+				"$match", bson.D{{"$expr", bson.D{{"$or", bson.A{
+					bson.D{{"$not", bson.A{clearedField}}},
+					bson.D{{"$gt", bson.A{bson.D{{"$arrayElemAt", bson.A{"$latestMessage.created_at", 0}}}, clearedField}}},
+				}}}}},
+			},
+		}
+		clearedProjection := bson.D{{"$project", bson.D{{"cleared", 0}}}}
+
+		// how the user is notified of each chat, a mute that ended reads as
+		// not muted and chats without a setting get the defaults
+		now := time.Now()
+		settingsLookupStage := bson.D{
+			{
+				"$lookup", bson.D{
+					{"from", "notificationSetting"},
+					{"let", bson.D{{"chatId", "$_id"}}},
+					{"pipeline", bson.A{
+						bson.D{{"$match", bson.D{{"user", userId}, {"$expr", bson.D{{"$eq", bson.A{"$chat", "$$chatId"}}}}}}},
+						bson.D{{"$project", bson.D{
+							{"_id", 0},
+							{"level", bson.D{{"$ifNull", bson.A{"$level", models.NotifyAll}}}},
+							{"muted", bson.D{{"$and", bson.A{"$muted", bson.D{{"$or", bson.A{
+								bson.D{{"$not", bson.A{"$muted_until"}}},
+								bson.D{{"$gt", bson.A{"$muted_until", now}}},
+							}}}}}}},
+							{"muted_until", bson.D{{"$cond", bson.A{bson.D{{"$gt", bson.A{"$muted_until", now}}}, "$muted_until", "$$REMOVE"}}}},
+							{"keywords", bson.D{{"$ifNull", bson.A{"$keywords", bson.A{}}}}},
+						}}},
+					}},
+					{"as", "notificationSettings"},
+				},
+			},
+		}
+		settingsStage := bson.D{
+			{
+				"$addFields", bson.D{{"notificationSettings", bson.D{{"$ifNull", bson.A{
+					bson.D{{"$arrayElemAt", bson.A{"$notificationSettings", 0}}},
+					bson.D{{"level", models.NotifyAll}, {"muted", false}, {"keywords", bson.A{}}},
+				}}}}},
+			},
+		}
+
 		projectStage := ProjectStage("users.password", "created_at",
 			"updated_at", "users.created_at", "users.updated_at")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, lookupStageLatestMessage, projectStage})
+		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, lookupStageLatestMessage, clearedStage, settingsLookupStage, settingsStage, projectStage, PublicProfileStage("users"), clearedProjection})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
 			log.Panic(err)
@@ -191,41 +264,110 @@ func GetUserChats() gin.HandlerFunc {
 	}
 }
 
+// DeleteUserConversation deletes the chat for everyone, which for groups only
+// the owner can do, or with for=me clears it for the user only until a new
+// message arrives. Both can be undone within the undo window, chats deleted
+// for everyone are purged with their messages after the retention period
 func DeleteUserConversation() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		cId := c.Param("chatId")
 		chatId, err := primitive.ObjectIDFromHex(cId)
 		if err != nil {
-			log.Panic(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
 		}
+		scope, ok := deletionScope(c)
+		if !ok {
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		// delete all the messages that refer this chatId
-		messageCollection := database.OpenCollection(database.Client, "message")
+		chatCollection := database.OpenCollection(database.Client, "chat")
 
-		filter := bson.D{
-			{"chat", chatId},
+		var chat models.Chat
+		err = chatCollection.FindOne(ctx, bson.D{{"_id", chatId}, {"users", userId}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&chat)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
 		}
-		_, err = messageCollection.DeleteMany(ctx, filter)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting chat messages"})
-			log.Panic(err)
+
+		now := time.Now()
+		if scope == deleteForMe {
+			update := bson.D{{"$set", bson.D{{"cleared." + userId.Hex(), now}}}}
+			if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, update); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting chat"})
+				log.Println(err)
+				return
+			}
+			c.Status(http.StatusOK)
+			return
 		}
 
-		// delete the chat document too
-		chatCollection := database.OpenCollection(database.Client, "chat")
-		_, err = chatCollection.DeleteOne(ctx, bson.D{{"_id", chatId}})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting chat document"})
-			log.Panic(err)
+		if chat.IsGroupChat && chat.RoleOf(userId) != models.RoleOwner {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can delete the group for everyone"})
+			return
+		}
+
+		update := bson.D{{"$set", bson.D{{"deleted_at", now}, {"deletedBy", userId}}}}
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting chat"})
+			log.Println(err)
+			return
 		}
+		search.Messages.RemoveChat(chatId)
+
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditChatDeleted, Chat: chatId})
+		websocket.Publish(chatId.Hex(), map[string]interface{}{"messageType": "chatDeleted"})
+		emitWebhookEvent(models.EventChatDeleted, chatId, gin.H{"chatId": chatId, "deletedBy": userId})
 
 		c.Status(http.StatusOK)
 	}
 }
 
+// chatDocuments are the collections, besides messages, of documents that
+// belong to a chat and are deleted along with it
+var chatDocuments = []string{"messageVersion", "pin", "bookmark", "invite", "joinRequest"}
+
+// deleteChat permanently deletes the chat along with all of its messages and
+// the documents that belong to it
+func deleteChat(ctx context.Context, chatId primitive.ObjectID) error {
+	// delete all the messages that refer this chatId
+	messageCollection := database.OpenCollection(database.Client, "message")
+
+	filter := bson.D{
+		{"chat", chatId},
+	}
+	if _, err := messageCollection.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	for _, name := range chatDocuments {
+		if _, err := database.OpenCollection(database.Client, name).DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+	}
+
+	// delete the chat document too
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	if _, err := chatCollection.DeleteOne(ctx, bson.D{{"_id", chatId}}); err != nil {
+		return err
+	}
+	search.Messages.RemoveChat(chatId)
+
+	// its webhooks go with it, their deliveries expire from the log
+	webhookCollection := database.OpenCollection(database.Client, "webhook")
+	if _, err := webhookCollection.DeleteMany(ctx, bson.D{{"chat", chatId}}); err != nil {
+		return err
+	}
+	return nil
+}
+
 func CreateGroupChat() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var groupData map[string]interface{}
@@ -239,6 +381,17 @@ func CreateGroupChat() gin.HandlerFunc {
 
 		users := groupData["users"].([]interface{})
 
+		visibility, _ := groupData["visibility"].(string)
+		if visibility == "" {
+			visibility = models.VisibilityPrivate
+		}
+		topic, _ := groupData["topic"].(string)
+		description, _ := groupData["description"].(string)
+		if msg := validateGroupInfo(visibility, topic, description); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
 		aUser, exists := c.Get("_id")
 		if !exists {
 			log.Panic("User details not available")
@@ -258,16 +411,34 @@ func CreateGroupChat() gin.HandlerFunc {
 			usersIds = append(usersIds, temp)
 		}
 
+		// the creator owns the group, everyone else joins as a member
+		roles := make(map[string]string, len(usersIds))
+		for _, id := range usersIds {
+			roles[id.Hex()] = models.RoleMember
+		}
+		roles[adminUser.Hex()] = models.RoleOwner
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// the group belongs to the active workspace, along with all its users
+		workspaceId := activeWorkspace(c)
+		if !requireWorkspaceMembers(ctx, c, workspaceId, usersIds...) {
+			return
+		}
+
 		groupChat := models.Chat{
 			IsGroupChat: true,
 			ChatName:    groupName,
 			Users:       usersIds,
 			GroupAdmin:  adminUser,
+			Roles:       roles,
+			Visibility:  visibility,
+			Topic:       topic,
+			Description: description,
+			Workspace:   workspaceId,
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
 		chatCollection := database.OpenCollection(database.Client, "chat")
 
 		insId, err := chatCollection.InsertOne(ctx, groupChat)
@@ -278,6 +449,12 @@ func CreateGroupChat() gin.HandlerFunc {
 
 		insertedId := insId.InsertedID.(primitive.ObjectID)
 
+		postSystemMessage(ctx, insertedId, models.SystemEvent{
+			Kind:  models.EventGroupCreated,
+			Actor: adminUser,
+			Value: groupName,
+		})
+
 		matchStage := MatchStageBySingleField("_id", insertedId)
 
 		lookupStage := LookUpStage("user", "users", "_id", "users")
@@ -285,7 +462,7 @@ func CreateGroupChat() gin.HandlerFunc {
 		projectStage := ProjectStage("users.password", "created_at",
 			"updated_at", "users.created_at", "users.updated_at")
 
-		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("users")})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
 			log.Panic(err)
@@ -317,21 +494,31 @@ func RenameGroupChatName() gin.HandlerFunc {
 			log.Panic(err)
 		}
 
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+
 		chatCollection := database.OpenCollection(database.Client, "chat")
 
 		filter := bson.D{{"_id", chatId}}
 
 		update := bson.D{{"$set", bson.D{{"chatName", groupName}}}}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
 		_, err = chatCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
 			log.Panic(err)
 		}
 
+		postSystemMessage(ctx, chatId, models.SystemEvent{
+			Kind:  models.EventGroupRenamed,
+			Actor: c.MustGet("_id").(primitive.ObjectID),
+			Value: groupName,
+		})
+
 		c.JSON(http.StatusOK, gin.H{"updatedGroupName": groupName})
 	}
 }
@@ -358,21 +545,41 @@ func AddUserToGroupChat() gin.HandlerFunc {
 			log.Panic(err)
 		}
 
-		chatCollection := database.OpenCollection(database.Client, "chat")
-
-		filter := bson.D{{"_id", chatId}}
-
-		update := bson.D{{"$push", bson.D{{"users", userId}}}}
-
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		_, err = chatCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
+		// only admins and the owner can add users
+		chat, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin)
+		if !ok {
+			return
+		}
+		if chat.RoleOf(userId) != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User is already a member of this group"})
+			return
+		}
+		if !requireWorkspaceMembers(ctx, c, chat.Workspace, userId) {
+			return
+		}
+
+		chatCollection := database.OpenCollection(database.Client, "chat")
+
+		err = addGroupMember(ctx, chatId, userId)
+		if errors.Is(err, errAlreadyMember) {
+			// added concurrently
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User is already a member of this group"})
+			return
+		} else if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
 			log.Panic(err)
 		}
 
+		postSystemMessage(ctx, chatId, models.SystemEvent{
+			Kind:   models.EventMemberAdded,
+			Actor:  c.MustGet("_id").(primitive.ObjectID),
+			Target: userId,
+		})
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMemberAdded, Target: userId, Chat: chatId})
+
 		// User is added to group, now retrieve that document and send into client
 		// so that client can update its data, and perfrom necessary rendering
 		matchStage := MatchStageBySingleField("_id", chatId)
@@ -382,7 +589,7 @@ func AddUserToGroupChat() gin.HandlerFunc {
 		projectStage := ProjectStage("users.password", "created_at",
 			"updated_at", "users.created_at", "users.updated_at")
 
-		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("users")})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
 			log.Panic(err)
@@ -424,14 +631,34 @@ func DeleteUserFromGroupChat() gin.HandlerFunc {
 			log.Panic(err)
 		}
 
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin)
+		if !ok {
+			return
+		}
+
+		if chat.RoleOf(userId) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User is not a member of this group"})
+			return
+		}
+
+		// admins can remove members, only the owner can remove admins
+		actorId := c.MustGet("_id").(primitive.ObjectID)
+		if !models.CanManage(chat.RoleOf(actorId), chat.RoleOf(userId)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can't remove this user from the group"})
+			return
+		}
+
 		chatCollection := database.OpenCollection(database.Client, "chat")
 
 		filter := bson.D{{"_id", chatId}}
 
-		update := bson.D{{"$pull", bson.D{{"users", userId}}}}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+		update := bson.D{
+			{"$pull", bson.D{{"users", userId}}},
+			{"$unset", bson.D{{"roles." + userId.Hex(), ""}}},
+		}
 
 		res, err := chatCollection.UpdateOne(ctx, filter, update)
 		if err != nil {
@@ -439,6 +666,14 @@ func DeleteUserFromGroupChat() gin.HandlerFunc {
 			log.Panic(err)
 		}
 		log.Printf("Docu up %v", res.ModifiedCount)
+		websocket.Unsubscribe(userId, chatId)
+
+		postSystemMessage(ctx, chatId, models.SystemEvent{
+			Kind:   models.EventMemberRemoved,
+			Actor:  actorId,
+			Target: userId,
+		})
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMemberRemoved, Target: userId, Chat: chatId})
 		// User is added to group, now retrieve that document and send into client
 		// so that client can update its data, and perfrom necessary rendering
 		matchStage := MatchStageBySingleField("_id", chatId)
@@ -448,7 +683,7 @@ func DeleteUserFromGroupChat() gin.HandlerFunc {
 		projectStage := ProjectStage("users.password", "created_at",
 			"updated_at", "users.created_at", "users.updated_at")
 
-		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("users")})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
 			log.Panic(err)
@@ -467,8 +702,7 @@ func DeleteUserFromGroupChat() gin.HandlerFunc {
 
 }
 
-// UserExitGroup removes a user from Group chat or deletes the whole
-// chat if admin of that group is exiting
+// UserExitGroup removes a user from Group chat
 func UserExitGroup() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqData map[string]interface{}
@@ -491,45 +725,329 @@ func UserExitGroup() gin.HandlerFunc {
 
 		userId := uId.(primitive.ObjectID)
 
-		// get chat collection
-		chatCollection := database.OpenCollection(database.Client, "chat")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
 
-		filter := bson.D{{"_id", chatId}}
+		exitGroup(ctx, c, chatId, userId)
+	}
+}
+
+// exitGroup removes the user from the group chat and writes the response
+func exitGroup(ctx context.Context, c *gin.Context, chatId, userId primitive.ObjectID) {
+	// get chat collection
+	chatCollection := database.OpenCollection(database.Client, "chat")
+
+	var chat models.Chat
+	err := chatCollection.FindOne(ctx, bson.D{{"_id", chatId}}).Decode(&chat)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return
+	} else if err != nil {
+		log.Panic(err)
+	}
+
+	if chat.RoleOf(userId) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You're not a member of this group"})
+		return
+	}
+
+	if err := leaveChat(ctx, chat, userId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+		log.Println(err)
+		return
+	}
+	recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMemberLeft, Target: userId, Chat: chatId})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exited from group"})
+}
+
+// leaveChat removes the user from the chat. When the owner exits a group, it's
+// handed over to the earliest added admin, or member if there is no admin,
+// and it's deleted only when nobody else is left
+func leaveChat(ctx context.Context, chat models.Chat, userId primitive.ObjectID) error {
+	chatCollection := database.OpenCollection(database.Client, "chat")
+
+	filter := bson.D{{"_id", chat.Id}}
+
+	update := bson.D{
+		{"$pull", bson.D{{"users", userId}}},
+		{"$unset", bson.D{{"roles." + userId.Hex(), ""}}},
+	}
+
+	// check if owner is exiting Group chat
+	var newOwner primitive.ObjectID
+	if chat.IsGroupChat && chat.RoleOf(userId) == models.RoleOwner {
+		var found bool
+		newOwner, found = chat.NextOwner()
+		if !found {
+			// nobody is left, delete the whole chat with its messages
+			if err := deleteChat(ctx, chat.Id); err != nil {
+				return err
+			}
+			websocket.Unsubscribe(userId, chat.Id)
+			return nil
+		}
+
+		update = append(update, bson.E{"$set", bson.D{
+			{"groupAdmin", newOwner},
+			{"roles." + newOwner.Hex(), models.RoleOwner},
+		}})
+	}
+
+	res, err := chatCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	log.Printf("Documents deleted: %v", res.ModifiedCount)
+	websocket.Unsubscribe(userId, chat.Id)
+
+	if chat.IsGroupChat {
+		postSystemMessage(ctx, chat.Id, models.SystemEvent{
+			Kind:  models.EventMemberLeft,
+			Actor: userId,
+		})
+		if !newOwner.IsZero() {
+			postSystemMessage(ctx, chat.Id, models.SystemEvent{
+				Kind:   models.EventOwnerChanged,
+				Actor:  userId,
+				Target: newOwner,
+			})
+		}
+	}
+	return nil
+}
+
+// ChangeGroupRole makes a group member an admin or an admin a member again.
+// Only the owner can change roles
+func ChangeGroupRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		uId, ok1 := reqData["userId"].(string)
+		cId, ok2 := reqData["chatId"].(string)
+		role, ok3 := reqData["role"].(string)
+		if !ok1 || !ok2 || !ok3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "userId, chatId and role are required"})
+			return
+		}
+		if role != models.RoleAdmin && role != models.RoleMember {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be admin or member"})
+			return
+		}
+
+		chatId, err := primitive.ObjectIDFromHex(cId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		userId, err := primitive.ObjectIDFromHex(uId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		var chatDocu bson.M
-		err = chatCollection.FindOne(ctx, filter).Decode(&chatDocu)
-		if err != nil {
-			log.Panic(err)
+		chat, ok := requireGroupRole(ctx, c, chatId, models.RoleOwner)
+		if !ok {
+			return
 		}
 
-		groupAdmin := chatDocu["groupAdmin"].(primitive.ObjectID)
+		current := chat.RoleOf(userId)
+		if current == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of the group"})
+			return
+		}
+		if current == models.RoleOwner {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer the ownership to change your role"})
+			return
+		}
 
-		// check if admin is exiting Group chat
-		if userId.Hex() == groupAdmin.Hex() {
-			// delete the whole chat
-			deleteResult, err := chatCollection.DeleteOne(ctx, filter)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while querying database"})
-				log.Panic(err)
-			}
-			log.Println("Documents deleted: ", deleteResult.DeletedCount)
-			c.JSON(http.StatusOK, gin.H{"message": "Exited from group"})
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		update := bson.D{{"$set", bson.D{{"roles." + userId.Hex(), role}}}}
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
 			return
 		}
 
-		// just remove the user from Group chat
-		update := bson.D{{"$pull", bson.D{{"users", userId}}}}
+		postSystemMessage(ctx, chatId, models.SystemEvent{
+			Kind:   models.EventRoleChanged,
+			Actor:  c.MustGet("_id").(primitive.ObjectID),
+			Target: userId,
+			Value:  role,
+		})
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditRoleChanged, Target: userId, Chat: chatId, Details: role})
 
-		res, err := chatCollection.UpdateOne(ctx, filter, update)
+		sendGroupChat(ctx, c, chatId)
+	}
+}
+
+// TransferGroupOwnership hands the group over to another member, the
+// previous owner stays on as an admin
+func TransferGroupOwnership() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		uId, ok1 := reqData["userId"].(string)
+		cId, ok2 := reqData["chatId"].(string)
+		if !ok1 || !ok2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "userId and chatId are required"})
+			return
+		}
+
+		chatId, err := primitive.ObjectIDFromHex(cId)
 		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		newOwner, err := primitive.ObjectIDFromHex(uId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := requireGroupRole(ctx, c, chatId, models.RoleOwner)
+		if !ok {
+			return
+		}
+
+		owner := c.MustGet("_id").(primitive.ObjectID)
+		if newOwner == owner {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You already own the group"})
+			return
+		}
+		if chat.RoleOf(newOwner) == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of the group"})
+			return
+		}
+
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		update := bson.D{{"$set", bson.D{
+			{"groupAdmin", newOwner},
+			{"roles." + newOwner.Hex(), models.RoleOwner},
+			{"roles." + owner.Hex(), models.RoleAdmin},
+		}}}
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, update); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
-			log.Panic(err)
+			log.Println(err)
+			return
 		}
-		log.Printf("Documents deleted: %v", res.ModifiedCount)
 
-		c.JSON(http.StatusOK, gin.H{"message": "Exited from group"})
+		postSystemMessage(ctx, chatId, models.SystemEvent{
+			Kind:   models.EventOwnerChanged,
+			Actor:  owner,
+			Target: newOwner,
+		})
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditOwnerChanged, Target: newOwner, Chat: chatId})
+
+		sendGroupChat(ctx, c, chatId)
+	}
+}
+
+// requireGroupRole loads the group chat and checks the requesting user has at
+// least the given role in it. Otherwise it writes an error response and
+// returns false
+func requireGroupRole(ctx context.Context, c *gin.Context, chatId primitive.ObjectID, role string) (models.Chat, bool) {
+	userId := c.MustGet("_id").(primitive.ObjectID)
+
+	var chat models.Chat
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	err := chatCollection.FindOne(ctx, bson.D{{"_id", chatId}}).Decode(&chat)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return chat, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return chat, false
+	}
+
+	if !chat.IsGroupChat {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chat is not a group chat"})
+		return chat, false
+	}
+	if models.RoleRank(chat.RoleOf(userId)) < models.RoleRank(role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this in the group"})
+		return chat, false
+	}
+	return chat, true
+}
+
+// sendGroupChat responds with the chat joined with its users profiles, so
+// that client can update its data
+func sendGroupChat(ctx context.Context, c *gin.Context, chatId primitive.ObjectID) {
+	chatCollection := database.OpenCollection(database.Client, "chat")
+
+	matchStage := MatchStageBySingleField("_id", chatId)
+
+	lookupStage := LookUpStage("user", "users", "_id", "users")
+
+	projectStage := ProjectStage("users.password", "created_at",
+		"updated_at", "users.created_at", "users.updated_at")
+
+	cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("users")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return
+	}
+
+	var results []bson.M
+	if err := cursor.All(ctx, &results); err != nil || len(results) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, results[0])
+}
+
+// isChatMember reports whether the user is one of the users of the chat,
+// chats deleted for everyone have no members
+func isChatMember(ctx context.Context, chatId, userId primitive.ObjectID) (bool, error) {
+	chatCollection := database.OpenCollection(database.Client, "chat")
+
+	count, err := chatCollection.CountDocuments(ctx, bson.D{{"_id", chatId}, {"users", userId}, {"deleted_at", bson.D{{"$exists", false}}}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// getUserChatIds returns the ids of every chat of the workspace the user is
+// a member of, leaving out chats deleted for everyone
+func getUserChatIds(ctx context.Context, userId, workspaceId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	chatCollection := database.OpenCollection(database.Client, "chat")
+
+	filter := bson.D{{"users", userId}, workspaceScope(workspaceId), {"deleted_at", bson.D{{"$exists", false}}}}
+	cursor, err := chatCollection.Find(ctx, filter, options.Find().SetProjection(bson.D{{"_id", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var chats []models.Chat
+	if err := cursor.All(ctx, &chats); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(chats))
+	for i, chat := range chats {
+		ids[i] = chat.Id
 	}
+	return ids, nil
 }
@@ -0,0 +1,161 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestAdmin(t *testing.T) {
+	setAdmin(t, user0Id, true)
+	defer setAdmin(t, user0Id, false)
+
+	input := []byte(`{"name":"Suspendee", "email":"suspendee@gmail.com", "password":"haha123"}`)
+	request, _ := http.NewRequest("POST", "/api/user/", bytes.NewBuffer(input))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	var suspendee map[string]string
+	_ = json.NewDecoder(response.Body).Decode(&suspendee)
+	suspendeeId, suspendeeToken := suspendee["_id"], suspendee["token"]
+
+	t.Run("returns forbidden for non admin", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/admin/stats", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns users matching search", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/admin/users?search=suspendee", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "1", response.Header().Get("X-Total-Count"))
+		assert.Equal(t, suspendeeId, result[0]["_id"])
+		assert.Equal(t, nil, result[0]["password"])
+	})
+
+	t.Run("returns error suspending admin", func(t *testing.T) {
+		request, _ := http.NewRequest("PUT", fmt.Sprintf("/api/admin/users/%s/suspend", user0Id), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("suspended user is locked out", func(t *testing.T) {
+		request, _ := http.NewRequest("PUT", fmt.Sprintf("/api/admin/users/%s/suspend", suspendeeId), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("GET", "/api/chat/", nil)
+		request.Header.Set("Authorization", "Bearer "+suspendeeToken)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		input := []byte(`{"email":"suspendee@gmail.com", "password":"haha123"}`)
+		request, _ = http.NewRequest("POST", "/api/user/login", bytes.NewBuffer(input))
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("unsuspended user is let back in", func(t *testing.T) {
+		request, _ := http.NewRequest("PUT", fmt.Sprintf("/api/admin/users/%s/unsuspend", suspendeeId), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("GET", "/api/chat/", nil)
+		request.Header.Set("Authorization", "Bearer "+suspendeeToken)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("forced password reset revokes tokens until reset", func(t *testing.T) {
+		// tokens carry their issue time in seconds
+		time.Sleep(time.Second)
+
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%s/reset", suspendeeId), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+		assert.Equal(t, http.StatusOK, response.Code)
+		resetToken, _ := result["resetToken"].(string)
+
+		request, _ = http.NewRequest("GET", "/api/chat/", nil)
+		request.Header.Set("Authorization", "Bearer "+suspendeeToken)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		input := []byte(`{"email":"suspendee@gmail.com", "password":"haha123"}`)
+		request, _ = http.NewRequest("POST", "/api/user/login", bytes.NewBuffer(input))
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		input = []byte(fmt.Sprintf(`{"email":"suspendee@gmail.com", "token":"%s", "password":"newpass123"}`, resetToken))
+		request, _ = http.NewRequest("POST", "/api/user/password/reset", bytes.NewBuffer(input))
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		input = []byte(`{"email":"suspendee@gmail.com", "password":"newpass123"}`)
+		request, _ = http.NewRequest("POST", "/api/user/login", bytes.NewBuffer(input))
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("returns stats", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/admin/stats", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.NotEqual(t, nil, result["users"])
+		assert.NotEqual(t, nil, result["connectedClients"])
+	})
+}
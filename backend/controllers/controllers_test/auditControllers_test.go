@@ -0,0 +1,80 @@
+package controllers_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestAuditLog(t *testing.T) {
+	setAdmin(t, user0Id, true)
+	defer setAdmin(t, user0Id, false)
+
+	input := []byte(`{"email":"user1@gmail.com", "password":"wrongpass"}`)
+	request, _ := http.NewRequest("POST", "/api/user/login", bytes.NewBuffer(input))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	t.Run("returns forbidden for non admin", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/admin/audit", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns failed logins of user", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("/api/admin/audit?action=login.failed&target=%s", user1Id), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "login.failed", result[0]["action"])
+		assert.Equal(t, user1Id, result[0]["target"])
+		assert.NotEqual(t, "", result[0]["ip"])
+	})
+
+	t.Run("returns error for invalid actor", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/admin/audit?actor=nope", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("exports entries as json lines", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/admin/audit/export?action=login.failed", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/x-ndjson", response.Header().Get("Content-Type"))
+
+		lines := 0
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			var entry map[string]interface{}
+			assert.Equal(t, nil, json.Unmarshal(scanner.Bytes(), &entry))
+			assert.Equal(t, "login.failed", entry["action"])
+			lines++
+		}
+		assert.NotEqual(t, 0, lines)
+	})
+}
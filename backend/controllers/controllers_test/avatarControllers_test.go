@@ -0,0 +1,108 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+// avatarUploadRequest builds a multipart request carrying the given file as avatar
+func avatarUploadRequest(file []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("avatar", "avatar.png")
+	part.Write(file)
+	writer.Close()
+
+	request, _ := http.NewRequest("POST", "/api/user/avatar", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Set("Authorization", "Bearer "+user1Token)
+	return request
+}
+
+func TestUploadAvatar(t *testing.T) {
+
+	t.Run("returns invalid image error", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, avatarUploadRequest([]byte("not an image")))
+
+		var res map[string]string
+		_ = json.NewDecoder(response.Body).Decode(&res)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		if res["error"] != "image must be a jpeg, png or gif" {
+			t.Errorf("Unexpected result: got %v, want %v", res["error"], "image must be a jpeg, png or gif")
+		}
+	})
+
+	t.Run("returns uploaded avatar url", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+		for y := 0; y < 200; y++ {
+			for x := 0; x < 300; x++ {
+				img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+			}
+		}
+		var file bytes.Buffer
+		_ = png.Encode(&file, img)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, avatarUploadRequest(file.Bytes()))
+
+		var res map[string]string
+		_ = json.NewDecoder(response.Body).Decode(&res)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if res["pic"] == "" {
+			t.Errorf("Unexpected result: pic field can't be empty")
+		}
+	})
+}
+
+func TestGetAvatar(t *testing.T) {
+
+	t.Run("returns generated avatar", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/user/avatar/"+user2Id+"?size=64", nil)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		img, format, err := image.Decode(response.Body)
+		if err != nil || format != "png" {
+			t.Fatalf("Unexpected result: got %v %v, want a png image", format, err)
+		}
+		if img.Bounds().Dx() != 64 {
+			t.Errorf("Unexpected result: got %v, want %v", img.Bounds().Dx(), 64)
+		}
+	})
+
+	t.Run("returns initials avatar", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/user/avatar/"+user2Id+"?style=initials", nil)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "image/svg+xml", response.Header().Get("Content-Type"))
+	})
+
+	t.Run("returns invalid user id error", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/user/avatar/notanid", nil)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -0,0 +1,93 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestChannels(t *testing.T) {
+	var channelId string
+
+	t.Run("returns created public channel", func(t *testing.T) {
+		input := []byte(`{"groupName":"Public testing channel", "users":[], "visibility":"public", "topic":"testing"}`)
+		request, _ := http.NewRequest("POST", "/api/chat/group", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if result["visibility"] != "public" {
+			t.Errorf("Unexpected result: got %v, want %v", result["visibility"], "public")
+		}
+		channelId, _ = result["_id"].(string)
+	})
+
+	t.Run("returns channel in directory", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/chat/channels?search=testing%20CHANNEL", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 {
+			t.Fatalf("Unexpected result: got %v, want %v", len(result), 1)
+		}
+		if result[0]["members"] != float64(1) || result[0]["isMember"] != false {
+			t.Errorf("Unexpected result: got %v, want 1 member and isMember false", result[0])
+		}
+	})
+
+	t.Run("returns channel after joining", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/channels/%s/join", channelId), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("returns permission error for members updating info", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "topic":"changed"}`, channelId)
+		request, _ := http.NewRequest("PUT", "/api/chat/groupinfo", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns exited after leaving", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/channels/%s/leave", channelId), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]string
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if result["message"] != "Exited from group" {
+			t.Errorf("Unexpected result: got %v, want %v", result["message"], "Exited from group")
+		}
+	})
+}
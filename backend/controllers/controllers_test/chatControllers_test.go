@@ -52,6 +52,21 @@ func TestGetUserChats(t *testing.T) {
 		if len(result) < 1 {
 			t.Errorf("Unexpected result: got %v, want %v", len(result), "at least 1 chat document")
 		}
+
+		// users of chats only have their public profile
+		for _, chat := range result {
+			users, _ := chat["users"].([]interface{})
+			for _, u := range users {
+				user, _ := u.(map[string]interface{})
+				for field := range user {
+					switch field {
+					case "_id", "name", "email", "pic":
+					default:
+						t.Errorf("Unexpected result: private field %v of chat user returned", field)
+					}
+				}
+			}
+		}
 	})
 }
 
@@ -65,6 +80,69 @@ func TestDeleteUserConversation(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, response.Code)
 	})
+
+	t.Run("returns error restoring chat deleted by other user", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", "/api/chat/"+chatIdDelete+"/restore", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("restored chat is listed again", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", "/api/chat/"+chatIdDelete+"/restore", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		assert.Equal(t, true, userChatListed(t, user0Token, chatIdDelete))
+	})
+
+	t.Run("chat deleted for me is hidden from that user only", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/chat/"+chatIdDelete+"?for=me", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		assert.Equal(t, false, userChatListed(t, user0Token, chatIdDelete))
+		assert.Equal(t, true, userChatListed(t, user1Token, chatIdDelete))
+	})
+
+	t.Run("returns status ok deleting conversation again", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/chat/"+chatIdDelete, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, false, userChatListed(t, user1Token, chatIdDelete))
+	})
+}
+
+// userChatListed reports whether the chat is in the chat list of the user
+func userChatListed(t *testing.T, token, chatId string) bool {
+	request, _ := http.NewRequest("GET", "/api/chat/", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	var result []map[string]interface{}
+	_ = json.NewDecoder(response.Body).Decode(&result)
+
+	for _, chat := range result {
+		if chat["_id"] == chatId {
+			return true
+		}
+	}
+	return false
 }
 
 func TestCreateGroupChat(t *testing.T) {
@@ -92,6 +170,9 @@ func TestCreateGroupChat(t *testing.T) {
 		if result["isGroupChat"] != expectedChatLabel {
 			t.Errorf("Unexpected result: got %v, want %v", result["isGroupChat"], expectedChatLabel)
 		}
+
+		// the group is exited in TestUserExitGroup
+		chatIdExit, _ = result["_id"].(string)
 	})
 }
 
@@ -166,6 +247,17 @@ func TestAddUserToGroupChat(t *testing.T) {
 			t.Errorf("Unexpected result: got %v, want %v", nil, user0Id)
 		}
 	})
+
+	t.Run("returns error for adding the owner again", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userId":"%s", "chatId":"%s"}`, user1Id, chatIdGroup)
+		request, _ := http.NewRequest("PUT", "/api/chat/groupadd", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
 }
 
 func TestDeleteUserFromGroupChat(t *testing.T) {
@@ -211,11 +303,23 @@ func TestDeleteUserFromGroupChat(t *testing.T) {
 			t.Errorf("Unexpected result: got %v, want %v", expectedRemovedUserId, nil)
 		}
 	})
+
+	t.Run("returns error for users who aren't members", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userId":"%s", "chatId":"%s"}`, user2Id, chatIdGroup)
+		input := []byte(data)
+		request, _ := http.NewRequest("PUT", "/api/chat/groupremove", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
 }
 
 func TestUserExitGroup(t *testing.T) {
 	t.Run("returns exited from group", func(t *testing.T) {
-		data := fmt.Sprintf(`{"chatId":"%s"}`, chatId)
+		data := fmt.Sprintf(`{"chatId":"%s"}`, chatIdExit)
 		input := []byte(data)
 		request, _ := http.NewRequest("PUT", "/api/chat/groupexit", bytes.NewBuffer(input))
 		request.Header.Set("Authorization", "Bearer "+user1Token)
@@ -234,4 +338,82 @@ func TestUserExitGroup(t *testing.T) {
 			t.Errorf("Unexpected result: got %v, want %v", result["message"], expectedMessage)
 		}
 	})
+
+	t.Run("returns error for users who aren't members", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s"}`, chatIdExit)
+		input := []byte(data)
+		request, _ := http.NewRequest("PUT", "/api/chat/groupexit", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestChangeGroupRole(t *testing.T) {
+	t.Run("returns permission error for non members", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userId":"%s", "chatId":"%s", "role":"admin"}`, user0Id, chatIdGroup)
+		input := []byte(data)
+		request, _ := http.NewRequest("PUT", "/api/chat/grouprole", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns group with promoted admin", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userId":"%s", "chatId":"%s", "role":"admin"}`, user0Id, chatIdGroup)
+		input := []byte(data)
+		request, _ := http.NewRequest("PUT", "/api/chat/grouprole", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		roles, ok := result["roles"].(map[string]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		if roles[user0Id] != "admin" {
+			t.Errorf("Unexpected result: got %v, want %v", roles[user0Id], "admin")
+		}
+	})
+}
+
+func TestTransferGroupOwnership(t *testing.T) {
+	t.Run("returns group with new owner", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userId":"%s", "chatId":"%s"}`, user0Id, chatIdGroup)
+		input := []byte(data)
+		request, _ := http.NewRequest("PUT", "/api/chat/groupowner", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if result["groupAdmin"] != user0Id {
+			t.Errorf("Unexpected result: got %v, want %v", result["groupAdmin"], user0Id)
+		}
+
+		roles, ok := result["roles"].(map[string]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		if roles[user0Id] != "owner" || roles[user1Id] != "admin" {
+			t.Errorf("Unexpected result: got %v, want owner and admin roles", roles)
+		}
+	})
 }
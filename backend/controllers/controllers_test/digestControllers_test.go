@@ -0,0 +1,88 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/pmohanj/web-chat-app/digest"
+	"github.com/pmohanj/web-chat-app/helpers"
+)
+
+func TestDigestSettings(t *testing.T) {
+	getSettings := func() map[string]interface{} {
+		request, _ := http.NewRequest("GET", "/api/user/digest", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+		assert.Equal(t, http.StatusOK, response.Code)
+		return result
+	}
+
+	t.Run("returns daily digests by default", func(t *testing.T) {
+		assert.Equal(t, "daily", getSettings()["frequency"])
+	})
+
+	t.Run("returns error for invalid settings", func(t *testing.T) {
+		for _, data := range []string{
+			`{"frequency":"hourly"}`,
+			`{"frequency":"daily", "quietStart":"22:00"}`,
+			`{"frequency":"daily", "quietStart":"22:00", "quietEnd":"25:00"}`,
+			`{"frequency":"daily", "timezone":"Mars/Olympus_Mons"}`,
+		} {
+			request, _ := http.NewRequest("PUT", "/api/user/digest", bytes.NewBuffer([]byte(data)))
+			request.Header.Set("Authorization", "Bearer "+user1Token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			assert.Equal(t, http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("updates settings", func(t *testing.T) {
+		data := `{"frequency":"weekly", "quietStart":"22:00", "quietEnd":"07:00", "timezone":"Europe/Berlin"}`
+		request, _ := http.NewRequest("PUT", "/api/user/digest", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		settings := getSettings()
+		assert.Equal(t, "weekly", settings["frequency"])
+		assert.Equal(t, "22:00", settings["quietStart"])
+		assert.Equal(t, "07:00", settings["quietEnd"])
+		assert.Equal(t, "Europe/Berlin", settings["timezone"])
+	})
+
+	t.Run("returns error for unsubscribe link with wrong signature", func(t *testing.T) {
+		query := url.Values{"user": {user1Id}, "sig": {digest.Sign("not the secret", user1Id)}}
+		request, _ := http.NewRequest("POST", "/api/user/digest/unsubscribe?"+query.Encode(), nil)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+		assert.Equal(t, "weekly", getSettings()["frequency"])
+	})
+
+	t.Run("unsubscribes through signed link", func(t *testing.T) {
+		link, _ := url.Parse(digest.UnsubscribeURL("", helpers.SECRET_KEY, user1Id))
+		request, _ := http.NewRequest("GET", link.String(), nil)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "off", getSettings()["frequency"])
+	})
+}
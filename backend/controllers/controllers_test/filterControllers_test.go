@@ -0,0 +1,92 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestChatFilters(t *testing.T) {
+	var groupId string
+
+	t.Run("returns updated filters", func(t *testing.T) {
+		data := fmt.Sprintf(`{"groupName":"Filtered group", "users":["%s"]}`, user2Id)
+		request, _ := http.NewRequest("POST", "/api/chat/group", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var group map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&group)
+		groupId, _ = group["_id"].(string)
+
+		input := []byte(`{"filters":[
+			{"type":"bannedWords", "action":"reject", "words":["darn"]},
+			{"type":"links", "action":"redact", "deny":["spam.example"]}
+		]}`)
+		request, _ = http.NewRequest("PUT", fmt.Sprintf("/api/chat/%s/filters", groupId), bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string][]map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 2, len(result["filters"]))
+	})
+
+	t.Run("returns error for invalid filters", func(t *testing.T) {
+		input := []byte(`{"filters":[{"type":"maxLength", "action":"reject"}]}`)
+		request, _ := http.NewRequest("PUT", fmt.Sprintf("/api/chat/%s/filters", groupId), bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns permission error for members", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("/api/chat/%s/filters", groupId), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns rejection for banned words", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"Darn this"}`, groupId)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns message with denied links removed", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"Deals at https://spam.example/deal"}`, groupId)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "Deals at [link removed]", result["content"])
+	})
+}
@@ -0,0 +1,132 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+// createInvite creates an invite for the testing group as user1
+func createInvite(t *testing.T, body string) map[string]interface{} {
+	request, _ := http.NewRequest("POST", "/api/chat/"+chatIdGroup+"/invites", bytes.NewBuffer([]byte(body)))
+	request.Header.Set("Authorization", "Bearer "+user1Token)
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	var result map[string]interface{}
+	_ = json.NewDecoder(response.Body).Decode(&result)
+	return result
+}
+
+func TestJoinByInvite(t *testing.T) {
+	var requestId string
+
+	t.Run("returns pending join request", func(t *testing.T) {
+		invite := createInvite(t, `{"requiresApproval":true}`)
+
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/invite/%s", invite["token"]), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusAccepted, response.Code)
+
+		if result["status"] != "pending" {
+			t.Errorf("Unexpected result: got %v, want %v", result["status"], "pending")
+		}
+		requestId, _ = result["_id"].(string)
+	})
+
+	t.Run("returns pending join requests of the group", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/chat/"+chatIdGroup+"/requests", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 {
+			t.Errorf("Unexpected result: got %v, want %v", len(result), 1)
+		}
+	})
+
+	t.Run("returns approved join request", func(t *testing.T) {
+		input := []byte(`{"action":"approve"}`)
+		request, _ := http.NewRequest("PUT", "/api/chat/"+chatIdGroup+"/requests/"+requestId, bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if result["status"] != "approved" {
+			t.Errorf("Unexpected result: got %v, want %v", result["status"], "approved")
+		}
+	})
+
+	t.Run("returns already a member error", func(t *testing.T) {
+		invite := createInvite(t, `{"maxUses":1}`)
+
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/invite/%s", invite["token"]), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]string
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		if result["error"] != "You're already a member of this group" {
+			t.Errorf("Unexpected result: got %v, want %v", result["error"], "You're already a member of this group")
+		}
+	})
+}
+
+func TestRevokeInvite(t *testing.T) {
+	t.Run("returns invite no longer valid", func(t *testing.T) {
+		invite := createInvite(t, `{}`)
+		inviteId, ok := invite["_id"].(string)
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+
+		request, _ := http.NewRequest("DELETE", "/api/chat/"+chatIdGroup+"/invites/"+inviteId, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("GET", fmt.Sprintf("/api/chat/invite/%s", invite["token"]), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusGone, response.Code)
+	})
+}
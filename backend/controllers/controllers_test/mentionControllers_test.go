@@ -0,0 +1,88 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestMentions(t *testing.T) {
+	var messageId string
+
+	t.Run("returns error mentioning user outside of chat", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"hey @%s"}`, chatId, user0Id)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns message with mentions", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"@%s have a look"}`, chatId, user2Id)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		messageId, _ = result["_id"].(string)
+
+		mentions, ok := result["mentions"].(map[string]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		assert.Equal(t, []interface{}{user2Id}, mentions["users"])
+		assert.Equal(t, nil, mentions["recipients"])
+	})
+
+	t.Run("lists mentions of user only", func(t *testing.T) {
+		for token, count := range map[string]string{user2Token: "1", user1Token: "0"} {
+			request, _ := http.NewRequest("GET", "/api/message/mentions", nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			var result []map[string]interface{}
+			_ = json.NewDecoder(response.Body).Decode(&result)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, count, response.Header().Get("X-Total-Count"))
+			if count == "1" {
+				assert.Equal(t, messageId, result[0]["_id"])
+			}
+		}
+	})
+
+	t.Run("editing out the mention removes it from the feed", func(t *testing.T) {
+		data := fmt.Sprintf(`{"content":"have a look", "messageId":"%s"}`, messageId)
+		request, _ := http.NewRequest("PUT", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("GET", "/api/message/mentions", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "0", response.Header().Get("X-Total-Count"))
+	})
+}
@@ -2,14 +2,21 @@ package controllers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-playground/assert/v2"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/unfurl"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestSendMessage(t *testing.T) {
@@ -26,6 +33,18 @@ func TestSendMessage(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, response.Code)
 	})
 
+	t.Run("returns error for users who aren't members", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"Let me in"}`, chatId)
+		input := []byte(data)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
 	t.Run("returns message object", func(t *testing.T) {
 		data := fmt.Sprintf(`{"chatId":"%s", "content":"How are you bro"}`, chatId)
 		input := []byte(data)
@@ -91,6 +110,91 @@ func TestSendMessage(t *testing.T) {
 	})
 }
 
+func TestSendMarkdownMessage(t *testing.T) {
+	var messageId string
+
+	t.Run("returns error for unknown format", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"hi", "format":"html"}`, chatId)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns sanitized rendered content", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"**hi** <script>alert(1)</script>", "format":"markdown"}`, chatId)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "markdown", result["format"])
+		assert.Equal(t, "**hi** <script>alert(1)</script>", result["content"])
+		assert.Equal(t, "<p><strong>hi</strong> &lt;script&gt;alert(1)&lt;/script&gt;</p>", result["rendered"])
+		messageId, _ = result["_id"].(string)
+	})
+
+	t.Run("editing renders content again", func(t *testing.T) {
+		data := fmt.Sprintf(`{"content":"_hi_", "messageId":"%s"}`, messageId)
+		request, _ := http.NewRequest("PUT", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "<p><em>hi</em></p>", result["rendered"])
+	})
+}
+
+func TestLinkPreviews(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<head><meta property="og:title" content="A page"><meta property="og:description" content="About it"></head>`))
+	}))
+	defer page.Close()
+
+	unfurl.Links = unfurl.New(unfurl.Options{AllowPrivate: true})
+	defer func() { unfurl.Links = nil }()
+
+	data := fmt.Sprintf(`{"chatId":"%s", "content":"have a look at %s/page."}`, chatId, page.URL)
+	request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+	request.Header.Set("Authorization", "Bearer "+user1Token)
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	var result map[string]interface{}
+	_ = json.NewDecoder(response.Body).Decode(&result)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	messageId, _ := primitive.ObjectIDFromHex(result["_id"].(string))
+
+	// previews are added in the background once the page is fetched
+	messageCollection := database.OpenCollection(database.Client, "message")
+	var message models.Message
+	for i := 0; i < 50 && len(message.Previews) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if err := messageCollection.FindOne(context.Background(), bson.D{{"_id", messageId}}).Decode(&message); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	want := []models.LinkPreview{{URL: page.URL + "/page", Title: "A page", Description: "About it"}}
+	assert.Equal(t, want, message.Previews)
+}
+
 func TestGetMessage(t *testing.T) {
 	t.Run("returns user messages", func(t *testing.T) {
 		request, _ := http.NewRequest("GET", "/api/message/"+chatId, nil)
@@ -108,6 +212,44 @@ func TestGetMessage(t *testing.T) {
 			t.Errorf("Unexpected result: got %v, want %v", len(result), "atleast 1 message document")
 		}
 	})
+	t.Run("returns error for users who aren't members", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/"+chatId, nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+	t.Run("returns system messages of group", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/"+chatIdGroup, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		if len(result) < 1 {
+			t.Fatalf("Unexpected result: got %v, want %v", len(result), "atleast 1 message document")
+		}
+
+		// the group was created during setup, which is recorded first
+		if result[0]["type"] != "system" {
+			t.Errorf("Unexpected result: got %v, want %v", result[0]["type"], "system")
+		}
+
+		event, ok := result[0]["event"].(map[string]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		if event["kind"] != "group.created" {
+			t.Errorf("Unexpected result: got %v, want %v", event["kind"], "group.created")
+		}
+	})
 }
 
 func TestEditUserMessage(t *testing.T) {
@@ -132,6 +274,50 @@ func TestEditUserMessage(t *testing.T) {
 			t.Errorf("Unexpected result: got %v, want %v", result["content"], expectedContent)
 		}
 	})
+
+	t.Run("returns forbidden editing message of other user", func(t *testing.T) {
+		data := fmt.Sprintf(`{"content":"Not mine", "messageId":"%s"}`, messageIdEdit)
+		request, _ := http.NewRequest("PUT", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+}
+
+func TestGetMessageHistory(t *testing.T) {
+	t.Run("returns previous versions of edited message", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/history/"+messageIdEdit, nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "Message edited", result["content"])
+
+		versions, _ := result["versions"].([]interface{})
+		if len(versions) != 1 {
+			t.Fatalf("Unexpected result: got %v, want %v", versions, "one previous version")
+		}
+		version, _ := versions[0].(map[string]interface{})
+		assert.Equal(t, "Message to be edited", version["content"])
+	})
+
+	t.Run("returns not found for non member", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/history/"+messageIdEdit, nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
 }
 
 func TestDeleteUserMessage(t *testing.T) {
@@ -145,4 +331,136 @@ func TestDeleteUserMessage(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, response.Code)
 	})
+
+	t.Run("returns tombstone of deleted message", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/"+chatId, nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		found := false
+		for _, message := range result {
+			if message["_id"] == messageIdDelete {
+				found = true
+				assert.Equal(t, "This message was deleted", message["content"])
+			}
+		}
+		if !found {
+			t.Errorf("Unexpected result: deleted message missing from %v", result)
+		}
+	})
+
+	t.Run("returns forbidden deleting message of other user for everyone", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/message/"+messageIdEdit, nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+}
+
+func TestRestoreMessage(t *testing.T) {
+	t.Run("returns error restoring message deleted by other user", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", "/api/message/"+messageIdDelete+"/restore", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns restored message", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", "/api/message/"+messageIdDelete+"/restore", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "How are you bro", result["content"])
+	})
+
+	t.Run("message deleted for me is hidden from that user only", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/message/"+messageIdDelete+"?for=me", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		for token, visible := range map[string]bool{user1Token: true, user2Token: false} {
+			request, _ := http.NewRequest("GET", "/api/message/"+chatId, nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			var result []map[string]interface{}
+			_ = json.NewDecoder(response.Body).Decode(&result)
+
+			found := false
+			for _, message := range result {
+				if message["_id"] == messageIdDelete {
+					found = true
+				}
+			}
+			assert.Equal(t, visible, found)
+		}
+
+		request, _ = http.NewRequest("POST", "/api/message/"+messageIdDelete+"/restore", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+}
+
+func TestSearchMessages(t *testing.T) {
+
+	t.Run("returns matching messages with snippets", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/search?q=bro&chatId="+chatId, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		results, ok := result["results"].([]interface{})
+		if !ok || len(results) < 1 {
+			t.Fatalf("Unexpected result: got %v, want %v", result["results"], "at least 1 message document")
+		}
+
+		message, ok := results[0].(map[string]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		if message["snippet"] == "" {
+			t.Errorf("Unexpected result: snippet field can't be empty")
+		}
+	})
+
+	t.Run("returns search text required error", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/search?q=", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
 }
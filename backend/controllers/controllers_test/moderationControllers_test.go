@@ -0,0 +1,157 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/pmohanj/web-chat-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setAdmin grants or revokes admin rights of the user directly in the
+// database, as no endpoint does that
+func setAdmin(t *testing.T, userId string, isAdmin bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, _ := primitive.ObjectIDFromHex(userId)
+	userCollection := database.OpenCollection(database.Client, "user")
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", id}}, bson.D{{"$set", bson.D{{"isAdmin", isAdmin}}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	var messageId string
+	var reportId string
+
+	t.Run("returns created report", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"Buy cheap followers now"}`, chatId)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var message map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&message)
+		messageId, _ = message["_id"].(string)
+
+		data = fmt.Sprintf(`{"messageId":"%s", "reason":"spam"}`, messageId)
+		request, _ = http.NewRequest("POST", "/api/report", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "Buy cheap followers now", result["content"])
+		reportId, _ = result["_id"].(string)
+	})
+
+	t.Run("returns error reporting message twice", func(t *testing.T) {
+		data := fmt.Sprintf(`{"messageId":"%s", "reason":"spam"}`, messageId)
+		request, _ := http.NewRequest("POST", "/api/report", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns not found reporting message of other chat", func(t *testing.T) {
+		data := fmt.Sprintf(`{"messageId":"%s", "reason":"spam"}`, messageId)
+		request, _ := http.NewRequest("POST", "/api/report", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns permission error for non admins", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/moderation/reports", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	setAdmin(t, user0Id, true)
+	defer setAdmin(t, user0Id, false)
+
+	t.Run("returns open reports", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/moderation/reports", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 || result[0]["_id"] != reportId {
+			t.Errorf("Unexpected result: got %v, want report %v", result, reportId)
+		}
+	})
+
+	t.Run("returns actioned report after deleting message", func(t *testing.T) {
+		input := []byte(`{"action":"deleteMessage", "note":"spam link"}`)
+		request, _ := http.NewRequest("PUT", fmt.Sprintf("/api/moderation/reports/%s", reportId), bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "actioned", result["status"])
+	})
+
+	t.Run("returns not found acting on resolved report", func(t *testing.T) {
+		input := []byte(`{"action":"dismiss"}`)
+		request, _ := http.NewRequest("PUT", fmt.Sprintf("/api/moderation/reports/%s", reportId), bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns moderation log entry", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("/api/moderation/log?target=%s", user1Id), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 || result[0]["action"] != "deleteMessage" {
+			t.Errorf("Unexpected result: got %v, want one deleteMessage entry", result)
+		}
+	})
+}
@@ -0,0 +1,273 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/push"
+)
+
+// recordingProvider stands in for a push service, passing on what it's sent
+type recordingProvider struct {
+	sent chan pushed
+}
+
+type pushed struct {
+	device       models.Device
+	notification push.Notification
+}
+
+func (p recordingProvider) Send(ctx context.Context, device models.Device, n push.Notification) error {
+	p.sent <- pushed{device, n}
+	return nil
+}
+
+func TestPushNotifications(t *testing.T) {
+	provider := recordingProvider{sent: make(chan pushed, 10)}
+	push.Providers[models.PlatformFCM] = provider
+	defer delete(push.Providers, models.PlatformFCM)
+
+	var deviceId string
+
+	sendMessage := func(content string) string {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"%s"}`, chatId, content)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+		assert.Equal(t, http.StatusOK, response.Code)
+		messageId, _ := result["_id"].(string)
+		return messageId
+	}
+
+	t.Run("returns error for platform that isn't set up", func(t *testing.T) {
+		for _, platform := range []string{"sms", models.PlatformAPNs} {
+			data := fmt.Sprintf(`{"platform":"%s", "token":"device-token"}`, platform)
+			request, _ := http.NewRequest("POST", "/api/user/devices", bytes.NewBuffer([]byte(data)))
+			request.Header.Set("Authorization", "Bearer "+user2Token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			assert.Equal(t, http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("registers device", func(t *testing.T) {
+		data := `{"platform":"fcm", "token":"user2-phone", "name":"Phone"}`
+		request, _ := http.NewRequest("POST", "/api/user/devices", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, user2Id, result["user"])
+		deviceId, _ = result["_id"].(string)
+
+		request, _ = http.NewRequest("GET", "/api/user/devices", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var devices []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&devices)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 1, len(devices))
+	})
+
+	t.Run("pushes messages to offline members", func(t *testing.T) {
+		messageId := sendMessage("are you there?")
+
+		select {
+		case p := <-provider.sent:
+			assert.Equal(t, "user2-phone", p.device.Token)
+			assert.Equal(t, "are you there?", p.notification.Body)
+			assert.Equal(t, messageId, p.notification.Data["messageId"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Unexpected result: no notification was pushed")
+		}
+	})
+
+	t.Run("muted chats aren't pushed", func(t *testing.T) {
+		data := `{"muted":true}`
+		request, _ := http.NewRequest("PUT", "/api/chat/"+chatId+"/notifications", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, true, result["muted"])
+
+		sendMessage("muted message")
+
+		data = fmt.Sprintf(`{"muted":true, "until":"%s"}`, time.Now().Add(-time.Hour).Format(time.RFC3339))
+		request, _ = http.NewRequest("PUT", "/api/chat/"+chatId+"/notifications", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		data = `{"muted":false}`
+		request, _ = http.NewRequest("PUT", "/api/chat/"+chatId+"/notifications", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		// the first notification is for the message sent after unmuting
+		messageId := sendMessage("unmuted message")
+		select {
+		case p := <-provider.sent:
+			assert.Equal(t, messageId, p.notification.Data["messageId"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Unexpected result: no notification was pushed")
+		}
+	})
+
+	t.Run("returns error muting chat of others", func(t *testing.T) {
+		request, _ := http.NewRequest("PUT", "/api/chat/"+chatId+"/notifications", bytes.NewBuffer([]byte(`{"muted":true}`)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	updateSettings := func(path, data string) (int, map[string]interface{}) {
+		request, _ := http.NewRequest("PUT", path, bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+		return response.Code, result
+	}
+
+	t.Run("returns error for invalid settings", func(t *testing.T) {
+		for _, data := range []string{
+			`{"level":"some"}`,
+			`{"keywords":"deploy"}`,
+			`{"keywords":["  "]}`,
+			`{"dnd":{"start":"22:00", "end":"22:00"}}`,
+			`{"dnd":{"start":"22:00", "end":"07:00", "days":[7]}}`,
+		} {
+			code, _ := updateSettings("/api/user/notifications", data)
+			assert.Equal(t, http.StatusBadRequest, code)
+		}
+
+		// do not disturb is kept for all chats
+		code, _ := updateSettings("/api/chat/"+chatId+"/notifications", `{"dnd":{"start":"22:00", "end":"07:00"}}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("mentions level pushes mentions and keywords only", func(t *testing.T) {
+		code, result := updateSettings("/api/chat/"+chatId+"/notifications", `{"level":"mentions", "keywords":[" Deploy "]}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "mentions", result["level"])
+		assert.Equal(t, []interface{}{"deploy"}, result["keywords"])
+
+		sendMessage("just chatting")
+		messageId := sendMessage("the deploy is done")
+		select {
+		case p := <-provider.sent:
+			assert.Equal(t, messageId, p.notification.Data["messageId"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Unexpected result: no notification was pushed")
+		}
+	})
+
+	t.Run("returns settings alongside chats", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/chat/", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var chats []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&chats)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		found := false
+		for _, chat := range chats {
+			settings, _ := chat["notificationSettings"].(map[string]interface{})
+			if chat["_id"] == chatId {
+				found = true
+				assert.Equal(t, "mentions", settings["level"])
+				assert.Equal(t, false, settings["muted"])
+			} else {
+				assert.Equal(t, "all", settings["level"])
+			}
+		}
+		assert.Equal(t, true, found)
+	})
+
+	t.Run("do not disturb holds back pushes", func(t *testing.T) {
+		now := time.Now().UTC()
+		data := fmt.Sprintf(`{"dnd":{"start":"%s", "end":"%s", "timezone":"UTC"}}`,
+			now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"))
+		code, result := updateSettings("/api/user/notifications", data)
+		assert.Equal(t, http.StatusOK, code)
+		assert.NotEqual(t, nil, result["dnd"])
+
+		code, _ = updateSettings("/api/chat/"+chatId+"/notifications", `{"level":"all", "keywords":[]}`)
+		assert.Equal(t, http.StatusOK, code)
+
+		sendMessage("during do not disturb")
+
+		code, result = updateSettings("/api/user/notifications", `{"dnd":null}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, nil, result["dnd"])
+
+		// the first notification is for the message sent after it ended
+		messageId := sendMessage("after do not disturb")
+		select {
+		case p := <-provider.sent:
+			assert.Equal(t, messageId, p.notification.Data["messageId"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Unexpected result: no notification was pushed")
+		}
+	})
+
+	t.Run("removes device", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/user/devices/"+deviceId, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+
+		request, _ = http.NewRequest("DELETE", "/api/user/devices/"+deviceId, nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+}
@@ -0,0 +1,153 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestPinMessage(t *testing.T) {
+	t.Run("returns not found pinning in chat of others", func(t *testing.T) {
+		data := fmt.Sprintf(`{"messageId":"%s"}`, messageIdEdit)
+		request, _ := http.NewRequest("POST", "/api/chat/"+chatId+"/pins", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("either user of direct chat pins message", func(t *testing.T) {
+		data := fmt.Sprintf(`{"messageId":"%s"}`, messageIdEdit)
+		request, _ := http.NewRequest("POST", "/api/chat/"+chatId+"/pins", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, messageIdEdit, result["message"])
+		assert.Equal(t, user2Id, result["pinnedBy"])
+	})
+
+	t.Run("returns not found pinning message of other chat", func(t *testing.T) {
+		data := fmt.Sprintf(`{"messageId":"%s"}`, messageIdEdit)
+		request, _ := http.NewRequest("POST", "/api/chat/"+chatIdGroup+"/pins", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns pinned messages", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/chat/"+chatId+"/pins", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		if len(result) != 1 {
+			t.Fatalf("Unexpected result: got %v, want %v", len(result), 1)
+		}
+
+		message, ok := result[0]["message"].(map[string]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		assert.Equal(t, messageIdEdit, message["_id"])
+	})
+
+	t.Run("unpins message", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/chat/"+chatId+"/pins/"+messageIdEdit, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("DELETE", "/api/chat/"+chatId+"/pins/"+messageIdEdit, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestBookmarks(t *testing.T) {
+	t.Run("returns not found saving message of chat of others", func(t *testing.T) {
+		data := fmt.Sprintf(`{"messageId":"%s"}`, messageIdEdit)
+		request, _ := http.NewRequest("POST", "/api/user/bookmarks", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("saves message", func(t *testing.T) {
+		data := fmt.Sprintf(`{"messageId":"%s"}`, messageIdEdit)
+		request, _ := http.NewRequest("POST", "/api/user/bookmarks", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, messageIdEdit, result["message"])
+		assert.Equal(t, chatId, result["chat"])
+	})
+
+	t.Run("returns saved messages of user only", func(t *testing.T) {
+		for token, count := range map[string]int{user2Token: 1, user1Token: 0} {
+			request, _ := http.NewRequest("GET", "/api/user/bookmarks", nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			var result []map[string]interface{}
+			_ = json.NewDecoder(response.Body).Decode(&result)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, fmt.Sprint(count), response.Header().Get("X-Total-Count"))
+			assert.Equal(t, count, len(result))
+		}
+	})
+
+	t.Run("removes saved message", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/user/bookmarks/"+messageIdEdit, nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("DELETE", "/api/user/bookmarks/"+messageIdEdit, nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,135 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestPolls(t *testing.T) {
+	var pollId string
+
+	t.Run("returns error for poll with one option", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "question":"Lunch?", "options":["Pizza"]}`, chatId)
+		request, _ := http.NewRequest("POST", "/api/message/poll", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns poll message", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "question":"Lunch?", "options":["Pizza", "Noodles"]}`, chatId)
+		request, _ := http.NewRequest("POST", "/api/message/poll", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "poll", result["type"])
+		assert.Equal(t, "Lunch?", result["content"])
+		pollId, _ = result["_id"].(string)
+	})
+
+	t.Run("returns error picking two options of single choice poll", func(t *testing.T) {
+		input := []byte(`{"options":[0, 1]}`)
+		request, _ := http.NewRequest("PUT", "/api/message/poll/"+pollId+"/vote", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("changing vote updates counts", func(t *testing.T) {
+		for _, option := range []int{0, 1} {
+			input := []byte(fmt.Sprintf(`{"options":[%d]}`, option))
+			request, _ := http.NewRequest("PUT", "/api/message/poll/"+pollId+"/vote", bytes.NewBuffer(input))
+			request.Header.Set("Authorization", "Bearer "+user2Token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			assert.Equal(t, http.StatusOK, response.Code)
+		}
+
+		request, _ := http.NewRequest("GET", "/api/message/poll/"+pollId, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		poll, ok := result["poll"].(map[string]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		options, ok := poll["options"].([]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		for i, want := range []float64{0, 1} {
+			option, _ := options[i].(map[string]interface{})
+			assert.Equal(t, want, option["votes"])
+		}
+
+		voters, ok := result["voters"].([]interface{})
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+		assert.Equal(t, []interface{}{user2Id}, voters[1])
+	})
+
+	t.Run("returns not found for poll of chat of others", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/poll/"+pollId, nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns forbidden closing poll of other user", func(t *testing.T) {
+		request, _ := http.NewRequest("PUT", "/api/message/poll/"+pollId+"/close", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("closed poll takes no votes", func(t *testing.T) {
+		request, _ := http.NewRequest("PUT", "/api/message/poll/"+pollId+"/close", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		input := []byte(`{"options":[0]}`)
+		request, _ = http.NewRequest("PUT", "/api/message/poll/"+pollId+"/vote", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
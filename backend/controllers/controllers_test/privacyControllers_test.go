@@ -0,0 +1,122 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestBlockUser(t *testing.T) {
+	t.Run("returns blocked users after blocking", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userId":"%s"}`, user2Id)
+		request, _ := http.NewRequest("POST", "/api/user/blocks", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string][]string
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result["blockedUsers"]) != 1 || result["blockedUsers"][0] != user2Id {
+			t.Errorf("Unexpected result: got %v, want [%v]", result["blockedUsers"], user2Id)
+		}
+	})
+
+	t.Run("returns profiles of blocked users", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/user/blocks", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 || result[0]["name"] != "User2" {
+			t.Errorf("Unexpected result: got %v, want User2", result)
+		}
+	})
+
+	t.Run("returns permission error for blocked users starting chat", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userToBeAdded":"%s"}`, user0Id)
+		request, _ := http.NewRequest("POST", "/api/chat/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns no blocked users after unblocking", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/user/blocks/%s", user2Id), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string][]string
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 0, len(result["blockedUsers"]))
+	})
+}
+
+func TestUpdatePrivacySettings(t *testing.T) {
+	t.Run("returns error for unknown setting", func(t *testing.T) {
+		input := []byte(`{"dmPrivacy":"friends"}`)
+		request, _ := http.NewRequest("PUT", "/api/user/privacy", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns permission error for chats nobody can start", func(t *testing.T) {
+		input := []byte(`{"dmPrivacy":"nobody"}`)
+		request, _ := http.NewRequest("PUT", "/api/user/privacy", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		data := fmt.Sprintf(`{"userToBeAdded":"%s"}`, user0Id)
+		request, _ = http.NewRequest("POST", "/api/chat/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns restored setting", func(t *testing.T) {
+		input := []byte(`{"dmPrivacy":"everyone"}`)
+		request, _ := http.NewRequest("PUT", "/api/user/privacy", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]string
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "everyone", result["dmPrivacy"])
+	})
+}
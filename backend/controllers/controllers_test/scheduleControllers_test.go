@@ -0,0 +1,160 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestScheduleMessage(t *testing.T) {
+	sendAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	var scheduledId string
+
+	t.Run("returns error for time in the past", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"Later", "sendAt":"%s"}`, chatId, time.Now().Add(-time.Hour).Format(time.RFC3339))
+		request, _ := http.NewRequest("POST", "/api/message/scheduled", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns not found scheduling to chat of others", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"Later", "sendAt":"%s"}`, chatId, sendAt)
+		request, _ := http.NewRequest("POST", "/api/message/scheduled", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns scheduled message", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"Later", "sendAt":"%s"}`, chatId, sendAt)
+		request, _ := http.NewRequest("POST", "/api/message/scheduled", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "message", result["kind"])
+		assert.Equal(t, "pending", result["status"])
+		scheduledId, _ = result["_id"].(string)
+	})
+
+	t.Run("edits scheduled message", func(t *testing.T) {
+		input := []byte(`{"content":"Later, edited"}`)
+		request, _ := http.NewRequest("PUT", "/api/message/scheduled/"+scheduledId, bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "Later, edited", result["content"])
+	})
+
+	t.Run("returns not found editing item of other user", func(t *testing.T) {
+		input := []byte(`{"content":"Mine now"}`)
+		request, _ := http.NewRequest("PUT", "/api/message/scheduled/"+scheduledId, bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("lists pending items of user", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/message/scheduled?kind=message", nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "1", response.Header().Get("X-Total-Count"))
+		assert.Equal(t, scheduledId, result[0]["_id"])
+	})
+
+	t.Run("cancels scheduled message", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", "/api/message/scheduled/"+scheduledId, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("DELETE", "/api/message/scheduled/"+scheduledId, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestAddReminder(t *testing.T) {
+	sendAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	t.Run("returns not found for message of chat of others", func(t *testing.T) {
+		data := fmt.Sprintf(`{"sendAt":"%s"}`, sendAt)
+		request, _ := http.NewRequest("POST", "/api/message/"+messageIdEdit+"/reminder", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns reminder", func(t *testing.T) {
+		data := fmt.Sprintf(`{"sendAt":"%s", "note":"reply to this"}`, sendAt)
+		request, _ := http.NewRequest("POST", "/api/message/"+messageIdEdit+"/reminder", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "reminder", result["kind"])
+		assert.Equal(t, messageIdEdit, result["message"])
+		assert.Equal(t, chatId, result["chat"])
+		assert.Equal(t, "reply to this", result["content"])
+	})
+
+	t.Run("lists reminders of user only", func(t *testing.T) {
+		for token, count := range map[string]string{user2Token: "1", user1Token: "0"} {
+			request, _ := http.NewRequest("GET", "/api/message/scheduled?kind=reminder", nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, count, response.Header().Get("X-Total-Count"))
+		}
+	})
+}
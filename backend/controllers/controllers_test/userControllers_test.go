@@ -18,6 +18,9 @@ import (
 	"github.com/pmohanj/web-chat-app/database"
 	"github.com/pmohanj/web-chat-app/models"
 	"github.com/pmohanj/web-chat-app/routes"
+	"github.com/pmohanj/web-chat-app/search"
+	"github.com/pmohanj/web-chat-app/storage"
+	"github.com/pmohanj/web-chat-app/webhook"
 )
 
 var router *gin.Engine
@@ -25,8 +28,12 @@ var user1Token string
 var chatId string
 var chatIdGroup string
 var chatIdDelete string
+var chatIdExit string
 var user0Id string
+var user0Token string
+var user1Id string
 var user2Id string
+var user2Token string
 var messageIdDelete string
 var messageIdEdit string
 
@@ -42,11 +49,25 @@ func TestMain(m *testing.M) {
 	// Initiate Databse
 	database.DBinstance(MongoDBURL)
 
+	// keep uploaded files of the tests in a temporary directory
+	uploadsDir, err := os.MkdirTemp("", "uploads")
+	if err != nil {
+		log.Fatal("Error creating uploads directory ", err)
+	}
+	storage.Store = storage.NewDiskStorage(uploadsDir)
+	search.Messages = search.NewMemoryIndex()
+	// webhooks of the tests post to local servers
+	webhook.Default = webhook.NewSender(true)
+
 	// setup user routes
 	api := router.Group("/api")
 	routes.AddUserRoutes(api)
 	routes.AddMessageRoutes(api)
 	routes.AddChatRoutes(api)
+	routes.AddWorkspaceRoutes(api)
+	routes.AddModerationRoutes(api)
+	routes.AddAdminRoutes(api)
+	routes.AddWebhookRoutes(api)
 
 	status := setupPhase()
 	if status != 0 {
@@ -55,6 +76,7 @@ func TestMain(m *testing.M) {
 	code := m.Run()
 	tearDownPhase()
 	database.CloseDBinstance()
+	os.RemoveAll(uploadsDir)
 	os.Exit(code)
 }
 
@@ -95,6 +117,7 @@ func createUsers() int {
 	var resUser0 map[string]string
 	_ = json.NewDecoder(response0.Body).Decode(&resUser0)
 	user0Id = resUser0["_id"]
+	user0Token = resUser0["token"]
 
 	input1 := []byte(`{"name":"User1", "email":"user1@gmail.com", "password":"haha123"}`)
 	req1, _ := http.NewRequest("POST", "/api/user/", bytes.NewBuffer(input1))
@@ -108,6 +131,7 @@ func createUsers() int {
 	var resUser1 map[string]string
 	_ = json.NewDecoder(response1.Body).Decode(&resUser1)
 	user1Token = resUser1["token"]
+	user1Id = resUser1["_id"]
 
 	input2 := []byte(`{"name":"User2", "email":"user2@gmail.com", "password":"haha123"}`)
 	req2, _ := http.NewRequest("POST", "/api/user/", bytes.NewBuffer(input2))
@@ -121,6 +145,7 @@ func createUsers() int {
 	var resUser2 map[string]string
 	_ = json.NewDecoder(response2.Body).Decode(&resUser2)
 	user2Id = resUser2["_id"]
+	user2Token = resUser2["token"]
 
 	return 0
 }
@@ -233,6 +258,22 @@ func tearDownPhase() {
 	chatCollection.Drop(ctx)
 	messageCollection.Drop(ctx)
 	userCollection.Drop(ctx)
+	database.OpenCollection(database.Client, "invite").Drop(ctx)
+	database.OpenCollection(database.Client, "joinRequest").Drop(ctx)
+	database.OpenCollection(database.Client, "workspace").Drop(ctx)
+	database.OpenCollection(database.Client, "workspaceInvite").Drop(ctx)
+	database.OpenCollection(database.Client, "report").Drop(ctx)
+	database.OpenCollection(database.Client, "moderationLog").Drop(ctx)
+	database.OpenCollection(database.Client, "warning").Drop(ctx)
+	database.OpenCollection(database.Client, "audit").Drop(ctx)
+	database.OpenCollection(database.Client, "messageVersion").Drop(ctx)
+	database.OpenCollection(database.Client, "pin").Drop(ctx)
+	database.OpenCollection(database.Client, "bookmark").Drop(ctx)
+	database.OpenCollection(database.Client, "scheduled").Drop(ctx)
+	database.OpenCollection(database.Client, "device").Drop(ctx)
+	database.OpenCollection(database.Client, "notificationSetting").Drop(ctx)
+	database.OpenCollection(database.Client, "webhook").Drop(ctx)
+	database.OpenCollection(database.Client, "webhookDelivery").Drop(ctx)
 }
 
 func TestRegisterUser(t *testing.T) {
@@ -402,4 +443,63 @@ func TestSearchUsers(t *testing.T) {
 			t.Errorf("Unexpected result: got %v, want %v", len(result), "at least 1 document")
 		}
 	})
+	t.Run("returns user found ignoring case", func(t *testing.T) {
+		url := "/api/user/search?search=" + "USER2"
+		request, _ := http.NewRequest("GET", url, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 {
+			t.Fatalf("Unexpected result: got %v, want %v", len(result), 1)
+		}
+
+		if _, exists := result[0]["isAdmin"]; exists {
+			t.Errorf("Unexpected result: isAdmin field shouldn't be returned")
+		}
+	})
+
+	t.Run("returns no searching user", func(t *testing.T) {
+		url := "/api/user/search?search=" + "user"
+		request, _ := http.NewRequest("GET", url, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		for _, user := range result {
+			if user["email"] == "user1@gmail.com" {
+				t.Errorf("Unexpected result: searching user shouldn't be returned")
+			}
+		}
+	})
+
+	t.Run("returns no users for regex characters", func(t *testing.T) {
+		url := "/api/user/search?search=" + ".%2A"
+		request, _ := http.NewRequest("GET", url, nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) > 0 {
+			t.Errorf("Unexpected result: got %v, want %v", len(result), "0 documents to be returned")
+		}
+	})
 }
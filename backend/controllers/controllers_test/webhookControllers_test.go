@@ -0,0 +1,232 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Header, body}
+	}))
+	defer server.Close()
+
+	var webhookId, secret string
+
+	t.Run("returns error for unknown event", func(t *testing.T) {
+		data := fmt.Sprintf(`{"url":"%s", "events":["message.exploded"]}`, server.URL)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/%s/webhooks", chatIdGroup), bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns error for invalid url", func(t *testing.T) {
+		data := `{"url":"ftp://example.com", "events":["message.created"]}`
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/%s/webhooks", chatIdGroup), bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("returns error for members who aren't admins", func(t *testing.T) {
+		data := fmt.Sprintf(`{"url":"%s", "events":["message.created"]}`, server.URL)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/%s/webhooks", chatIdGroup), bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns created webhook with its secret", func(t *testing.T) {
+		data := fmt.Sprintf(`{"url":"%s", "events":["message.created", "member.added", "message.created"]}`, server.URL)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/chat/%s/webhooks", chatIdGroup), bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, true, result["active"])
+		webhookId, _ = result["_id"].(string)
+		secret, _ = result["secret"].(string)
+		if secret == "" {
+			t.Error("Unexpected result: secret isn't sent")
+		}
+		if events, _ := result["events"].([]interface{}); len(events) != 2 {
+			t.Errorf("Unexpected events: %v", result["events"])
+		}
+	})
+
+	t.Run("returns webhooks without secrets", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("/api/chat/%s/webhooks", chatIdGroup), nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		if len(result) != 1 || result[0]["_id"] != webhookId {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+		if _, exists := result[0]["secret"]; exists {
+			t.Error("Unexpected result: secret is listed")
+		}
+	})
+
+	t.Run("delivers signed ping", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/webhook/%s/ping", webhookId), nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, models.DeliveryDelivered, result["status"])
+
+		select {
+		case got := <-requests:
+			assert.Equal(t, models.EventPing, got.header.Get(webhook.HeaderEvent))
+			if !webhook.Verify(secret, got.header.Get(webhook.HeaderTimestamp), got.body, got.header.Get(webhook.HeaderSignature)) {
+				t.Error("Unexpected result: ping isn't signed with the secret")
+			}
+		default:
+			t.Error("Unexpected result: ping wasn't delivered")
+		}
+	})
+
+	t.Run("returns error for ping by members who aren't admins", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/webhook/%s/ping", webhookId), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("queues delivery of sent message", func(t *testing.T) {
+		data := fmt.Sprintf(`{"chatId":"%s", "content":"webhook me"}`, chatIdGroup)
+		request, _ := http.NewRequest("POST", "/api/message/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		// events are queued in the background
+		var result []map[string]interface{}
+		for i := 0; i < 20; i++ {
+			time.Sleep(100 * time.Millisecond)
+
+			request, _ := http.NewRequest("GET", fmt.Sprintf("/api/webhook/%s/deliveries?status=pending", webhookId), nil)
+			request.Header.Set("Authorization", "Bearer "+user1Token)
+
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			assert.Equal(t, http.StatusOK, response.Code)
+
+			_ = json.NewDecoder(response.Body).Decode(&result)
+			if len(result) > 0 {
+				break
+			}
+		}
+
+		if len(result) != 1 {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+		assert.Equal(t, models.EventMessageCreated, result[0]["event"])
+
+		var payload webhook.Payload
+		payload.Data = &models.Message{}
+		raw, _ := result[0]["payload"].(string)
+		if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, chatIdGroup, payload.Chat)
+		assert.Equal(t, "webhook me", payload.Data.(*models.Message).Content)
+	})
+
+	t.Run("returns delivery log newest first", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", fmt.Sprintf("/api/webhook/%s/deliveries", webhookId), nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "2", response.Header().Get("X-Total-Count"))
+		if len(result) != 2 || result[1]["event"] != models.EventPing {
+			t.Errorf("Unexpected result: %v", result)
+		}
+	})
+
+	t.Run("returns deactivated webhook", func(t *testing.T) {
+		request, _ := http.NewRequest("PUT", fmt.Sprintf("/api/webhook/%s", webhookId), bytes.NewBuffer([]byte(`{"active":false}`)))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, false, result["active"])
+		if events, _ := result["events"].([]interface{}); len(events) != 2 {
+			t.Errorf("Unexpected result: events changed to %v", result["events"])
+		}
+	})
+
+	t.Run("deletes webhook", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/webhook/%s", webhookId), nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		request, _ = http.NewRequest("GET", fmt.Sprintf("/api/webhook/%s/deliveries", webhookId), nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,282 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func TestWorkspaces(t *testing.T) {
+	var workspaceId string
+	var workspaceToken string
+	var invitedResponse string
+
+	t.Run("returns created workspace", func(t *testing.T) {
+		input := []byte(`{"name":"Testing workspace"}`)
+		request, _ := http.NewRequest("POST", "/api/workspace/", bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		workspaceId, _ = result["_id"].(string)
+	})
+
+	t.Run("returns same response for unregistered email", func(t *testing.T) {
+		input := []byte(`{"email":"nobody@gmail.com"}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/workspace/%s/members", workspaceId), bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		invitedResponse = response.Body.String()
+	})
+
+	t.Run("returns same response for invited member", func(t *testing.T) {
+		input := []byte(`{"email":"user2@gmail.com"}`)
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/workspace/%s/members", workspaceId), bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, invitedResponse, response.Body.String())
+	})
+
+	var inviteId string
+	t.Run("returns pending invites of user", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/workspace/invites", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		if len(result) != 1 {
+			t.Fatalf("Unexpected result: got %v, want %v", len(result), 1)
+		}
+		inviteId, _ = result[0]["_id"].(string)
+	})
+
+	t.Run("returns not found for invites of other users", func(t *testing.T) {
+		input := []byte(`{"action":"accept"}`)
+		request, _ := http.NewRequest("POST", "/api/workspace/invites/"+inviteId, bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns accepted invite", func(t *testing.T) {
+		input := []byte(`{"action":"accept"}`)
+		request, _ := http.NewRequest("POST", "/api/workspace/invites/"+inviteId, bytes.NewBuffer(input))
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "accepted", result["status"])
+	})
+
+	t.Run("returns workspaces of user who accepted", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/workspace/", nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		if len(result) != 1 || result[0]["_id"] != workspaceId {
+			t.Errorf("Unexpected result: got %v, want workspace %v", result, workspaceId)
+		}
+	})
+
+	t.Run("returns token of switched workspace", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/workspace/%s/switch", workspaceId), nil)
+		request.Header.Set("Authorization", "Bearer "+user1Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		workspaceToken, _ = result["token"].(string)
+	})
+
+	t.Run("returns only workspace members in user search", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/user/search?search=user", nil)
+		request.Header.Set("Authorization", "Bearer "+workspaceToken)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 || result[0]["_id"] != user2Id {
+			t.Errorf("Unexpected result: got %v, want only %v", result, user2Id)
+		}
+	})
+
+	t.Run("returns no workspace members in user search outside of workspaces", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/user/search?search=user", nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		for _, user := range result {
+			if user["_id"] == user1Id || user["_id"] == user2Id {
+				t.Errorf("Unexpected result: workspace member %v found", user["_id"])
+			}
+		}
+	})
+
+	t.Run("returns permission error for chatting with workspace members from outside", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userToBeAdded":"%s"}`, user2Id)
+		request, _ := http.NewRequest("POST", "/api/chat/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns permission error for chatting outside of workspace", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userToBeAdded":"%s"}`, user0Id)
+		request, _ := http.NewRequest("POST", "/api/chat/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+workspaceToken)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("returns chat created in workspace", func(t *testing.T) {
+		data := fmt.Sprintf(`{"userToBeAdded":"%s"}`, user2Id)
+		request, _ := http.NewRequest("POST", "/api/chat/", bytes.NewBuffer([]byte(data)))
+		request.Header.Set("Authorization", "Bearer "+workspaceToken)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if result["_id"] == chatId || result["workspace"] != workspaceId {
+			t.Errorf("Unexpected result: got %v, want a new chat in workspace %v", result, workspaceId)
+		}
+	})
+
+	t.Run("returns only chats of workspace", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/chat/", nil)
+		request.Header.Set("Authorization", "Bearer "+workspaceToken)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		if len(result) != 1 {
+			t.Errorf("Unexpected result: got %v, want %v", len(result), 1)
+		}
+	})
+
+	t.Run("returns not found for outsiders switching", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/workspace/%s/switch", workspaceId), nil)
+		request.Header.Set("Authorization", "Bearer "+user0Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	var leavingToken string
+	t.Run("returns token of switched workspace for leaving member", func(t *testing.T) {
+		request, _ := http.NewRequest("POST", fmt.Sprintf("/api/workspace/%s/switch", workspaceId), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		leavingToken, _ = result["token"].(string)
+	})
+
+	t.Run("returns workspace after member leaves", func(t *testing.T) {
+		request, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/workspace/%s/members/%s", workspaceId, user2Id), nil)
+		request.Header.Set("Authorization", "Bearer "+user2Token)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		members, _ := result["members"].([]interface{})
+		if len(members) != 1 {
+			t.Errorf("Unexpected result: got %v, want %v", len(members), 1)
+		}
+	})
+	t.Run("returns no workspace members in user search after leaving", func(t *testing.T) {
+		request, _ := http.NewRequest("GET", "/api/user/search?search=user1", nil)
+		request.Header.Set("Authorization", "Bearer "+leavingToken)
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		var result []map[string]interface{}
+		_ = json.NewDecoder(response.Body).Decode(&result)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		if len(result) != 0 {
+			t.Errorf("Unexpected result: got %v, want none", result)
+		}
+	})
+}
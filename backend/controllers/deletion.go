@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/search"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Who a message or chat is deleted for, picked with the for query value
+const (
+	deleteForEveryone = "everyone"
+	deleteForMe       = "me"
+)
+
+// UndoWindow is how long a deletion can be undone, and RetentionPeriod how
+// long messages and chats deleted for everyone are kept before being purged
+var (
+	UndoWindow      = 5 * time.Minute
+	RetentionPeriod = 30 * 24 * time.Hour
+)
+
+// InitDeletion reads the undo window and retention period from the
+// UNDO_WINDOW and DELETED_RETENTION env variables, durations like "10m" or
+// "720h". Missing values keep the defaults
+func InitDeletion() {
+	for key, value := range map[string]*time.Duration{
+		"UNDO_WINDOW":       &UndoWindow,
+		"DELETED_RETENTION": &RetentionPeriod,
+	} {
+		raw := os.Getenv(key)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			log.Fatalf("%s must be a positive duration, got %q", key, raw)
+		}
+		*value = d
+	}
+	log.Printf("deletions can be undone for %v and are purged after %v", UndoWindow, RetentionPeriod)
+}
+
+// RestoreMessage undoes the deletion of the message by the user, either for
+// everyone or for themselves, within the undo window
+func RestoreMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		messageCollection := database.OpenCollection(database.Client, "message")
+
+		var message models.Message
+		err = messageCollection.FindOne(ctx, bson.D{{"_id", messageId}}).Decode(&message)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		var update bson.D
+		restored := false
+		if hiddenAt, hidden := message.Hidden[userId.Hex()]; hidden {
+			if !canUndo(c, hiddenAt) {
+				return
+			}
+			update = bson.D{{"$unset", bson.D{{"hidden." + userId.Hex(), ""}}}}
+			delete(message.Hidden, userId.Hex())
+		} else if !message.Deleted_at.IsZero() && message.DeletedBy == userId {
+			if !canUndo(c, message.Deleted_at) {
+				return
+			}
+			update = bson.D{{"$unset", bson.D{{"deleted_at", ""}, {"deletedBy", ""}}}}
+			message.Deleted_at, message.DeletedBy = time.Time{}, primitive.NilObjectID
+			restored = true
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You haven't deleted this message"})
+			return
+		}
+
+		if _, err := messageCollection.UpdateOne(ctx, bson.D{{"_id", messageId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		if restored {
+			search.Messages.Add(message)
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMessageRestored, Target: message.Sender, Chat: message.Chat, Message: messageId})
+			websocket.Publish(message.Chat.Hex(), map[string]interface{}{
+				"messageType": "messageRestored",
+				"message":     message,
+			})
+		}
+
+		c.JSON(http.StatusOK, message)
+	}
+}
+
+// RestoreConversation undoes the deletion of the chat by the user, either
+// for everyone or for themselves, within the undo window
+func RestoreConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chatCollection := database.OpenCollection(database.Client, "chat")
+
+		var chat models.Chat
+		err = chatCollection.FindOne(ctx, bson.D{{"_id", chatId}, {"users", userId}}).Decode(&chat)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		var update bson.D
+		restored := false
+		if !chat.Deleted_at.IsZero() && chat.DeletedBy == userId {
+			if !canUndo(c, chat.Deleted_at) {
+				return
+			}
+			update = bson.D{{"$unset", bson.D{{"deleted_at", ""}, {"deletedBy", ""}}}}
+			restored = true
+		} else if clearedAt, cleared := chat.Cleared[userId.Hex()]; cleared && chat.Deleted_at.IsZero() {
+			if !canUndo(c, clearedAt) {
+				return
+			}
+			update = bson.D{{"$unset", bson.D{{"cleared." + userId.Hex(), ""}}}}
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You haven't deleted this chat"})
+			return
+		}
+
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		if restored {
+			if err := indexChat(ctx, chatId); err != nil {
+				log.Println("error while indexing restored chat: ", err)
+			}
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditChatRestored, Chat: chatId})
+			websocket.Publish(chatId.Hex(), map[string]interface{}{"messageType": "chatRestored"})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Chat restored"})
+	}
+}
+
+// PurgeDeleted permanently removes, every interval, the messages and chats
+// that were deleted for everyone longer than the retention period ago. It
+// never returns, main runs it in its own goroutine
+func PurgeDeleted(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		if err := purgeDeleted(ctx, time.Now().Add(-RetentionPeriod)); err != nil {
+			log.Println("error while purging deleted messages: ", err)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
+
+// messageDocuments are the collections of documents that belong to a message
+// and are purged along with it
+var messageDocuments = []string{"messageVersion", "pin", "bookmark"}
+
+// purgeDeleted removes the messages and chats deleted for everyone before
+// cutoff, along with the documents that belong to them
+func purgeDeleted(ctx context.Context, cutoff time.Time) error {
+	deleted := bson.D{{"deleted_at", bson.D{{"$lt", cutoff}}}}
+
+	messageCollection := database.OpenCollection(database.Client, "message")
+	messageIds, err := messageCollection.Distinct(ctx, "_id", deleted)
+	if err != nil {
+		return err
+	}
+	if len(messageIds) > 0 {
+		ofMessages := bson.D{{"message", bson.D{{"$in", messageIds}}}}
+		for _, name := range messageDocuments {
+			if _, err := database.OpenCollection(database.Client, name).DeleteMany(ctx, ofMessages); err != nil {
+				return err
+			}
+		}
+
+		res, err := messageCollection.DeleteMany(ctx, bson.D{{"_id", bson.D{{"$in", messageIds}}}})
+		if err != nil {
+			return err
+		}
+		log.Println("Deleted messages purged: ", res.DeletedCount)
+	}
+
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	chatIds, err := chatCollection.Distinct(ctx, "_id", deleted)
+	if err != nil {
+		return err
+	}
+	for _, id := range chatIds {
+		if err := deleteChat(ctx, id.(primitive.ObjectID)); err != nil {
+			return err
+		}
+	}
+	if len(chatIds) > 0 {
+		log.Println("Deleted chats purged: ", len(chatIds))
+	}
+	return nil
+}
+
+// deletionScope reads who to delete for from the for query value, everyone
+// by default. It writes an error response and returns false when invalid
+func deletionScope(c *gin.Context) (string, bool) {
+	switch scope := c.DefaultQuery("for", deleteForEveryone); scope {
+	case deleteForEveryone, deleteForMe:
+		return scope, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "for must be everyone or me"})
+	return "", false
+}
+
+// canUndo checks a deletion made at deletedAt is still within the undo
+// window, writing an error response and returning false when it isn't
+func canUndo(c *gin.Context, deletedAt time.Time) bool {
+	if time.Since(deletedAt) > UndoWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The deletion can no longer be undone"})
+		return false
+	}
+	return true
+}
+
+// indexChat adds the messages of the chat back to the search index
+func indexChat(ctx context.Context, chatId primitive.ObjectID) error {
+	messageCollection := database.OpenCollection(database.Client, "message")
+	cursor, err := messageCollection.Find(ctx, bson.D{{"chat", chatId}, {"deleted_at", bson.D{{"$exists", false}}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			return err
+		}
+		search.Messages.Add(message)
+	}
+	return cursor.Err()
+}
+
+// visibleMessagesStage drops the messages the user deleted for themselves,
+// or that were sent before they deleted the chat for themselves
+func visibleMessagesStage(chat models.Chat, userId primitive.ObjectID) bson.D {
+	filter := bson.D{{"hidden." + userId.Hex(), bson.D{{"$exists", false}}}}
+	if clearedAt, cleared := chat.Cleared[userId.Hex()]; cleared {
+		filter = append(filter, bson.E{"created_at", bson.D{{"$gt", clearedAt}}})
+	}
+	return bson.D{{"$match", filter}}
+}
+
+// tombstoneStage replaces the content of messages deleted for everyone, drops
+// its rendered form and link previews, and removes who hid them, who voted
+// in polls and who mentions notified
+func tombstoneStage() mongo.Pipeline {
+	return mongo.Pipeline{
+		bson.D{
+			{
+				"$addFields", bson.D{
+					{"content", bson.D{{"$cond", bson.A{
+						bson.D{{"$gt", bson.A{"$deleted_at", nil}}},
+						models.DeletedContent,
+						"$content",
+					}}}},
+					{"rendered", bson.D{{"$cond", bson.A{
+						bson.D{{"$gt", bson.A{"$deleted_at", nil}}},
+						"$$REMOVE",
+						"$rendered",
+					}}}},
+					{"previews", bson.D{{"$cond", bson.A{
+						bson.D{{"$gt", bson.A{"$deleted_at", nil}}},
+						"$$REMOVE",
+						"$previews",
+					}}}},
+				},
+			},
+		},
+		bson.D{{"$project", bson.D{{"hidden", 0}, {"poll.votes", 0}, {"mentions.recipients", 0}}}},
+	}
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/push"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxDeviceToken bounds the length of device tokens and web push endpoints
+const maxDeviceToken = 4096
+
+// GetPushConfig returns the platforms push notifications can be sent
+// through, and the VAPID public key browsers subscribe to web push with
+func GetPushConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		platforms := []string{}
+		for _, platform := range []string{models.PlatformWebPush, models.PlatformFCM, models.PlatformAPNs} {
+			if push.Enabled(platform) {
+				platforms = append(platforms, platform)
+			}
+		}
+
+		config := gin.H{"platforms": platforms}
+		if webPush, ok := push.Providers[models.PlatformWebPush].(*push.WebPush); ok {
+			config["vapidPublicKey"] = webPush.PublicKey()
+		}
+		c.JSON(http.StatusOK, config)
+	}
+}
+
+// GetDevices lists the devices the user registered for push notifications
+func GetDevices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		deviceCollection := database.OpenCollection(database.Client, "device")
+		opts := options.Find().SetSort(bson.D{{"updated_at", -1}})
+		cursor, err := deviceCollection.Find(ctx, bson.D{{"user", userId}}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		devices := []models.Device{}
+		if err := cursor.All(ctx, &devices); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, devices)
+	}
+}
+
+// RegisterDevice registers a device of the user for push notifications.
+// Registering a device again refreshes it, and a device registered by
+// another user moves to this one since whoever is logged in on it gets them
+func RegisterDevice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+		platform, _ := reqData["platform"].(string)
+		token, _ := reqData["token"].(string)
+		name, _ := reqData["name"].(string)
+		token = strings.TrimSpace(token)
+		name = strings.TrimSpace(name)
+
+		switch platform {
+		case models.PlatformWebPush, models.PlatformFCM, models.PlatformAPNs:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "platform must be webpush, fcm or apns"})
+			return
+		}
+		if !push.Enabled(platform) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Push notifications aren't available for %s", platform)})
+			return
+		}
+		if token == "" || len(token) > maxDeviceToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+		if len(name) > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name can have at most 100 characters"})
+			return
+		}
+
+		var keys *models.WebPushKeys
+		if platform == models.PlatformWebPush {
+			rawKeys, _ := reqData["keys"].(map[string]interface{})
+			p256dh, _ := rawKeys["p256dh"].(string)
+			auth, _ := rawKeys["auth"].(string)
+			keys = &models.WebPushKeys{P256dh: p256dh, Auth: auth}
+			if err := push.CheckSubscription(token, keys); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid web push subscription"})
+				return
+			}
+		}
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		deviceCollection := database.OpenCollection(database.Client, "device")
+		count, err := deviceCollection.CountDocuments(ctx, bson.D{
+			{"user", userId},
+			{"$nor", bson.A{bson.D{{"platform", platform}, {"token", token}}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if count >= models.MaxDevices {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You can register at most %d devices", models.MaxDevices)})
+			return
+		}
+
+		now := time.Now()
+		set := bson.D{{"user", userId}, {"updated_at", now}}
+		if keys != nil {
+			set = append(set, bson.E{"keys", keys})
+		}
+		if name != "" {
+			set = append(set, bson.E{"name", name})
+		}
+		update := bson.D{
+			{"$set", set},
+			{"$setOnInsert", bson.D{{"platform", platform}, {"token", token}, {"created_at", now}}},
+		}
+
+		var device models.Device
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err = deviceCollection.FindOneAndUpdate(ctx, bson.D{{"platform", platform}, {"token", token}}, update, opts).Decode(&device)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, device)
+	}
+}
+
+// DeleteDevice stops push notifications to a device of the user
+func DeleteDevice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deviceId, err := primitive.ObjectIDFromHex(c.Param("deviceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device id"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		deviceCollection := database.OpenCollection(database.Client, "device")
+		res, err := deviceCollection.DeleteOne(ctx, bson.D{{"_id", deviceId}, {"user", userId}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,487 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/digest"
+	"github.com/pmohanj/web-chat-app/helpers"
+	"github.com/pmohanj/web-chat-app/mail"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/notify"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	digestChats    = 10               // most chats a digest lists
+	digestMessages = 3                // latest messages shown of each chat
+	digestLease    = 10 * time.Minute // how long a digest being sent is held
+	digestRetry    = 30 * time.Minute // how long after a failed digest it's tried again
+)
+
+var (
+	// DigestIdle is how long users must have been away before they're
+	// emailed digests
+	DigestIdle = 24 * time.Hour
+	// AppURL is where digests link to for reading the messages
+	AppURL = "http://localhost:3000"
+)
+
+// InitDigests reads how long users must have been away before they get
+// digests from DIGEST_IDLE, a duration like "12h", and the address of the
+// frontend digests link to from APP_URL. Missing values keep the defaults
+func InitDigests() {
+	if raw := os.Getenv("DIGEST_IDLE"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			log.Fatalf("DIGEST_IDLE must be a positive duration, got %q", raw)
+		}
+		DigestIdle = d
+	}
+	if url := os.Getenv("APP_URL"); url != "" {
+		AppURL = url
+	}
+}
+
+// GetDigestSettings returns how often the user gets digest emails and
+// their quiet hours
+func GetDigestSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var user models.User
+		userCollection := database.OpenCollection(database.Client, "user")
+		opts := options.FindOne().SetProjection(bson.D{{"digest", 1}})
+		if err := userCollection.FindOne(ctx, bson.D{{"_id", userId}}, opts).Decode(&user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, digestSettings(user))
+	}
+}
+
+// UpdateDigestSettings sets how often the user gets digest emails, "off",
+// "daily" or "weekly", and optionally the quietStart and quietEnd "HH:MM"
+// times in timezone during which none are sent
+func UpdateDigestSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+		frequency, _ := reqData["frequency"].(string)
+		quietStart, _ := reqData["quietStart"].(string)
+		quietEnd, _ := reqData["quietEnd"].(string)
+		timezone, _ := reqData["timezone"].(string)
+
+		switch frequency {
+		case models.DigestOff, models.DigestDaily, models.DigestWeekly:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be off, daily or weekly"})
+			return
+		}
+		if (quietStart == "") != (quietEnd == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quietStart and quietEnd must be set together"})
+			return
+		}
+		if quietStart != "" {
+			_, startErr := notify.ParseClock(quietStart)
+			_, endErr := notify.ParseClock(quietEnd)
+			if startErr != nil || endErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "quietStart and quietEnd must be HH:MM times"})
+				return
+			}
+		}
+		if _, err := time.LoadLocation(timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone"})
+			return
+		}
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// the next digest is worked out again for the new settings
+		set := bson.D{{"digest.frequency", frequency}, {"updated_at", time.Now()}}
+		unset := bson.D{{"digest.next_at", ""}}
+		for _, field := range []struct{ key, value string }{
+			{"digest.quietStart", quietStart},
+			{"digest.quietEnd", quietEnd},
+			{"digest.timezone", timezone},
+		} {
+			if field.value == "" {
+				unset = append(unset, bson.E{field.key, ""})
+			} else {
+				set = append(set, bson.E{field.key, field.value})
+			}
+		}
+
+		var user models.User
+		userCollection := database.OpenCollection(database.Client, "user")
+		opts := options.FindOneAndUpdate().SetProjection(bson.D{{"digest", 1}}).SetReturnDocument(options.After)
+		err := userCollection.FindOneAndUpdate(ctx, bson.D{{"_id", userId}}, bson.D{{"$set", set}, {"$unset", unset}}, opts).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, digestSettings(user))
+	}
+}
+
+// UnsubscribeDigest turns off digests of the user in the link of a digest
+// email. The link is signed, so it works without logging in, and it takes
+// GET from the link and POST from mail clients' one-click unsubscribe
+func UnsubscribeDigest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, sig := c.Query("user"), c.Query("sig")
+		userId, err := primitive.ObjectIDFromHex(user)
+		if err != nil || !digest.Verify(helpers.SECRET_KEY, user, sig) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid unsubscribe link"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		userCollection := database.OpenCollection(database.Client, "user")
+		update := bson.D{
+			{"$set", bson.D{{"digest.frequency", models.DigestOff}, {"updated_at", time.Now()}}},
+			{"$unset", bson.D{{"digest.next_at", ""}}},
+		}
+		res, err := userCollection.UpdateOne(ctx, bson.D{{"_id", userId}}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "You won't get digest emails anymore"})
+	}
+}
+
+// digestSettings returns the digest settings of the user, users without
+// any get daily digests
+func digestSettings(user models.User) models.DigestSettings {
+	settings := models.DigestSettings{}
+	if user.Digest != nil {
+		settings = *user.Digest
+	}
+	if settings.Frequency == "" {
+		settings.Frequency = models.DigestDaily
+	}
+	return settings
+}
+
+// RunDigests emails, every interval, the digests that are due to users who
+// have been away for DigestIdle. It never returns, main runs it in its own
+// goroutine when a mailer is set up
+func RunDigests(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if err := sendDueDigests(ctx, time.Now()); err != nil {
+			log.Println("error while sending digests: ", err)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
+
+// sendDueDigests sends the digest of every user it's due for at now, one at
+// a time. Each user is held for digestLease while theirs is being sent so
+// it's sent only once
+func sendDueDigests(ctx context.Context, now time.Time) error {
+	userCollection := database.OpenCollection(database.Client, "user")
+
+	due := bson.D{
+		{"suspended", bson.D{{"$ne", true}}},
+		{"digest.frequency", bson.D{{"$ne", models.DigestOff}}},
+		{"lastSeenAt", bson.D{{"$lte", now.Add(-DigestIdle)}}},
+		{"$or", bson.A{
+			bson.D{{"digest.next_at", bson.D{{"$exists", false}}}},
+			bson.D{{"digest.next_at", bson.D{{"$lte", now}}}},
+		}},
+	}
+	claim := bson.D{{"$set", bson.D{{"digest.next_at", now.Add(digestLease)}}}}
+	opts := options.FindOneAndUpdate().SetProjection(bson.D{
+		{"name", 1}, {"email", 1}, {"blockedUsers", 1}, {"lastSeenAt", 1}, {"digest", 1},
+	})
+
+	for {
+		var user models.User
+		err := userCollection.FindOneAndUpdate(ctx, due, claim, opts).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		set := bson.D{}
+		next, compiled, err := sendDigest(ctx, user, digestSettings(user), now)
+		if err != nil {
+			log.Println("error while sending digest: ", err)
+			next = now.Add(digestRetry)
+		} else if compiled {
+			set = append(set, bson.E{"digest.last_at", now})
+		}
+		set = append(set, bson.E{"digest.next_at", next})
+
+		if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", user.Id}}, bson.D{{"$set", set}}); err != nil {
+			return err
+		}
+	}
+}
+
+// sendDigest emails the user their messages that are unread at now, if
+// there are any. It returns when the next digest of the user is due, and
+// whether the messages up to now were compiled, which they aren't while the
+// user is connected, within their quiet hours or do-not-disturb, or muted
+func sendDigest(ctx context.Context, user models.User, settings models.DigestSettings, now time.Time) (time.Time, bool, error) {
+	period := settings.Period()
+	if websocket.Connected(user.Id) {
+		return now.Add(period), false, nil
+	}
+	if until := digest.QuietUntil(settings, now); !until.IsZero() {
+		return until, false, nil
+	}
+
+	// users who muted all chats or are within do-not-disturb aren't emailed
+	// either, the digest waits for the end of it
+	global, chatSettings, err := userNotificationSettings(ctx, user.Id)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if global != nil && global.IsMuted(now) {
+		if global.Muted_until.IsZero() {
+			return now.Add(period), false, nil
+		}
+		return global.Muted_until, false, nil
+	}
+	if global != nil && global.DND != nil {
+		if until := notify.QuietUntil(*global.DND, now); !until.IsZero() {
+			return until, false, nil
+		}
+	}
+
+	// digests cover at most one period, and not what the user saw already
+	since := now.Add(-period)
+	for _, t := range []time.Time{user.LastSeenAt, settings.Last_at} {
+		if t.After(since) {
+			since = t
+		}
+	}
+
+	chats, err := unreadChats(ctx, user, global, chatSettings, since, now)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(chats) == 0 {
+		return now.Add(period), true, nil
+	}
+
+	d := digest.Digest{
+		Name:           user.Name,
+		Chats:          chats,
+		AppURL:         AppURL,
+		UnsubscribeURL: digest.UnsubscribeURL(os.Getenv("PUBLIC_URL"), helpers.SECRET_KEY, user.Id.Hex()),
+	}
+	subject, text, html, err := digest.Render(d)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	to := netmail.Address{Name: user.Name, Address: user.Email}
+	err = mail.Default.Send(ctx, mail.Message{
+		To:      to.String(),
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + d.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return now.Add(period), true, nil
+}
+
+// unreadChats returns the chats of the user with messages sent to them
+// after since and up to now, with the latest chats first. Messages of users
+// they blocked, deleted ones and chats they muted are left out, and of chats
+// they're only notified of mentions in, messages not mentioning them or
+// matching their keywords. global and chatSettings are the notification
+// settings of the user
+func unreadChats(ctx context.Context, user models.User, global *models.NotificationSetting, chatSettings map[primitive.ObjectID]*models.NotificationSetting, since, now time.Time) ([]digest.Chat, error) {
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	opts := options.Find().SetProjection(bson.D{{"chatName", 1}, {"isGroupChat", 1}, {"users", 1}, {"cleared", 1}})
+	cursor, err := chatCollection.Find(ctx, bson.D{{"users", user.Id}, {"deleted_at", bson.D{{"$exists", false}}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var chats []models.Chat
+	if err := cursor.All(ctx, &chats); err != nil {
+		return nil, err
+	}
+
+	// messages before the user deleted a chat for themselves aren't theirs
+	byId := make(map[primitive.ObjectID]models.Chat)
+	inChats := bson.A{}
+	for _, chat := range chats {
+		setting := chatSettings[chat.Id]
+		if setting != nil && setting.IsMuted(now) {
+			continue
+		}
+		after := since
+		if clearedAt, cleared := chat.Cleared[user.Id.Hex()]; cleared && clearedAt.After(after) {
+			after = clearedAt
+		}
+		filter := bson.D{{"chat", chat.Id}, {"created_at", bson.D{{"$gt", after}}}}
+		if notify.Level(global, setting) == models.NotifyMentions {
+			filter = append(filter, bson.E{"$or", alertFilter(user.Id, global, setting)})
+		}
+		byId[chat.Id] = chat
+		inChats = append(inChats, filter)
+	}
+	if len(inChats) == 0 {
+		return nil, nil
+	}
+
+	matchStage := bson.D{{"$match", bson.D{
+		{"$or", inChats},
+		{"created_at", bson.D{{"$lte", now}}},
+		{"sender", bson.D{{"$nin", append([]primitive.ObjectID{user.Id}, user.BlockedUsers...)}}},
+		{"type", bson.D{{"$ne", models.MessageSystem}}},
+		{"deleted_at", bson.D{{"$exists", false}}},
+		{"hidden." + user.Id.Hex(), bson.D{{"$exists", false}}},
+	}}}
+	sortStage := bson.D{{"$sort", bson.D{{"created_at", -1}}}}
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", "$chat"},
+		{"unread", bson.D{{"$sum", 1}}},
+		{"latest", bson.D{{"$push", bson.D{{"sender", "$sender"}, {"content", "$content"}, {"type", "$type"}}}}},
+		{"last", bson.D{{"$first", "$created_at"}}},
+	}}}
+	latestStage := bson.D{{"$sort", bson.D{{"last", -1}}}}
+	limitStage := bson.D{{"$limit", digestChats}}
+	sliceStage := bson.D{{"$project", bson.D{{"unread", 1}, {"latest", bson.D{{"$slice", bson.A{"$latest", digestMessages}}}}}}}
+
+	messageCollection := database.OpenCollection(database.Client, "message")
+	cursor, err = messageCollection.Aggregate(ctx, mongo.Pipeline{matchStage, sortStage, groupStage, latestStage, limitStage, sliceStage})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Chat   primitive.ObjectID `bson:"_id"`
+		Unread int                `bson:"unread"`
+		Latest []models.Message   `bson:"latest"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	// direct chats are named after the other user
+	userIds := []primitive.ObjectID{}
+	for _, group := range groups {
+		for _, message := range group.Latest {
+			userIds = append(userIds, message.Sender)
+		}
+		if chat := byId[group.Chat]; !chat.IsGroupChat {
+			userIds = append(userIds, chat.Users...)
+		}
+	}
+	names, err := userNames(ctx, userIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	unread := make([]digest.Chat, 0, len(groups))
+	for _, group := range groups {
+		chat := byId[group.Chat]
+		digestChat := digest.Chat{Name: chat.ChatName, Unread: group.Unread}
+		if !chat.IsGroupChat {
+			for _, member := range chat.Users {
+				if member != user.Id {
+					digestChat.Name = names[member]
+				}
+			}
+		}
+		for _, message := range group.Latest {
+			digestChat.Messages = append(digestChat.Messages, digest.Message{Sender: names[message.Sender], Content: pushBody(message)})
+		}
+		unread = append(unread, digestChat)
+	}
+	return unread, nil
+}
+
+// userNotificationSettings returns the notification setting of the user for
+// all chats, nil if they have none, and their settings by chat
+func userNotificationSettings(ctx context.Context, userId primitive.ObjectID) (*models.NotificationSetting, map[primitive.ObjectID]*models.NotificationSetting, error) {
+	settingCollection := database.OpenCollection(database.Client, "notificationSetting")
+	cursor, err := settingCollection.Find(ctx, bson.D{{"user", userId}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var settings []models.NotificationSetting
+	if err := cursor.All(ctx, &settings); err != nil {
+		return nil, nil, err
+	}
+
+	var global *models.NotificationSetting
+	byChat := make(map[primitive.ObjectID]*models.NotificationSetting)
+	for i := range settings {
+		if settings[i].Chat.IsZero() {
+			global = &settings[i]
+		} else {
+			byChat[settings[i].Chat] = &settings[i]
+		}
+	}
+	return global, byChat, nil
+}
+
+// alertFilter matches the messages that alert the user at the mentions
+// level: the ones mentioning them or matching the keywords of the settings
+func alertFilter(userId primitive.ObjectID, settings ...*models.NotificationSetting) bson.A {
+	filter := bson.A{bson.D{{"mentions.recipients", userId}}}
+
+	keywords := []string{}
+	for _, setting := range settings {
+		if setting != nil {
+			keywords = append(keywords, setting.Keywords...)
+		}
+	}
+	if pattern, err := notify.KeywordPattern(keywords); err == nil {
+		filter = append(filter, bson.D{{"content", primitive.Regex{Pattern: pattern}}})
+	}
+	return filter
+}
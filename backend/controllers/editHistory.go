@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EditWindow is how long after sending a message can be edited, zero allows
+// edits at any time
+var EditWindow time.Duration
+
+// InitEditing reads the edit window from the EDIT_WINDOW env variable, a
+// duration like "15m". Missing or "0" lets messages be edited at any time
+func InitEditing() {
+	raw := os.Getenv("EDIT_WINDOW")
+	if raw == "" {
+		return
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		log.Fatalf("EDIT_WINDOW must be a duration, got %q", raw)
+	}
+	EditWindow = d
+	if EditWindow > 0 {
+		log.Printf("messages can be edited for %v", EditWindow)
+	}
+}
+
+// GetMessageHistory returns the current content of the message and its
+// previous versions, oldest first, to members of its chat
+func GetMessageHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// the history of a deleted message goes with it
+		var message models.Message
+		messageCollection := database.OpenCollection(database.Client, "message")
+		err = messageCollection.FindOne(ctx, bson.D{{"_id", messageId}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&message)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		member, err := isChatMember(ctx, message.Chat, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if !member {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+
+		versionCollection := database.OpenCollection(database.Client, "messageVersion")
+		opts := options.Find().SetSort(bson.D{{"replaced_at", 1}, {"_id", 1}})
+		cursor, err := versionCollection.Find(ctx, bson.D{{"message", messageId}}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		versions := []models.MessageVersion{}
+		if err := cursor.All(ctx, &versions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"_id":        message.Id,
+			"content":    message.Content,
+			"isedited":   message.IsEdited,
+			"updated_at": message.Updated_at,
+			"versions":   versions,
+		})
+	}
+}
+
+// saveMessageVersion keeps the content the message had before the edit made
+// at replacedAt
+func saveMessageVersion(ctx context.Context, message models.Message, replacedAt time.Time) error {
+	version := models.MessageVersion{
+		Message:     message.Id,
+		Chat:        message.Chat,
+		Content:     message.Content,
+		Created_at:  message.Updated_at,
+		Replaced_at: replacedAt,
+	}
+
+	versionCollection := database.OpenCollection(database.Client, "messageVersion")
+	_, err := versionCollection.InsertOne(ctx, version)
+	return err
+}
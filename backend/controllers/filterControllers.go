@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/filter"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetChatFilters returns the content filter rules of the group chat, only
+// its admins can see them
+func GetChatFilters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"filters": filterRules(chat.Filters)})
+	}
+}
+
+// UpdateChatFilters replaces the content filter rules of the group chat with
+// the filters of the request. They run after the rules of its workspace
+func UpdateChatFilters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		rules, ok := bindFilterRules(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		update := bson.D{{"$set", bson.D{{"filters", rules}}}}
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"filters": rules})
+	}
+}
+
+// GetWorkspaceFilters returns the content filter rules of the workspace,
+// only its admins can see them
+func GetWorkspaceFilters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceId, err := primitive.ObjectIDFromHex(c.Param("workspaceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		workspace, ok := requireWorkspaceRole(ctx, c, workspaceId, models.RoleAdmin)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"filters": filterRules(workspace.Filters)})
+	}
+}
+
+// UpdateWorkspaceFilters replaces the content filter rules applied to every
+// chat of the workspace with the filters of the request
+func UpdateWorkspaceFilters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceId, err := primitive.ObjectIDFromHex(c.Param("workspaceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+			return
+		}
+
+		rules, ok := bindFilterRules(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireWorkspaceRole(ctx, c, workspaceId, models.RoleAdmin); !ok {
+			return
+		}
+
+		workspaceCollection := database.OpenCollection(database.Client, "workspace")
+		update := bson.D{{"$set", bson.D{{"filters", rules}, {"updated_at", time.Now()}}}}
+		if _, err := workspaceCollection.UpdateOne(ctx, bson.D{{"_id", workspaceId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"filters": rules})
+	}
+}
+
+// bindFilterRules reads and validates the filters of the request body,
+// writing an error response and returning false when they are invalid
+func bindFilterRules(c *gin.Context) ([]models.FilterRule, bool) {
+	var reqData struct {
+		Filters []models.FilterRule `json:"filters"`
+	}
+	if err := c.BindJSON(&reqData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+		return nil, false
+	}
+
+	if _, err := filter.New(reqData.Filters, nil); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return filterRules(reqData.Filters), true
+}
+
+// filterRules returns rules, or an empty list instead of nil so that clients
+// always get an array
+func filterRules(rules []models.FilterRule) []models.FilterRule {
+	if rules == nil {
+		return []models.FilterRule{}
+	}
+	return rules
+}
+
+// runFilters passes a message for the chat through the content filters of its
+// workspace and then its own. It writes an error response and returns false
+// when the message is rejected
+func runFilters(ctx context.Context, c *gin.Context, chat models.Chat, in filter.Input) (filter.Outcome, bool) {
+	outcome, err := filterMessage(ctx, chat, in)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return outcome, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while filtering message"})
+		log.Println(err)
+		return outcome, false
+	}
+	if outcome.Rejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message rejected, it " + outcome.Reason})
+		return outcome, false
+	}
+	return outcome, true
+}
+
+// filterMessage is runFilters for callers without a request, rejected
+// messages come back with Rejected set rather than an error
+func filterMessage(ctx context.Context, chat models.Chat, in filter.Input) (filter.Outcome, error) {
+	rules := chat.Filters
+	if !chat.Workspace.IsZero() {
+		var workspace models.Workspace
+		workspaceCollection := database.OpenCollection(database.Client, "workspace")
+		if err := workspaceCollection.FindOne(ctx, bson.D{{"_id", chat.Workspace}}).Decode(&workspace); err != nil {
+			return filter.Outcome{}, err
+		}
+		rules = append(append([]models.FilterRule{}, workspace.Filters...), chat.Filters...)
+	}
+	if len(rules) == 0 {
+		return filter.Outcome{Content: in.Content}, nil
+	}
+
+	pipeline, err := filter.New(rules, messageHistory{})
+	if err != nil {
+		return filter.Outcome{}, err
+	}
+	return pipeline.Run(ctx, in)
+}
+
+// reportFlaggedMessage files a report for the moderators about a message the
+// content filters flagged. Errors are only logged, as the message is stored
+func reportFlaggedMessage(ctx context.Context, message models.Message, flags []string) {
+	report := models.Report{
+		Kind:       models.ReportMessage,
+		User:       message.Sender,
+		Message:    message.Id,
+		Chat:       message.Chat,
+		Content:    message.Content,
+		Reason:     models.ReasonFilter,
+		Details:    "Message " + strings.Join(flags, ", "),
+		Status:     models.ReportOpen,
+		Created_at: time.Now(),
+	}
+
+	reportCollection := database.OpenCollection(database.Client, "report")
+	if _, err := reportCollection.InsertOne(ctx, report); err != nil {
+		log.Println("Error while reporting flagged message ", err)
+	}
+}
+
+// messageHistory looks up earlier messages for the content filters
+type messageHistory struct{}
+
+func (messageHistory) CountSame(ctx context.Context, chat, sender primitive.ObjectID, content string, since time.Time) (int64, error) {
+	messageCollection := database.OpenCollection(database.Client, "message")
+	return messageCollection.CountDocuments(ctx, bson.D{
+		{"chat", chat},
+		{"sender", sender},
+		{"content", content},
+		{"created_at", bson.D{{"$gte", since}}},
+	})
+}
@@ -0,0 +1,517 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/helpers"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateInvite creates an invite token for the group. The optional expiresIn
+// (seconds), maxUses and requiresApproval fields limit how it can be used
+func CreateInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+
+		token, err := helpers.RandomToken(16)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating invite"})
+			log.Println(err)
+			return
+		}
+
+		invite := models.Invite{
+			Token:      token,
+			Chat:       chatId,
+			CreatedBy:  c.MustGet("_id").(primitive.ObjectID),
+			Created_at: time.Now(),
+		}
+
+		// json numbers are decoded as float64
+		if expiresIn, ok := reqData["expiresIn"].(float64); ok {
+			if expiresIn <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "expiresIn must be a positive number of seconds"})
+				return
+			}
+			invite.ExpiresAt = invite.Created_at.Add(time.Duration(expiresIn) * time.Second)
+		}
+		if maxUses, ok := reqData["maxUses"].(float64); ok {
+			if maxUses < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "maxUses can't be negative"})
+				return
+			}
+			invite.MaxUses = int(maxUses)
+		}
+		invite.RequiresApproval, _ = reqData["requiresApproval"].(bool)
+
+		inviteCollection := database.OpenCollection(database.Client, "invite")
+		insId, err := inviteCollection.InsertOne(ctx, invite)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating invite"})
+			log.Println(err)
+			return
+		}
+		invite.Id = insId.InsertedID.(primitive.ObjectID)
+
+		c.JSON(http.StatusOK, invite)
+	}
+}
+
+// GetGroupInvites lists the invites of the group that can still be used
+func GetGroupInvites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+
+		inviteCollection := database.OpenCollection(database.Client, "invite")
+		cursor, err := inviteCollection.Find(ctx, bson.D{{"chat", chatId}, {"revoked", false}},
+			options.Find().SetSort(bson.D{{"created_at", -1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		var invites []models.Invite
+		if err := cursor.All(ctx, &invites); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		now := time.Now()
+		results := []models.Invite{}
+		for _, invite := range invites {
+			if invite.Usable(now) {
+				results = append(results, invite)
+			}
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// RevokeInvite stops an invite of the group from being used
+func RevokeInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		inviteId, err := primitive.ObjectIDFromHex(c.Param("inviteId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+
+		inviteCollection := database.OpenCollection(database.Client, "invite")
+		res, err := inviteCollection.UpdateOne(ctx, bson.D{{"_id", inviteId}, {"chat", chatId}},
+			bson.D{{"$set", bson.D{{"revoked", true}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// GetInvite shows which group an invite token leads to before joining it
+func GetInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		invite, chat, ok := findUsableInvite(ctx, c, c.Param("token"))
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"chat":             chat.Id,
+			"chatName":         chat.ChatName,
+			"members":          len(chat.Users),
+			"requiresApproval": invite.RequiresApproval,
+			"expiresAt":        invite.ExpiresAt,
+		})
+	}
+}
+
+// JoinByInvite adds the user to the group of the invite token. When the invite
+// requires approval a join request is queued for the admins instead, and
+// 202 Accepted is returned
+func JoinByInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		invite, chat, ok := findUsableInvite(ctx, c, c.Param("token"))
+		if !ok {
+			return
+		}
+
+		if chat.RoleOf(userId) != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You're already a member of this group"})
+			return
+		}
+		if !requireWorkspaceMembers(ctx, c, chat.Workspace, userId) {
+			return
+		}
+
+		joinRequestCollection := database.OpenCollection(database.Client, "joinRequest")
+		if invite.RequiresApproval {
+			pending, err := joinRequestCollection.CountDocuments(ctx, bson.D{
+				{"user", userId}, {"chat", chat.Id}, {"status", models.JoinRequestPending},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+				log.Println(err)
+				return
+			}
+			if pending > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "You've already requested to join this group"})
+				return
+			}
+		}
+
+		// count the use atomically so concurrent joins can't exceed maxUses
+		inviteCollection := database.OpenCollection(database.Client, "invite")
+		filter := bson.D{
+			{"_id", invite.Id},
+			{"revoked", false},
+			{"$or", bson.A{
+				bson.D{{"maxUses", 0}},
+				bson.D{{"$expr", bson.D{{"$lt", bson.A{"$uses", "$maxUses"}}}}},
+			}},
+		}
+		res, err := inviteCollection.UpdateOne(ctx, filter, bson.D{{"$inc", bson.D{{"uses", 1}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.ModifiedCount == 0 {
+			c.JSON(http.StatusGone, gin.H{"error": "Invite is no longer valid"})
+			return
+		}
+
+		if invite.RequiresApproval {
+			request := models.JoinRequest{
+				Chat:       chat.Id,
+				User:       userId,
+				Invite:     invite.Id,
+				Status:     models.JoinRequestPending,
+				Created_at: time.Now(),
+			}
+			insId, err := joinRequestCollection.InsertOne(ctx, request)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating join request"})
+				log.Println(err)
+				return
+			}
+			request.Id = insId.InsertedID.(primitive.ObjectID)
+
+			c.JSON(http.StatusAccepted, request)
+			return
+		}
+
+		if err := addGroupMember(ctx, chat.Id, userId); errors.Is(err, errAlreadyMember) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You're already a member of this group"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		postSystemMessage(ctx, chat.Id, models.SystemEvent{
+			Kind:  models.EventMemberJoined,
+			Actor: userId,
+		})
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMemberJoined, Target: userId, Chat: chat.Id, Details: "invite link"})
+
+		sendGroupChat(ctx, c, chat.Id)
+	}
+}
+
+// GetJoinRequests lists the pending join requests of the group along with
+// the public profile of the requesting users
+func GetJoinRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+
+		matchStage := bson.D{{"$match", bson.D{{"chat", chatId}, {"status", models.JoinRequestPending}}}}
+
+		lookupStage := LookUpStage("user", "user", "_id", "user")
+
+		projectStage := bson.D{
+			{
+				"$project", bson.D{
+					{"chat", 1},
+					{"status", 1},
+					{"created_at", 1},
+					{"user._id", 1},
+					{"user.name", 1},
+					{"user.email", 1},
+					{"user.pic", 1},
+				},
+			},
+		}
+
+		joinRequestCollection := database.OpenCollection(database.Client, "joinRequest")
+		cursor, err := joinRequestCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// DecideJoinRequest approves or rejects a pending join request, given as
+// action "approve" or "reject". Approved users are added to the group, as
+// long as they still belong to its workspace and neither they nor the admin
+// blocked the other
+func DecideJoinRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		requestId, err := primitive.ObjectIDFromHex(c.Param("requestId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		var status string
+		switch reqData["action"] {
+		case "approve":
+			status = models.JoinRequestApproved
+		case "reject":
+			status = models.JoinRequestRejected
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be approve or reject"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin)
+		if !ok {
+			return
+		}
+		actorId := c.MustGet("_id").(primitive.ObjectID)
+
+		// only a pending request can be decided, and only once
+		joinRequestCollection := database.OpenCollection(database.Client, "joinRequest")
+		filter := bson.D{{"_id", requestId}, {"chat", chatId}, {"status", models.JoinRequestPending}}
+
+		if status == models.JoinRequestApproved {
+			// the user may have left the workspace or been blocked since
+			// they requested to join
+			var pending models.JoinRequest
+			err := joinRequestCollection.FindOne(ctx, filter).Decode(&pending)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Pending join request not found"})
+				return
+			} else if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+				log.Println(err)
+				return
+			}
+			if !requireWorkspaceMembers(ctx, c, chat.Workspace, pending.User) {
+				return
+			}
+			blocked, err := isBlocked(ctx, actorId, pending.User)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+				log.Println(err)
+				return
+			}
+			if blocked {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You can't add this user"})
+				return
+			}
+		}
+
+		update := bson.D{{"$set", bson.D{
+			{"status", status},
+			{"decidedBy", actorId},
+			{"decided_at", time.Now()},
+		}}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var request models.JoinRequest
+		err = joinRequestCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&request)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pending join request not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		if status == models.JoinRequestApproved {
+			// users who joined meanwhile keep their role
+			err := addGroupMember(ctx, chatId, request.User)
+			if errors.Is(err, errAlreadyMember) {
+				c.JSON(http.StatusOK, request)
+				return
+			} else if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+				log.Println(err)
+				return
+			}
+
+			postSystemMessage(ctx, chatId, models.SystemEvent{
+				Kind:   models.EventMemberAdded,
+				Actor:  request.DecidedBy,
+				Target: request.User,
+			})
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMemberAdded, Target: request.User, Chat: chatId, Details: "join request approved"})
+		}
+
+		c.JSON(http.StatusOK, request)
+	}
+}
+
+// findUsableInvite looks up the invite by token along with its group chat.
+// It writes an error response and returns false when the invite doesn't
+// exist or can't be used anymore
+func findUsableInvite(ctx context.Context, c *gin.Context, token string) (models.Invite, models.Chat, bool) {
+	var invite models.Invite
+	var chat models.Chat
+
+	inviteCollection := database.OpenCollection(database.Client, "invite")
+	err := inviteCollection.FindOne(ctx, bson.D{{"token", token}}).Decode(&invite)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return invite, chat, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return invite, chat, false
+	}
+
+	if !invite.Usable(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "Invite is no longer valid"})
+		return invite, chat, false
+	}
+
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	err = chatCollection.FindOne(ctx, bson.D{{"_id", invite.Chat}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&chat)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return invite, chat, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return invite, chat, false
+	}
+	return invite, chat, true
+}
+
+// errAlreadyMember is returned by addGroupMember for users who are members
+// of the group already
+var errAlreadyMember = errors.New("already a member")
+
+// addGroupMember adds the user to the group chat with the member role. Users
+// who are members already keep their role, errAlreadyMember is returned
+func addGroupMember(ctx context.Context, chatId, userId primitive.ObjectID) error {
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	filter := bson.D{{"_id", chatId}, {"users", bson.D{{"$ne", userId}}}}
+	update := bson.D{
+		{"$addToSet", bson.D{{"users", userId}}},
+		{"$set", bson.D{{"roles." + userId.Hex(), models.RoleMember}}},
+	}
+	res, err := chatCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errAlreadyMember
+	}
+	return nil
+}
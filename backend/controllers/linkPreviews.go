@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/unfurl"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxPreviews bounds how many links of a message get a preview
+const maxPreviews = 3
+
+// unfurlLinks fetches previews of the links in the message in the
+// background, stores them on it and pushes them to the clients of its chat.
+// They're dropped if the message was edited or deleted meanwhile, the edit
+// unfurls its own links
+func unfurlLinks(message models.Message) {
+	if unfurl.Links == nil || message.Type == models.MessageSystem {
+		return
+	}
+	links := unfurl.ExtractURLs(message.Content, maxPreviews)
+	if len(links) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		previews := []models.LinkPreview{}
+		for _, link := range links {
+			preview, err := unfurl.Links.Unfurl(ctx, link)
+			if err != nil {
+				if !errors.Is(err, unfurl.ErrNoPreview) && !errors.Is(err, unfurl.ErrNotHTML) {
+					log.Println("error while unfurling link: ", err)
+				}
+				continue
+			}
+			previews = append(previews, preview)
+		}
+		if len(previews) == 0 {
+			return
+		}
+
+		messageCollection := database.OpenCollection(database.Client, "message")
+		filter := bson.D{
+			{"_id", message.Id},
+			{"updated_at", message.Updated_at},
+			{"deleted_at", bson.D{{"$exists", false}}},
+		}
+		result, err := messageCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{{"previews", previews}}}})
+		if err != nil {
+			log.Println("error while saving link previews: ", err)
+			return
+		}
+		if result.MatchedCount == 0 {
+			return
+		}
+
+		websocket.Publish(message.Chat.Hex(), map[string]interface{}{
+			"messageType": "linkPreviews",
+			"messageId":   message.Id,
+			"previews":    previews,
+		})
+	}()
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/mention"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errMentionNotMember is returned when a message mentions a user outside of
+// its chat
+var errMentionNotMember = errors.New("mentioned user is not a member of the chat")
+
+// GetMentions lists the messages that mentioned the user, newest first,
+// across the chats they are in. Results are paginated with page and limit,
+// the total is sent in X-Total-Count
+func GetMentions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// mentions in chats the user left or that were deleted aren't listed
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		chatIds, err := chatCollection.Distinct(ctx, "_id", bson.D{{"users", userId}, {"deleted_at", bson.D{{"$exists", false}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		filter := bson.D{
+			{"mentions.recipients", userId},
+			{"chat", bson.D{{"$in", chatIds}}},
+			{"deleted_at", bson.D{{"$exists", false}}},
+			{"hidden." + userId.Hex(), bson.D{{"$exists", false}}},
+		}
+
+		messageCollection := database.OpenCollection(database.Client, "message")
+
+		total, err := messageCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		pipeline := mongo.Pipeline{
+			bson.D{{"$match", filter}},
+			bson.D{{"$sort", bson.D{{"created_at", -1}, {"_id", -1}}}},
+			bson.D{{"$skip", (page - 1) * limit}},
+			bson.D{{"$limit", limit}},
+			publicProfileLookup("sender", "sender"),
+		}
+		pipeline = append(pipeline, tombstoneStage()...)
+
+		cursor, err := messageCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// bindMentions resolves the mentions in content for a message of the chat.
+// It writes an error response and returns false when a mentioned user isn't
+// a member of the chat
+func bindMentions(c *gin.Context, chat models.Chat, senderId primitive.ObjectID, content string) (*models.Mentions, bool) {
+	mentions, err := resolveMentions(chat, senderId, content)
+	if errors.Is(err, errMentionNotMember) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only members of the chat can be mentioned"})
+		return nil, false
+	}
+	return mentions, true
+}
+
+// resolveMentions parses the mentions in content and works out who they
+// notify: the mentioned users, every member for @all and the members online
+// for @here, never the sender. It returns nil when nothing is mentioned
+func resolveMentions(chat models.Chat, senderId primitive.ObjectID, content string) (*models.Mentions, error) {
+	parsed := mention.Parse(content)
+	if parsed.Empty() {
+		return nil, nil
+	}
+
+	members := make(map[primitive.ObjectID]bool, len(chat.Users))
+	for _, userId := range chat.Users {
+		members[userId] = true
+	}
+
+	mentions := &models.Mentions{
+		Users:      []primitive.ObjectID{},
+		All:        parsed.All,
+		Here:       parsed.Here,
+		Recipients: []primitive.ObjectID{},
+	}
+	notified := make(map[primitive.ObjectID]bool)
+	notify := func(userId primitive.ObjectID) {
+		if userId != senderId && !notified[userId] {
+			notified[userId] = true
+			mentions.Recipients = append(mentions.Recipients, userId)
+		}
+	}
+
+	for _, userId := range parsed.Users {
+		if !members[userId] {
+			return nil, errMentionNotMember
+		}
+		mentions.Users = append(mentions.Users, userId)
+		notify(userId)
+	}
+	for _, userId := range chat.Users {
+		if parsed.All || (parsed.Here && websocket.Connected(userId)) {
+			notify(userId)
+		}
+	}
+	return mentions, nil
+}
+
+// notifyMentions sends a mention event to the users the message mentions,
+// leaving out those the previous version of the message already notified
+func notifyMentions(message models.Message, previous *models.Mentions) {
+	if message.Mentions == nil {
+		return
+	}
+
+	notified := make(map[primitive.ObjectID]bool)
+	if previous != nil {
+		for _, userId := range previous.Recipients {
+			notified[userId] = true
+		}
+	}
+	for _, userId := range message.Mentions.Recipients {
+		if notified[userId] {
+			continue
+		}
+		websocket.PublishToUser(userId.Hex(), map[string]interface{}{
+			"messageType": "mention",
+			"sender":      message.Sender,
+			"message":     message,
+		})
+	}
+}
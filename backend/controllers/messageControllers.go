@@ -2,19 +2,27 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/filter"
+	"github.com/pmohanj/web-chat-app/markdown"
 	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/search"
+	"github.com/pmohanj/web-chat-app/websocket"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// SendMessage sends a text message to the chat. With format markdown the
+// content is also stored rendered to sanitized HTML
 func SendMessage() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqData map[string]interface{}
@@ -26,6 +34,10 @@ func SendMessage() gin.HandlerFunc {
 
 		cId := reqData["chatId"].(string)
 		content := reqData["content"].(string)
+		format, ok := messageFormat(c, reqData["format"], content)
+		if !ok {
+			return
+		}
 
 		chatId, err := primitive.ObjectIDFromHex(cId)
 		if err != nil {
@@ -39,50 +51,107 @@ func SendMessage() gin.HandlerFunc {
 		}
 
 		senderId := sId.(primitive.ObjectID)
-		newMessage := models.Message{
-			Sender:  senderId,
-			Content: content,
-			Chat:    chatId,
-		}
-
-		// get the message collection
-		messageCollection := database.OpenCollection(database.Client, "message")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		insId, err := messageCollection.InsertOne(ctx, newMessage)
-		insertedId := insId.InsertedID.(primitive.ObjectID)
-
-		// get chat collection to update the latestMessage field
-		chatCollection := database.OpenCollection(database.Client, "chat")
-
-		filter := bson.D{{"_id", chatId}}
-		update := bson.D{{"$set", bson.D{{"latestMessage", insertedId}}}}
-		_, err = chatCollection.UpdateOne(ctx, filter, update)
-
-		// get the inserted message document, and send it to client
-		matchStage := MatchStageBySingleField("_id", insertedId)
+		// blocked users can't message each other in their direct chat
+		chat, ok := canSendToChat(ctx, c, chatId, senderId)
+		if !ok {
+			return
+		}
 
-		lookupStage := LookUpStage("user", "sender", "_id", "sender")
+		outcome, ok := runFilters(ctx, c, chat, filter.Input{Chat: chatId, Sender: senderId, Content: content})
+		if !ok {
+			return
+		}
+		mentions, ok := bindMentions(c, chat, senderId, outcome.Content)
+		if !ok {
+			return
+		}
 
-		projectStage := ProjectStage("sender.password", "created_at",
-			"updated_at", "sender.created_at", "sender.updated_at")
+		newMessage := models.Message{
+			Sender:     senderId,
+			Content:    outcome.Content,
+			Chat:       chatId,
+			Type:       models.MessageText,
+			Format:     format,
+			Rendered:   renderContent(format, outcome.Content),
+			Mentions:   mentions,
+			Created_at: time.Now(),
+			Updated_at: time.Now(),
+		}
 
-		cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		result, err := deliverMessage(ctx, newMessage, outcome.Flags)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while retrieving data"})
 			log.Panic(err)
 		}
 
-		var results []bson.M
-		if err := cursor.All(ctx, &results); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while retrieving data"})
-			log.Panic(err)
-		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// deliverMessage stores the new message, indexes it for search, makes it the
+// latest of its chat, pushes it to the connections of the chat, notifies the
+// users it mentions and members who are offline, and starts unfurling its
+// links. It returns the message with its sender's profile
+func deliverMessage(ctx context.Context, newMessage models.Message, flags []string) (bson.M, error) {
+	// get the message collection
+	messageCollection := database.OpenCollection(database.Client, "message")
+
+	insId, err := messageCollection.InsertOne(ctx, newMessage)
+	if err != nil {
+		return nil, err
+	}
+	insertedId := insId.InsertedID.(primitive.ObjectID)
 
-		c.JSON(http.StatusOK, results[0])
+	newMessage.Id = insertedId
+	search.Messages.Add(newMessage)
+	if len(flags) > 0 {
+		reportFlaggedMessage(ctx, newMessage, flags)
+	}
+	notifyMentions(newMessage, nil)
+	notifyMessage(newMessage)
+	unfurlLinks(newMessage)
+	emitWebhookEvent(models.EventMessageCreated, newMessage.Chat, newMessage)
+
+	// get chat collection to update the latestMessage field
+	chatCollection := database.OpenCollection(database.Client, "chat")
+
+	filter := bson.D{{"_id", newMessage.Chat}}
+	update := bson.D{{"$set", bson.D{{"latestMessage", insertedId}}}}
+	if _, err := chatCollection.UpdateOne(ctx, filter, update); err != nil {
+		return nil, err
 	}
+
+	// get the inserted message document
+	matchStage := MatchStageBySingleField("_id", insertedId)
+
+	lookupStage := LookUpStage("user", "sender", "_id", "sender")
+
+	projectStage := ProjectStage("sender.password", "created_at",
+		"updated_at", "sender.created_at", "sender.updated_at")
+
+	// everyone notified through @all is left out
+	recipientsStage := bson.D{{"$project", bson.D{{"mentions.recipients", 0}}}}
+
+	cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("sender"), recipientsStage})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []bson.M
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	websocket.Publish(newMessage.Chat.Hex(), map[string]interface{}{
+		"messageType": "newMessage",
+		"sender":      newMessage.Sender,
+		"message":     results[0],
+	})
+	return results[0], nil
 }
 
 func GetMessages() gin.HandlerFunc {
@@ -93,6 +162,7 @@ func GetMessages() gin.HandlerFunc {
 		if err != nil {
 			log.Panic(err)
 		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
 
 		// get messages collection
 		messageCollection := database.OpenCollection(database.Client, "message")
@@ -100,14 +170,52 @@ func GetMessages() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
+		var chat models.Chat
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		err = chatCollection.FindOne(ctx, bson.D{{"_id", chatId}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&chat)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		member, err := isChatMember(ctx, chatId, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if !member {
+			// don't reveal messages of other chats
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		}
+
 		matchStage := MatchStageBySingleField("chat", chatId)
 
 		lookupStage := LookUpStage("user", "sender", "_id", "sender")
 
-		projectStage := ProjectStage("users.password", "created_at",
-			"updated_at", "users.created_at", "users.updated_at")
+		projectStage := ProjectStage("sender.password", "created_at",
+			"updated_at", "sender.created_at", "sender.updated_at")
+
+		// messages stored before message types existed are text messages,
+		// so clients can tell system messages apart by type alone
+		typeStage := bson.D{
+			{
+				"$addFields", bson.D{
+					{"type", bson.D{{"$ifNull", bson.A{"$type", models.MessageText}}}},
+				},
+			},
+		}
 
-		cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		// messages deleted for everyone are shown as tombstones
+		pipeline := mongo.Pipeline{matchStage, visibleMessagesStage(chat, userId), lookupStage, projectStage, PublicProfileStage("sender"), typeStage}
+		pipeline = append(pipeline, tombstoneStage()...)
+
+		cursor, err := messageCollection.Aggregate(ctx, pipeline)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while retrieving data"})
 			log.Panic(err)
@@ -123,6 +231,9 @@ func GetMessages() gin.HandlerFunc {
 	}
 }
 
+// EditUserMessage replaces the content of the user's own message, keeping the
+// previous content in its edit history. Edits may be limited to the edit
+// window after sending, and are pushed to the websocket clients of the chat
 func EditUserMessage() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var reqData map[string]interface{}
@@ -151,19 +262,93 @@ func EditUserMessage() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		filter := bson.D{{"_id", messageId}}
-		update := bson.D{{"$set", bson.M{"content": content, "isedited": true}}}
+		// edited content goes through the content filters of the chat again
+		var message models.Message
+		err = messageCollection.FindOne(ctx, bson.D{{"_id", messageId}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&message)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if message.Sender != c.MustGet("_id").(primitive.ObjectID) || message.Type == models.MessageSystem {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own messages"})
+			return
+		}
+		if message.Format == models.FormatMarkdown && len(content) > markdown.MaxLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Markdown messages can have at most %d characters", markdown.MaxLength)})
+			return
+		}
+		if EditWindow > 0 && time.Since(message.Created_at) > EditWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The message can no longer be edited"})
+			return
+		}
+		chat, ok := canSendToChat(ctx, c, message.Chat, message.Sender)
+		if !ok {
+			return
+		}
+		outcome, ok := runFilters(ctx, c, chat, filter.Input{Chat: message.Chat, Sender: message.Sender, Content: content, Edit: true})
+		if !ok {
+			return
+		}
+		content = outcome.Content
+		mentions, ok := bindMentions(c, chat, message.Sender, content)
+		if !ok {
+			return
+		}
+
+		// only the version that was read is replaced, so a concurrent edit
+		// can't slip out of the history
+		now := time.Now()
+		filter := bson.D{{"_id", messageId}, {"updated_at", message.Updated_at}}
+		set := bson.M{"content": content, "isedited": true, "updated_at": now}
+		if message.Format == models.FormatMarkdown {
+			set["rendered"] = renderContent(message.Format, content)
+		}
+		// previews of the old links go, the edit unfurls its own
+		unset := bson.D{{"previews", ""}}
+		if mentions != nil {
+			set["mentions"] = mentions
+		} else {
+			unset = append(unset, bson.E{"mentions", ""})
+		}
+		update := bson.D{{"$set", set}, {"$unset", unset}}
 
 		// return the document after it's modified
 		options := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
 		var updatedDoc bson.M
-		err = messageCollection.FindOneAndUpdate(ctx, filter, update, options).Decode(&updatedDoc)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting message"})
+		result := messageCollection.FindOneAndUpdate(ctx, filter, update, options)
+		err = result.Decode(&updatedDoc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The message was changed meanwhile, try again"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating message"})
 			log.Panic(err)
 		}
 
+		if err := saveMessageVersion(ctx, message, now); err != nil {
+			log.Println("error while saving message version: ", err)
+		}
+
+		var editedMessage models.Message
+		if err := result.Decode(&editedMessage); err == nil {
+			search.Messages.Add(editedMessage)
+			if len(outcome.Flags) > 0 {
+				reportFlaggedMessage(ctx, editedMessage, outcome.Flags)
+			}
+			notifyMentions(editedMessage, message.Mentions)
+			unfurlLinks(editedMessage)
+			emitWebhookEvent(models.EventMessageUpdated, editedMessage.Chat, editedMessage)
+			websocket.Publish(editedMessage.Chat.Hex(), map[string]interface{}{
+				"messageType": "messageEdited",
+				"message":     editedMessage,
+			})
+		}
+
 		matchStage := bson.D{
 			{
 				"$match", updatedDoc,
@@ -175,7 +360,10 @@ func EditUserMessage() gin.HandlerFunc {
 		projectStage := ProjectStage("sender.password", "created_at",
 			"updated_at", "sender.created_at", "sender.updated_at")
 
-		cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		pipeline := mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("sender")}
+		pipeline = append(pipeline, tombstoneStage()...)
+
+		cursor, err := messageCollection.Aggregate(ctx, pipeline)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while retrieving data"})
 			log.Panic(err)
@@ -192,28 +380,107 @@ func EditUserMessage() gin.HandlerFunc {
 	}
 }
 
+// DeleteUserMessage deletes the sender's message for everyone, leaving a
+// tombstone in the chat, or with for=me hides any message of the chat from
+// the user only. Both can be undone within the undo window
 func DeleteUserMessage() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		mId := c.Param("messageId")
-
-		messageId, err := primitive.ObjectIDFromHex(mId)
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
 		if err != nil {
-			log.Panic(err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
 		}
+		scope, ok := deletionScope(c)
+		if !ok {
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
 
 		messageCollection := database.OpenCollection(database.Client, "message")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		deleteRes, err := messageCollection.DeleteOne(ctx, bson.D{{"_id", messageId}})
+		var message models.Message
+		err = messageCollection.FindOne(ctx, bson.D{{"_id", messageId}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&message)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		member, err := isChatMember(ctx, message.Chat, userId)
 		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if !member {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+
+		now := time.Now()
+		if scope == deleteForMe {
+			update := bson.D{{"$set", bson.D{{"hidden." + userId.Hex(), now}}}}
+			if _, err := messageCollection.UpdateOne(ctx, bson.D{{"_id", messageId}}, update); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting message"})
+				log.Println(err)
+				return
+			}
+			c.Status(http.StatusOK)
+			return
+		}
+
+		if message.Sender != userId || message.Type == models.MessageSystem {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own messages for everyone"})
+			return
+		}
+
+		update := bson.D{{"$set", bson.D{{"deleted_at", now}, {"deletedBy", userId}}}}
+		if _, err := messageCollection.UpdateOne(ctx, bson.D{{"_id", messageId}}, update); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting message"})
-			log.Panic(err)
+			log.Println(err)
+			return
 		}
+		search.Messages.Remove(messageId)
 
-		log.Println("Documents deleted: ", deleteRes.DeletedCount)
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMessageDeleted, Target: message.Sender, Chat: message.Chat, Message: messageId})
+		websocket.Publish(message.Chat.Hex(), map[string]interface{}{
+			"messageType": "messageDeleted",
+			"messageId":   messageId,
+		})
+		emitWebhookEvent(models.EventMessageDeleted, message.Chat, gin.H{"messageId": messageId, "deletedBy": userId})
 
 		c.Status(http.StatusOK)
 	}
 }
+
+// messageFormat reads the format of a new message, plain by default, and
+// checks markdown content isn't too long to render. It writes an error
+// response and returns false when either is invalid
+func messageFormat(c *gin.Context, value interface{}, content string) (string, bool) {
+	switch format, _ := value.(string); format {
+	case "", models.FormatPlain:
+		return "", true
+	case models.FormatMarkdown:
+		if len(content) > markdown.MaxLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Markdown messages can have at most %d characters", markdown.MaxLength)})
+			return "", false
+		}
+		return format, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "format must be plain or markdown"})
+	return "", false
+}
+
+// renderContent returns the sanitized HTML of markdown content, and nothing
+// for plain content
+func renderContent(format, content string) string {
+	if format != models.FormatMarkdown {
+		return ""
+	}
+	return markdown.Render(content)
+}
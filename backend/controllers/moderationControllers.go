@@ -0,0 +1,342 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/search"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxModerationNoteLength = 1000
+
+// GetReports lists the reports with the status query value, open by default,
+// oldest first along with the public profiles of the reporter and reported
+// user. Results are paginated with page and limit, the total is sent in
+// X-Total-Count
+func GetReports() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := c.DefaultQuery("status", models.ReportOpen)
+		switch status {
+		case models.ReportOpen, models.ReportDismissed, models.ReportActioned:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, dismissed or actioned"})
+			return
+		}
+
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		reportCollection := database.OpenCollection(database.Client, "report")
+
+		filter := bson.D{{"status", status}}
+		total, err := reportCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		matchStage := bson.D{{"$match", filter}}
+		sortStage := bson.D{{"$sort", bson.D{{"created_at", 1}, {"_id", 1}}}}
+		skipStage := bson.D{{"$skip", (page - 1) * limit}}
+		limitStage := bson.D{{"$limit", limit}}
+
+		cursor, err := reportCollection.Aggregate(ctx, mongo.Pipeline{
+			matchStage, sortStage, skipStage, limitStage,
+			publicProfileLookup("reporter", "reporter"),
+			publicProfileLookup("user", "user"),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// ModerateReport resolves an open report with an action: dismiss, deleteMessage,
+// warn or suspend the reported user, and an optional note. Every decision is
+// written to the moderation log
+func ModerateReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportId, err := primitive.ObjectIDFromHex(c.Param("reportId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		action, _ := reqData["action"].(string)
+		switch action {
+		case models.ModerationDismiss, models.ModerationDeleteMessage, models.ModerationWarn, models.ModerationSuspend:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be dismiss, deleteMessage, warn or suspend"})
+			return
+		}
+		note, _ := reqData["note"].(string)
+		if len([]rune(note)) > maxModerationNoteLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("note can be at most %d characters", maxModerationNoteLength)})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		reportCollection := database.OpenCollection(database.Client, "report")
+
+		var report models.Report
+		err = reportCollection.FindOne(ctx, bson.D{{"_id", reportId}, {"status", models.ReportOpen}}).Decode(&report)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Open report not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		if action == models.ModerationDeleteMessage && report.Kind != models.ReportMessage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only reported messages can be deleted"})
+			return
+		}
+		if action == models.ModerationSuspend {
+			target, ok := findUser(ctx, c, report.User)
+			if !ok {
+				return
+			}
+			if target.IsAdmin {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Admins can't be suspended"})
+				return
+			}
+		}
+
+		// claim the report first, so two moderators can't both act on it
+		moderatorId := c.MustGet("_id").(primitive.ObjectID)
+		status := models.ReportActioned
+		if action == models.ModerationDismiss {
+			status = models.ReportDismissed
+		}
+		update := bson.D{{"$set", bson.D{
+			{"status", status},
+			{"action", action},
+			{"moderatedBy", moderatorId},
+			{"resolved_at", time.Now()},
+		}}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = reportCollection.FindOneAndUpdate(ctx, bson.D{{"_id", reportId}, {"status", models.ReportOpen}}, update, opts).Decode(&report)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Open report not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		if err := applyModeration(ctx, report, action, note); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while applying moderation action"})
+			log.Println(err)
+
+			// the action wasn't taken, so the report is open again to retry
+			reopen := bson.D{
+				{"$set", bson.D{{"status", models.ReportOpen}}},
+				{"$unset", bson.D{{"action", ""}, {"moderatedBy", ""}, {"resolved_at", ""}}},
+			}
+			if _, err := reportCollection.UpdateOne(ctx, bson.D{{"_id", reportId}, {"status", status}, {"moderatedBy", moderatorId}}, reopen); err != nil {
+				log.Println("error while reopening report: ", err)
+			}
+			return
+		}
+
+		entry := models.ModerationEntry{
+			Report:     report.Id,
+			Moderator:  moderatorId,
+			Action:     action,
+			Target:     report.User,
+			Message:    report.Message,
+			Note:       note,
+			Created_at: time.Now(),
+		}
+		moderationLogCollection := database.OpenCollection(database.Client, "moderationLog")
+		if _, err := moderationLogCollection.InsertOne(ctx, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while writing moderation log"})
+			log.Println(err)
+			return
+		}
+
+		recordAudit(ctx, c, models.AuditEntry{
+			Action:  models.AuditReportModerated,
+			Target:  report.User,
+			Chat:    report.Chat,
+			Message: report.Message,
+			Details: action,
+		})
+		switch action {
+		case models.ModerationDeleteMessage:
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditMessageDeleted, Target: report.User, Chat: report.Chat, Message: report.Message, Details: "deleted by moderator"})
+		case models.ModerationSuspend:
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditUserSuspended, Target: report.User, Details: "suspended by moderator"})
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetModerationLog lists the decisions of moderators, newest first. It can be
+// narrowed to a moderator or a target user with the moderator and target
+// query values. Results are paginated with page and limit, the total is
+// sent in X-Total-Count
+func GetModerationLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.D{}
+		for _, key := range []string{"moderator", "target"} {
+			value := c.Query(key)
+			if value == "" {
+				continue
+			}
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key + " id"})
+				return
+			}
+			filter = append(filter, bson.E{key, id})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		moderationLogCollection := database.OpenCollection(database.Client, "moderationLog")
+
+		total, err := moderationLogCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{"created_at", -1}, {"_id", -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := moderationLogCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		entries := []models.ModerationEntry{}
+		if err := cursor.All(ctx, &entries); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// applyModeration carries out the action of a moderator on the report
+func applyModeration(ctx context.Context, report models.Report, action, note string) error {
+	switch action {
+	case models.ModerationDeleteMessage:
+		// the message is deleted for everyone like its sender would, and
+		// purged after the retention period
+		messageCollection := database.OpenCollection(database.Client, "message")
+		update := bson.D{{"$set", bson.D{{"deleted_at", report.Resolved_at}, {"deletedBy", report.ModeratedBy}}}}
+		if _, err := messageCollection.UpdateOne(ctx, bson.D{{"_id", report.Message}}, update); err != nil {
+			return err
+		}
+		search.Messages.Remove(report.Message)
+		websocket.Publish(report.Chat.Hex(), map[string]interface{}{
+			"messageType": "messageDeleted",
+			"messageId":   report.Message,
+		})
+
+		// the other open reports of the message are settled by its deletion
+		reportCollection := database.OpenCollection(database.Client, "report")
+		_, err := reportCollection.UpdateMany(ctx,
+			bson.D{{"message", report.Message}, {"status", models.ReportOpen}},
+			bson.D{{"$set", bson.D{
+				{"status", models.ReportActioned},
+				{"action", action},
+				{"moderatedBy", report.ModeratedBy},
+				{"resolved_at", report.Resolved_at},
+			}}},
+		)
+		return err
+
+	case models.ModerationWarn:
+		warning := models.Warning{
+			User:       report.User,
+			Report:     report.Id,
+			Reason:     report.Reason,
+			Note:       note,
+			Created_at: time.Now(),
+		}
+		warningCollection := database.OpenCollection(database.Client, "warning")
+		_, err := warningCollection.InsertOne(ctx, warning)
+		return err
+
+	case models.ModerationSuspend:
+		return setSuspended(ctx, report.User, true)
+	}
+	return nil
+}
+
+// publicProfileLookup joins the public profile of the user whose id is at
+// localField, as an array at field as
+func publicProfileLookup(localField, as string) bson.D {
+	return bson.D{
+		{
+			"$lookup", bson.D{
+				{"from", "user"},
+				{"let", bson.D{{"userId", "$" + localField}}},
+				{"pipeline", bson.A{
+					bson.D{{"$match", bson.D{{"$expr", bson.D{{"$eq", bson.A{"$_id", "$$userId"}}}}}}},
+					bson.D{{"$project", publicProfileProjection()}},
+				}},
+				{"as", as},
+			},
+		},
+	}
+}
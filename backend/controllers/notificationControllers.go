@@ -0,0 +1,471 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/notify"
+	"github.com/pmohanj/web-chat-app/push"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxPushBody bounds how much of a message is shown in its notification
+const maxPushBody = 200
+
+// GetNotificationSettings returns how the user is notified of messages in
+// all chats, along with their do-not-disturb schedule
+func GetNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getNotificationSetting(c, primitive.NilObjectID)
+	}
+}
+
+// UpdateNotificationSettings changes how the user is notified of messages
+// in all chats and their do-not-disturb schedule
+func UpdateNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updateNotificationSetting(c, primitive.NilObjectID)
+	}
+}
+
+// GetChatNotificationSettings returns how the user is notified of messages
+// in the chat
+func GetChatNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, ok := notificationChat(c)
+		if !ok {
+			return
+		}
+		getNotificationSetting(c, chatId)
+	}
+}
+
+// UpdateChatNotificationSettings changes how the user is notified of
+// messages in the chat
+func UpdateChatNotificationSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, ok := notificationChat(c)
+		if !ok {
+			return
+		}
+		updateNotificationSetting(c, chatId)
+	}
+}
+
+// notificationChat reads the chat of the request and checks the user is a
+// member. Otherwise it writes an error response and returns false
+func notificationChat(c *gin.Context) (primitive.ObjectID, bool) {
+	chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return chatId, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	member, err := isChatMember(ctx, chatId, c.MustGet("_id").(primitive.ObjectID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return chatId, false
+	}
+	if !member {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return chatId, false
+	}
+	return chatId, true
+}
+
+// getNotificationSetting responds with the setting of the user for the chat,
+// or for all chats when chatId is nil. Users without one are notified of all
+// messages
+func getNotificationSetting(c *gin.Context, chatId primitive.ObjectID) {
+	userId := c.MustGet("_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	setting := models.NotificationSetting{User: userId, Chat: chatId}
+	settingCollection := database.OpenCollection(database.Client, "notificationSetting")
+	err := settingCollection.FindOne(ctx, settingFilter(userId, chatId)).Decode(&setting)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, settingResponse(setting, time.Now()))
+}
+
+// updateNotificationSetting changes the setting of the user for the chat, or
+// for all chats when chatId is nil, from the fields of the request that are
+// set: muted and until, to mute until then, level, keywords and, for all
+// chats only, dnd, the do-not-disturb schedule or null to remove it
+func updateNotificationSetting(c *gin.Context, chatId primitive.ObjectID) {
+	var reqData map[string]interface{}
+	if err := c.BindJSON(&reqData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+		return
+	}
+
+	set := bson.D{{"updated_at", time.Now()}}
+	unset := bson.D{}
+
+	if raw, exists := reqData["muted"]; exists {
+		muted, ok := raw.(bool)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "muted must be true or false"})
+			return
+		}
+		set = append(set, bson.E{"muted", muted})
+
+		if raw, exists := reqData["until"]; exists && raw != nil && muted {
+			s, _ := raw.(string)
+			until, err := time.Parse(time.RFC3339, s)
+			if err != nil || !until.After(time.Now()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "until must be an RFC3339 time in the future"})
+				return
+			}
+			set = append(set, bson.E{"muted_until", until})
+		} else {
+			unset = append(unset, bson.E{"muted_until", ""})
+		}
+	}
+
+	if raw, exists := reqData["level"]; exists {
+		switch level, _ := raw.(string); level {
+		case models.NotifyAll, models.NotifyMentions:
+			set = append(set, bson.E{"level", level})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "level must be all or mentions"})
+			return
+		}
+	}
+
+	if raw, exists := reqData["keywords"]; exists {
+		items, ok := raw.([]interface{})
+		words := make([]string, 0, len(items))
+		for _, item := range items {
+			word, isString := item.(string)
+			ok = ok && isString
+			words = append(words, word)
+		}
+		keywords, err := notify.CleanKeywords(words)
+		if (raw != nil && !ok) || err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("keywords must be a list of at most %d words", models.MaxKeywords)})
+			return
+		}
+		if len(keywords) == 0 {
+			unset = append(unset, bson.E{"keywords", ""})
+		} else {
+			set = append(set, bson.E{"keywords", keywords})
+		}
+	}
+
+	if raw, exists := reqData["dnd"]; exists {
+		if !chatId.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Do not disturb can only be set for all chats"})
+			return
+		}
+		if raw == nil {
+			unset = append(unset, bson.E{"dnd", ""})
+		} else {
+			dnd, ok := bindQuietHours(raw)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "dnd must have start and end HH:MM times, a timezone and days from 0 (Sunday) to 6"})
+				return
+			}
+			set = append(set, bson.E{"dnd", dnd})
+		}
+	}
+
+	userId := c.MustGet("_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	insert := bson.D{{"user", userId}}
+	if !chatId.IsZero() {
+		insert = append(insert, bson.E{"chat", chatId})
+	}
+	if _, exists := reqData["muted"]; !exists {
+		insert = append(insert, bson.E{"muted", false})
+	}
+	update := bson.D{{"$set", set}, {"$setOnInsert", insert}}
+	if len(unset) > 0 {
+		update = append(update, bson.E{"$unset", unset})
+	}
+
+	var setting models.NotificationSetting
+	settingCollection := database.OpenCollection(database.Client, "notificationSetting")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := settingCollection.FindOneAndUpdate(ctx, settingFilter(userId, chatId), update, opts).Decode(&setting); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, settingResponse(setting, time.Now()))
+}
+
+// bindQuietHours reads a do-not-disturb schedule from the request, it
+// returns false when it isn't valid
+func bindQuietHours(raw interface{}) (models.QuietHours, bool) {
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return models.QuietHours{}, false
+	}
+	var quiet models.QuietHours
+	quiet.Start, _ = fields["start"].(string)
+	quiet.End, _ = fields["end"].(string)
+	quiet.Timezone, _ = fields["timezone"].(string)
+	if days, exists := fields["days"]; exists && days != nil {
+		items, ok := days.([]interface{})
+		if !ok {
+			return quiet, false
+		}
+		for _, item := range items {
+			day, ok := item.(float64)
+			if !ok || day != float64(int(day)) {
+				return quiet, false
+			}
+			quiet.Days = append(quiet.Days, int(day))
+		}
+	}
+	return quiet, notify.CheckQuietHours(quiet) == nil
+}
+
+// settingResponse returns the setting the way clients get it, with its
+// defaults filled in and a mute that ended reading as not muted
+func settingResponse(setting models.NotificationSetting, now time.Time) models.NotificationSetting {
+	if setting.Muted && !setting.IsMuted(now) {
+		setting.Muted, setting.Muted_until = false, time.Time{}
+	}
+	if setting.Level == "" {
+		setting.Level = models.NotifyAll
+	}
+	if setting.Keywords == nil {
+		setting.Keywords = []string{}
+	}
+	return setting
+}
+
+// settingFilter matches the setting of the user for the chat, or the one for
+// all chats, which has no chat, when chatId is nil
+func settingFilter(userId, chatId primitive.ObjectID) bson.D {
+	if chatId.IsZero() {
+		return bson.D{{"user", userId}, {"chat", bson.D{{"$exists", false}}}}
+	}
+	return bson.D{{"user", userId}, {"chat", chatId}}
+}
+
+// notifyMessage notifies the members of the message's chat, other than the
+// sender, the way their notification settings ask for. Members who are
+// connected get a notification event their clients alert with, the others
+// a push notification on the devices they registered unless they're within
+// do-not-disturb. Members who blocked the sender are left out. It runs in
+// the background, and forgets devices providers no longer accept
+func notifyMessage(message models.Message) {
+	if message.Type == models.MessageSystem {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		var chat models.Chat
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		if err := chatCollection.FindOne(ctx, bson.D{{"_id", message.Chat}}).Decode(&chat); err != nil {
+			log.Println("error while notifying of message: ", err)
+			return
+		}
+
+		members := []primitive.ObjectID{}
+		for _, userId := range chat.Users {
+			if userId != message.Sender {
+				members = append(members, userId)
+			}
+		}
+		if len(members) == 0 {
+			return
+		}
+
+		decisions, err := notificationDecisions(ctx, members, message, time.Now())
+		if err != nil {
+			log.Println("error while notifying of message: ", err)
+			return
+		}
+
+		offline := []primitive.ObjectID{}
+		for _, userId := range members {
+			decision := decisions[userId]
+			if !decision.Notify {
+				continue
+			}
+			if websocket.Connected(userId) {
+				event := map[string]interface{}{
+					"messageType": "notification",
+					"reason":      decision.Reason,
+					"silent":      decision.Quiet,
+					"message":     message,
+				}
+				if decision.Keyword != "" {
+					event["keyword"] = decision.Keyword
+				}
+				websocket.PublishToUser(userId.Hex(), event)
+			} else if !decision.Quiet {
+				offline = append(offline, userId)
+			}
+		}
+		if len(offline) == 0 || len(push.Providers) == 0 {
+			return
+		}
+
+		var sender models.User
+		userCollection := database.OpenCollection(database.Client, "user")
+		opts := options.FindOne().SetProjection(bson.D{{"name", 1}})
+		if err := userCollection.FindOne(ctx, bson.D{{"_id", message.Sender}}, opts).Decode(&sender); err != nil {
+			log.Println("error while pushing message: ", err)
+			return
+		}
+
+		notification := push.Notification{
+			Title: sender.Name,
+			Body:  pushBody(message),
+			Data: map[string]string{
+				"type":      "message",
+				"chatId":    message.Chat.Hex(),
+				"messageId": message.Id.Hex(),
+			},
+		}
+		if chat.IsGroupChat {
+			notification.Title = chat.ChatName
+			notification.Body = sender.Name + ": " + notification.Body
+		}
+
+		sendPush(ctx, offline, notification)
+	}()
+}
+
+// notificationDecisions works out how each of the users is notified of the
+// message at now from their settings for its chat and for all chats. Users
+// who blocked its sender aren't notified
+func notificationDecisions(ctx context.Context, userIds []primitive.ObjectID, message models.Message, now time.Time) (map[primitive.ObjectID]notify.Decision, error) {
+	global, chat, err := notificationSettings(ctx, userIds, message.Chat)
+	if err != nil {
+		return nil, err
+	}
+
+	mentioned := make(map[primitive.ObjectID]bool)
+	if message.Mentions != nil {
+		for _, userId := range message.Mentions.Recipients {
+			mentioned[userId] = true
+		}
+	}
+
+	decisions := make(map[primitive.ObjectID]notify.Decision, len(userIds))
+	for _, userId := range userIds {
+		decisions[userId] = notify.Decide(global[userId], chat[userId], message.Content, mentioned[userId], now)
+	}
+
+	userCollection := database.OpenCollection(database.Client, "user")
+	blockers, err := userCollection.Distinct(ctx, "_id", bson.D{{"_id", bson.D{{"$in", userIds}}}, {"blockedUsers", message.Sender}})
+	if err != nil {
+		return nil, err
+	}
+	for _, blocker := range blockers {
+		if userId, ok := blocker.(primitive.ObjectID); ok {
+			decisions[userId] = notify.Decision{}
+		}
+	}
+	return decisions, nil
+}
+
+// notificationSettings returns the settings of the users for all chats and
+// for the chat, by user. Users without one aren't in the maps
+func notificationSettings(ctx context.Context, userIds []primitive.ObjectID, chatId primitive.ObjectID) (global, chat map[primitive.ObjectID]*models.NotificationSetting, err error) {
+	settingCollection := database.OpenCollection(database.Client, "notificationSetting")
+	cursor, err := settingCollection.Find(ctx, bson.D{
+		{"user", bson.D{{"$in", userIds}}},
+		{"$or", bson.A{
+			bson.D{{"chat", chatId}},
+			bson.D{{"chat", bson.D{{"$exists", false}}}},
+		}},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var settings []models.NotificationSetting
+	if err := cursor.All(ctx, &settings); err != nil {
+		return nil, nil, err
+	}
+
+	global = make(map[primitive.ObjectID]*models.NotificationSetting)
+	chat = make(map[primitive.ObjectID]*models.NotificationSetting)
+	for i := range settings {
+		if settings[i].Chat.IsZero() {
+			global[settings[i].User] = &settings[i]
+		} else {
+			chat[settings[i].User] = &settings[i]
+		}
+	}
+	return global, chat, nil
+}
+
+// sendPush sends the notification to every device of the users whose
+// platform is set up, deleting the devices providers no longer accept
+func sendPush(ctx context.Context, userIds []primitive.ObjectID, notification push.Notification) {
+	platforms := []string{}
+	for platform := range push.Providers {
+		platforms = append(platforms, platform)
+	}
+
+	deviceCollection := database.OpenCollection(database.Client, "device")
+	cursor, err := deviceCollection.Find(ctx, bson.D{{"user", bson.D{{"$in", userIds}}}, {"platform", bson.D{{"$in", platforms}}}})
+	if err != nil {
+		log.Println("error while pushing message: ", err)
+		return
+	}
+	var devices []models.Device
+	if err := cursor.All(ctx, &devices); err != nil {
+		log.Println("error while pushing message: ", err)
+		return
+	}
+
+	for _, device := range devices {
+		err := push.Send(ctx, device, notification)
+		if errors.Is(err, push.ErrInvalidToken) {
+			if _, err := deviceCollection.DeleteOne(ctx, bson.D{{"_id", device.Id}}); err != nil {
+				log.Println("error while removing device: ", err)
+			}
+		} else if err != nil {
+			log.Println("error while pushing message: ", err)
+		}
+	}
+}
+
+// pushBody returns the text a notification shows for the message
+func pushBody(message models.Message) string {
+	body := message.Content
+	if message.Type == models.MessagePoll {
+		body = "Poll: " + body
+	}
+	if utf8.RuneCountInString(body) > maxPushBody {
+		body = string([]rune(body)[:maxPushBody-1]) + "…"
+	}
+	return body
+}
@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetPinnedMessages lists the pinned messages of the chat, most recently
+// pinned first, to its members
+func GetPinnedMessages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		member, err := isChatMember(ctx, chatId, c.MustGet("_id").(primitive.ObjectID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if !member {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		}
+
+		pinCollection := database.OpenCollection(database.Client, "pin")
+		cursor, err := pinCollection.Aggregate(ctx, append(mongo.Pipeline{
+			MatchStageBySingleField("chat", chatId),
+			bson.D{{"$sort", bson.D{{"created_at", -1}, {"_id", -1}}}},
+		}, messageLookupStages()...))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// PinMessage pins a message of the chat. In groups only admins can pin, in
+// direct chats either user can
+func PinMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+		mId, _ := reqData["messageId"].(string)
+		messageId, err := primitive.ObjectIDFromHex(mId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requirePinner(ctx, c, chatId); !ok {
+			return
+		}
+
+		messageCollection := database.OpenCollection(database.Client, "message")
+		count, err := messageCollection.CountDocuments(ctx, bson.D{
+			{"_id", messageId},
+			{"chat", chatId},
+			{"deleted_at", bson.D{{"$exists", false}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+
+		pinCollection := database.OpenCollection(database.Client, "pin")
+		pinned, err := pinCollection.CountDocuments(ctx, bson.D{{"chat", chatId}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if pinned >= models.MaxPins {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A chat can have at most %d pinned messages", models.MaxPins)})
+			return
+		}
+
+		// pinning a pinned message again leaves it as it was
+		pin := models.Pin{
+			Chat:       chatId,
+			Message:    messageId,
+			PinnedBy:   c.MustGet("_id").(primitive.ObjectID),
+			Created_at: time.Now(),
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err = pinCollection.FindOneAndUpdate(ctx,
+			bson.D{{"chat", chatId}, {"message", messageId}},
+			bson.D{{"$setOnInsert", pin}},
+			opts,
+		).Decode(&pin)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		websocket.Publish(chatId.Hex(), map[string]interface{}{
+			"messageType": "messagePinned",
+			"pin":         pin,
+		})
+
+		c.JSON(http.StatusOK, pin)
+	}
+}
+
+// UnpinMessage unpins a message of the chat, with the same rights as pinning
+func UnpinMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requirePinner(ctx, c, chatId); !ok {
+			return
+		}
+
+		pinCollection := database.OpenCollection(database.Client, "pin")
+		res, err := pinCollection.DeleteOne(ctx, bson.D{{"chat", chatId}, {"message", messageId}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message is not pinned"})
+			return
+		}
+
+		websocket.Publish(chatId.Hex(), map[string]interface{}{
+			"messageType": "messageUnpinned",
+			"messageId":   messageId,
+		})
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// GetBookmarks lists the messages the user saved, most recently saved first.
+// Results are paginated with page and limit, the total is sent in
+// X-Total-Count
+func GetBookmarks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		bookmarkCollection := database.OpenCollection(database.Client, "bookmark")
+
+		total, err := bookmarkCollection.CountDocuments(ctx, bson.D{{"user", userId}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		cursor, err := bookmarkCollection.Aggregate(ctx, append(mongo.Pipeline{
+			MatchStageBySingleField("user", userId),
+			bson.D{{"$sort", bson.D{{"created_at", -1}, {"_id", -1}}}},
+			bson.D{{"$skip", (page - 1) * limit}},
+			bson.D{{"$limit", limit}},
+		}, messageLookupStages()...))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// AddBookmark saves a message from one of the user's chats
+func AddBookmark() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+		mId, _ := reqData["messageId"].(string)
+		messageId, err := primitive.ObjectIDFromHex(mId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var message models.Message
+		messageCollection := database.OpenCollection(database.Client, "message")
+		err = messageCollection.FindOne(ctx, bson.D{
+			{"_id", messageId},
+			{"deleted_at", bson.D{{"$exists", false}}},
+			{"hidden." + userId.Hex(), bson.D{{"$exists", false}}},
+		}).Decode(&message)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		member, err := isChatMember(ctx, message.Chat, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if !member {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+
+		// saving a saved message again leaves it as it was
+		bookmark := models.Bookmark{
+			User:       userId,
+			Message:    messageId,
+			Chat:       message.Chat,
+			Created_at: time.Now(),
+		}
+		bookmarkCollection := database.OpenCollection(database.Client, "bookmark")
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err = bookmarkCollection.FindOneAndUpdate(ctx,
+			bson.D{{"user", userId}, {"message", messageId}},
+			bson.D{{"$setOnInsert", bookmark}},
+			opts,
+		).Decode(&bookmark)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, bookmark)
+	}
+}
+
+// RemoveBookmark removes a message from the user's saved messages
+func RemoveBookmark() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		bookmarkCollection := database.OpenCollection(database.Client, "bookmark")
+		res, err := bookmarkCollection.DeleteOne(ctx, bson.D{{"user", c.MustGet("_id").(primitive.ObjectID)}, {"message", messageId}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bookmark not found"})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// requirePinner loads the chat and checks the requesting user can pin in it:
+// admins of a group, or either user of a direct chat. Otherwise it writes an
+// error response and returns false
+func requirePinner(ctx context.Context, c *gin.Context, chatId primitive.ObjectID) (models.Chat, bool) {
+	var chat models.Chat
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	err := chatCollection.FindOne(ctx, bson.D{{"_id", chatId}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&chat)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return chat, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return chat, false
+	}
+
+	role := chat.RoleOf(c.MustGet("_id").(primitive.ObjectID))
+	if role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return chat, false
+	}
+	if chat.IsGroupChat && models.RoleRank(role) < models.RoleRank(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only group admins can pin messages"})
+		return chat, false
+	}
+	return chat, true
+}
+
+// messageLookupStages joins the message at the message field along with its
+// sender's public profile, leaving out entries whose message was purged
+func messageLookupStages() mongo.Pipeline {
+	messagePipeline := bson.A{
+		bson.D{{"$match", bson.D{{"$expr", bson.D{{"$eq", bson.A{"$_id", "$$messageId"}}}}}}},
+	}
+	for _, stage := range tombstoneStage() {
+		messagePipeline = append(messagePipeline, stage)
+	}
+	messagePipeline = append(messagePipeline, publicProfileLookup("sender", "sender"))
+
+	return mongo.Pipeline{
+		bson.D{
+			{
+				"$lookup", bson.D{
+					{"from", "message"},
+					{"let", bson.D{{"messageId", "$message"}}},
+					{"pipeline", messagePipeline},
+					{"as", "message"},
+				},
+			},
+		},
+		bson.D{{"$unwind", "$message"}},
+	}
+}
@@ -0,0 +1,376 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/filter"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxPollOptionLength bounds the text of a poll option
+const maxPollOptionLength = 100
+
+// CreatePoll sends a poll message to the chat. The question is its content,
+// options lists the answers, multiple lets users pick more than one,
+// anonymous hides who voted for what and closesAt, an RFC3339 time, stops
+// the voting
+func CreatePoll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData struct {
+			ChatId    string   `json:"chatId"`
+			Question  string   `json:"question"`
+			Options   []string `json:"options"`
+			Multiple  bool     `json:"multiple"`
+			Anonymous bool     `json:"anonymous"`
+			ClosesAt  string   `json:"closesAt"`
+		}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		chatId, err := primitive.ObjectIDFromHex(reqData.ChatId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		if strings.TrimSpace(reqData.Question) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "question is required"})
+			return
+		}
+		if len(reqData.Options) < models.MinPollOptions || len(reqData.Options) > models.MaxPollOptions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A poll needs between %d and %d options", models.MinPollOptions, models.MaxPollOptions)})
+			return
+		}
+
+		poll := models.Poll{Multiple: reqData.Multiple, Anonymous: reqData.Anonymous}
+		for _, text := range reqData.Options {
+			text = strings.TrimSpace(text)
+			if text == "" || len(text) > maxPollOptionLength || strings.ContainsAny(text, "\r\n") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Options must be a single line of 1 to %d characters", maxPollOptionLength)})
+				return
+			}
+			poll.Options = append(poll.Options, models.PollOption{Text: text})
+		}
+		if reqData.ClosesAt != "" {
+			closesAt, err := time.Parse(time.RFC3339, reqData.ClosesAt)
+			if err != nil || !closesAt.After(time.Now()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "closesAt must be an RFC3339 time in the future"})
+				return
+			}
+			poll.Closes_at = closesAt
+		}
+		senderId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		chat, ok := canSendToChat(ctx, c, chatId, senderId)
+		if !ok {
+			return
+		}
+
+		// the options go through the filters along with the question
+		content := reqData.Question
+		for _, option := range poll.Options {
+			content += "\n" + option.Text
+		}
+		outcome, ok := runFilters(ctx, c, chat, filter.Input{Chat: chatId, Sender: senderId, Content: content})
+		if !ok {
+			return
+		}
+		lines := strings.Split(outcome.Content, "\n")
+		if len(lines) <= len(poll.Options) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while filtering message"})
+			return
+		}
+		for i := range poll.Options {
+			poll.Options[i].Text = lines[len(lines)-len(poll.Options)+i]
+		}
+
+		newMessage := models.Message{
+			Sender:     senderId,
+			Content:    strings.Join(lines[:len(lines)-len(poll.Options)], "\n"),
+			Chat:       chatId,
+			Type:       models.MessagePoll,
+			Poll:       &poll,
+			Created_at: time.Now(),
+			Updated_at: time.Now(),
+		}
+
+		result, err := deliverMessage(ctx, newMessage, outcome.Flags)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while retrieving data"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetPoll returns the poll of the message with the options the user picked.
+// For polls that aren't anonymous it also lists who voted for each option
+func GetPoll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		message, ok := findPoll(ctx, c, messageId, userId)
+		if !ok {
+			return
+		}
+
+		response := pollState(message)
+		myVote := message.Poll.Votes[userId.Hex()]
+		if myVote == nil {
+			myVote = []int{}
+		}
+		response["myVote"] = myVote
+
+		if !message.Poll.Anonymous {
+			voters := make([][]primitive.ObjectID, len(message.Poll.Options))
+			for i := range voters {
+				voters[i] = []primitive.ObjectID{}
+			}
+			users := make([]string, 0, len(message.Poll.Votes))
+			for user := range message.Poll.Votes {
+				users = append(users, user)
+			}
+			sort.Strings(users)
+			for _, user := range users {
+				id, err := primitive.ObjectIDFromHex(user)
+				if err != nil {
+					continue
+				}
+				for _, option := range message.Poll.Votes[user] {
+					voters[option] = append(voters[option], id)
+				}
+			}
+			response["voters"] = voters
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// VotePoll records the options the user picks in the poll, replacing their
+// previous vote. An empty options list takes the vote back
+func VotePoll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		var reqData struct {
+			Options []int `json:"options"`
+		}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		message, ok := findPoll(ctx, c, messageId, userId)
+		if !ok {
+			return
+		}
+		poll := message.Poll
+		if poll.IsClosed(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The poll is closed"})
+			return
+		}
+
+		choice := []int{}
+		picked := make(map[int]bool)
+		for _, option := range reqData.Options {
+			if option < 0 || option >= len(poll.Options) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid option"})
+				return
+			}
+			if !picked[option] {
+				picked[option] = true
+				choice = append(choice, option)
+			}
+		}
+		if len(choice) > 1 && !poll.Multiple {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only one option can be picked"})
+			return
+		}
+		sort.Ints(choice)
+
+		// the counts change by the difference between the previous vote and
+		// this one, applied only if the previous vote is still the stored one
+		key := "poll.votes." + userId.Hex()
+		previous, voted := poll.Votes[userId.Hex()]
+		delta := make(map[int]int)
+		for _, option := range previous {
+			delta[option]--
+		}
+		for _, option := range choice {
+			delta[option]++
+		}
+
+		filter := bson.D{{"_id", messageId}, {"poll.closed_at", bson.D{{"$exists", false}}}}
+		if voted {
+			filter = append(filter, bson.E{key, previous})
+		} else {
+			filter = append(filter, bson.E{key, bson.D{{"$exists", false}}})
+		}
+
+		inc := bson.D{}
+		for option, change := range delta {
+			if change != 0 {
+				inc = append(inc, bson.E{fmt.Sprintf("poll.options.%d.votes", option), change})
+				poll.Options[option].Votes += change
+			}
+		}
+		update := bson.D{}
+		if len(inc) > 0 {
+			update = append(update, bson.E{"$inc", inc})
+		}
+		if len(choice) > 0 {
+			update = append(update, bson.E{"$set", bson.D{{key, choice}}})
+		} else {
+			update = append(update, bson.E{"$unset", bson.D{{key, ""}}})
+		}
+
+		messageCollection := database.OpenCollection(database.Client, "message")
+		res, err := messageCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "The poll changed, try again"})
+			return
+		}
+
+		state := pollState(message)
+		publishPoll(message.Chat, state)
+
+		state["myVote"] = choice
+		c.JSON(http.StatusOK, state)
+	}
+}
+
+// ClosePoll stops the voting on a poll, only its creator can close it
+func ClosePoll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		message, ok := findPoll(ctx, c, messageId, userId)
+		if !ok {
+			return
+		}
+		if message.Sender != userId {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator of the poll can close it"})
+			return
+		}
+		if message.Poll.IsClosed(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The poll is closed"})
+			return
+		}
+
+		message.Poll.Closed_at = time.Now()
+		messageCollection := database.OpenCollection(database.Client, "message")
+		update := bson.D{{"$set", bson.D{{"poll.closed_at", message.Poll.Closed_at}}}}
+		if _, err := messageCollection.UpdateOne(ctx, bson.D{{"_id", messageId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		state := pollState(message)
+		publishPoll(message.Chat, state)
+
+		c.JSON(http.StatusOK, state)
+	}
+}
+
+// findPoll loads the poll message, checking the user is a member of its chat.
+// Otherwise it writes an error response and returns false
+func findPoll(ctx context.Context, c *gin.Context, messageId, userId primitive.ObjectID) (models.Message, bool) {
+	var message models.Message
+	messageCollection := database.OpenCollection(database.Client, "message")
+	err := messageCollection.FindOne(ctx, bson.D{
+		{"_id", messageId},
+		{"type", models.MessagePoll},
+		{"deleted_at", bson.D{{"$exists", false}}},
+	}).Decode(&message)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return message, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return message, false
+	}
+
+	member, err := isChatMember(ctx, message.Chat, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return message, false
+	}
+	if !member {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return message, false
+	}
+	return message, true
+}
+
+// pollState is what every member may see of the poll: its options with their
+// counts and whether it's closed
+func pollState(message models.Message) gin.H {
+	return gin.H{
+		"_id":    message.Id,
+		"chat":   message.Chat,
+		"poll":   message.Poll,
+		"closed": message.Poll.IsClosed(time.Now()),
+	}
+}
+
+// publishPoll sends the new tally of a poll to the clients of its chat
+func publishPoll(chatId primitive.ObjectID, state gin.H) {
+	websocket.Publish(chatId.Hex(), map[string]interface{}{
+		"messageType": "pollUpdated",
+		"messageId":   state["_id"],
+		"poll":        state["poll"],
+		"closed":      state["closed"],
+	})
+}
@@ -0,0 +1,340 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetBlockedUsers lists the public profiles of the users the user blocked
+func GetBlockedUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		user, ok := findUser(ctx, c, userId)
+		if !ok {
+			return
+		}
+
+		userCollection := database.OpenCollection(database.Client, "user")
+		opts := options.Find().
+			SetProjection(publicProfileProjection()).
+			SetSort(bson.D{{"name", 1}, {"_id", 1}})
+		cursor, err := userCollection.Find(ctx, bson.D{{"_id", bson.D{{"$in", user.BlockedUsers}}}}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// BlockUser blocks the user with the given userId. Blocked users can't start
+// or continue a direct chat with the user, don't find each other in search,
+// and their socket events aren't delivered to the user
+func BlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		uId, ok := reqData["userId"].(string)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "userId is required"})
+			return
+		}
+		blockedId, err := primitive.ObjectIDFromHex(uId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+		if blockedId == userId {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You can't block yourself"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := findUser(ctx, c, blockedId); !ok {
+			return
+		}
+
+		updateBlockedUsers(ctx, c, userId, bson.D{{"$addToSet", bson.D{{"blockedUsers", blockedId}}}})
+	}
+}
+
+// UnblockUser removes the user with the userId param from the blocked users
+func UnblockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		blockedId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+		updateBlockedUsers(ctx, c, userId, bson.D{{"$pull", bson.D{{"blockedUsers", blockedId}}}})
+	}
+}
+
+// GetPrivacySettings returns who can start a direct chat with the user
+func GetPrivacySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		user, ok := findUser(ctx, c, userId)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"dmPrivacy": dmPrivacyOf(user)})
+	}
+}
+
+// UpdatePrivacySettings sets who can start a direct chat with the user:
+// everyone, shared (users sharing a workspace or group chat) or nobody
+func UpdatePrivacySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		dmPrivacy, _ := reqData["dmPrivacy"].(string)
+		switch dmPrivacy {
+		case models.DMPrivacyEveryone, models.DMPrivacyShared, models.DMPrivacyNobody:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dmPrivacy must be everyone, shared or nobody"})
+			return
+		}
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		userCollection := database.OpenCollection(database.Client, "user")
+		update := bson.D{{"$set", bson.D{{"dmPrivacy", dmPrivacy}, {"updated_at", time.Now()}}}}
+		if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", userId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"dmPrivacy": dmPrivacy})
+	}
+}
+
+// updateBlockedUsers applies the update to the blocked users of the user,
+// keeps their socket connections in sync and responds with the blocked ids
+func updateBlockedUsers(ctx context.Context, c *gin.Context, userId primitive.ObjectID, update bson.D) {
+	userCollection := database.OpenCollection(database.Client, "user")
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.D{{"blockedUsers", 1}})
+
+	var user models.User
+	if err := userCollection.FindOneAndUpdate(ctx, bson.D{{"_id", userId}}, update, opts).Decode(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+		log.Println(err)
+		return
+	}
+
+	websocket.SetBlocked(userId, user.BlockedUsers)
+
+	if user.BlockedUsers == nil {
+		user.BlockedUsers = []primitive.ObjectID{}
+	}
+	c.JSON(http.StatusOK, gin.H{"blockedUsers": user.BlockedUsers})
+}
+
+// canStartDM checks whether the user may start a direct chat with another
+// user, given their blocks and the privacy setting of the other user. It
+// writes an error response and returns false when they can't
+func canStartDM(ctx context.Context, c *gin.Context, userId, otherId primitive.ObjectID) bool {
+	other, ok := findUser(ctx, c, otherId)
+	if !ok {
+		return false
+	}
+
+	blocked, err := isBlocked(ctx, userId, otherId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+		log.Println(err)
+		return false
+	}
+	if blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't message this user"})
+		return false
+	}
+
+	allowed := true
+	switch dmPrivacyOf(other) {
+	case models.DMPrivacyNobody:
+		allowed = false
+	case models.DMPrivacyShared:
+		if allowed, err = sharesSpace(ctx, userId, otherId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return false
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This user doesn't accept direct messages from you"})
+		return false
+	}
+	return true
+}
+
+// isBlocked reports whether either of the users blocked the other
+func isBlocked(ctx context.Context, userId, otherId primitive.ObjectID) (bool, error) {
+	userCollection := database.OpenCollection(database.Client, "user")
+	count, err := userCollection.CountDocuments(ctx, bson.D{{"$or", bson.A{
+		bson.D{{"_id", userId}, {"blockedUsers", otherId}},
+		bson.D{{"_id", otherId}, {"blockedUsers", userId}},
+	}}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// sharesSpace reports whether the users are members of a common workspace or
+// group chat
+func sharesSpace(ctx context.Context, userId, otherId primitive.ObjectID) (bool, error) {
+	both := bson.D{{"$all", bson.A{userId, otherId}}}
+
+	workspaceCollection := database.OpenCollection(database.Client, "workspace")
+	count, err := workspaceCollection.CountDocuments(ctx, bson.D{{"members", both}})
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	count, err = chatCollection.CountDocuments(ctx, bson.D{{"isGroupChat", true}, {"users", both}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func dmPrivacyOf(user models.User) string {
+	if user.DMPrivacy == "" {
+		return models.DMPrivacyEveryone
+	}
+	return user.DMPrivacy
+}
+
+// findUser loads the user, writing an error response and returning false
+// when they don't exist
+func findUser(ctx context.Context, c *gin.Context, userId primitive.ObjectID) (models.User, bool) {
+	var user models.User
+
+	userCollection := database.OpenCollection(database.Client, "user")
+	err := userCollection.FindOne(ctx, bson.D{{"_id", userId}}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+		log.Println(err)
+		return user, false
+	}
+	return user, true
+}
+
+// Why a message can't be sent to a chat
+var (
+	errChatNotFound = errors.New("chat not found")
+	errUserBlocked  = errors.New("user blocked")
+)
+
+// canSendToChat loads the chat and checks the sender is a member of it and,
+// for a direct chat, that neither user blocked the other. It writes an error response and returns false
+// when the message can't be sent
+func canSendToChat(ctx context.Context, c *gin.Context, chatId, senderId primitive.ObjectID) (models.Chat, bool) {
+	chat, err := sendableChat(ctx, chatId, senderId)
+	switch {
+	case errors.Is(err, errChatNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return chat, false
+	case errors.Is(err, errUserBlocked):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't message this user"})
+		return chat, false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return chat, false
+	}
+	return chat, true
+}
+
+// sendableChat is canSendToChat for callers without a request, it returns
+// errChatNotFound or errUserBlocked when the message can't be sent
+func sendableChat(ctx context.Context, chatId, senderId primitive.ObjectID) (models.Chat, error) {
+	var chat models.Chat
+
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	// chats the sender isn't a member of aren't revealed to them
+	filter := bson.D{{"_id", chatId}, {"users", senderId}, {"deleted_at", bson.D{{"$exists", false}}}}
+	err := chatCollection.FindOne(ctx, filter).Decode(&chat)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return chat, errChatNotFound
+	} else if err != nil {
+		return chat, err
+	}
+	if chat.IsGroupChat {
+		return chat, nil
+	}
+
+	for _, userId := range chat.Users {
+		if userId == senderId {
+			continue
+		}
+		blocked, err := isBlocked(ctx, senderId, userId)
+		if err != nil {
+			return chat, err
+		}
+		if blocked {
+			return chat, errUserBlocked
+		}
+	}
+	return chat, nil
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxReportDetailsLength = 1000
+
+// ReportContent flags a message, given by messageId, or a user, given by
+// userId, for the moderators. It also takes a reason (spam, harassment, hate
+// or other) and optional details. Users can only report messages of chats
+// they're in, and each message or user once while the report is open
+func ReportContent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		reason, _ := reqData["reason"].(string)
+		switch reason {
+		case models.ReasonSpam, models.ReasonHarassment, models.ReasonHate, models.ReasonOther:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be spam, harassment, hate or other"})
+			return
+		}
+		details, _ := reqData["details"].(string)
+		if len([]rune(details)) > maxReportDetailsLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("details can be at most %d characters", maxReportDetailsLength)})
+			return
+		}
+
+		reporterId := c.MustGet("_id").(primitive.ObjectID)
+		report := models.Report{
+			Reporter:   reporterId,
+			Reason:     reason,
+			Details:    details,
+			Status:     models.ReportOpen,
+			Created_at: time.Now(),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		mId, isMessage := reqData["messageId"].(string)
+		uId, isUser := reqData["userId"].(string)
+		switch {
+		case isMessage:
+			messageId, err := primitive.ObjectIDFromHex(mId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+				return
+			}
+			message, ok := findReportableMessage(ctx, c, messageId, reporterId)
+			if !ok {
+				return
+			}
+			report.Kind = models.ReportMessage
+			report.Message = message.Id
+			report.Chat = message.Chat
+			report.User = message.Sender
+			report.Content = message.Content
+		case isUser:
+			userId, err := primitive.ObjectIDFromHex(uId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+				return
+			}
+			if userId == reporterId {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "You can't report yourself"})
+				return
+			}
+			if _, ok := findUser(ctx, c, userId); !ok {
+				return
+			}
+			report.Kind = models.ReportUser
+			report.User = userId
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "messageId or userId is required"})
+			return
+		}
+
+		reportCollection := database.OpenCollection(database.Client, "report")
+
+		duplicate := bson.D{{"reporter", reporterId}, {"kind", report.Kind}, {"status", models.ReportOpen}}
+		if report.Kind == models.ReportMessage {
+			duplicate = append(duplicate, bson.E{"message", report.Message})
+		} else {
+			duplicate = append(duplicate, bson.E{"user", report.User})
+		}
+		count, err := reportCollection.CountDocuments(ctx, duplicate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You've already reported this"})
+			return
+		}
+
+		insId, err := reportCollection.InsertOne(ctx, report)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating report"})
+			log.Println(err)
+			return
+		}
+		report.Id = insId.InsertedID.(primitive.ObjectID)
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetUserWarnings lists the warnings moderators sent to the user, newest first
+func GetUserWarnings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		warningCollection := database.OpenCollection(database.Client, "warning")
+		opts := options.Find().SetSort(bson.D{{"created_at", -1}})
+		cursor, err := warningCollection.Find(ctx, bson.D{{"user", userId}}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		warnings := []models.Warning{}
+		if err := cursor.All(ctx, &warnings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, warnings)
+	}
+}
+
+// findReportableMessage loads a message the user can report: a message of
+// someone else in a chat the user is in. Otherwise it writes an error
+// response and returns false
+func findReportableMessage(ctx context.Context, c *gin.Context, messageId, userId primitive.ObjectID) (models.Message, bool) {
+	var message models.Message
+
+	messageCollection := database.OpenCollection(database.Client, "message")
+	err := messageCollection.FindOne(ctx, bson.D{{"_id", messageId}}).Decode(&message)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return message, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return message, false
+	}
+
+	member, err := isChatMember(ctx, message.Chat, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return message, false
+	}
+	if !member {
+		// don't reveal messages of other chats
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return message, false
+	}
+
+	if message.Type == models.MessageSystem || message.Sender == userId {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This message can't be reported"})
+		return message, false
+	}
+	return message, true
+}
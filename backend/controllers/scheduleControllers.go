@@ -0,0 +1,552 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/filter"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxScheduleAhead is how far in the future items can be scheduled
+	maxScheduleAhead = 365 * 24 * time.Hour
+
+	// scheduleLease is how long the scheduler holds an item it is sending.
+	// Items still held after it, because the backend stopped while sending
+	// them, are picked up again
+	scheduleLease = 2 * time.Minute
+
+	// maxScheduleAttempts is how many times sending an item is tried before
+	// it's marked failed
+	maxScheduleAttempts = 5
+)
+
+// errUndeliverable wraps the reasons a scheduled item can never be sent, like
+// its chat being deleted, so it isn't tried again
+var errUndeliverable = errors.New("undeliverable")
+
+// GetScheduled lists the user's scheduled messages and reminders, the next
+// due first. Only pending items are listed unless status is given, and kind
+// picks messages or reminders. Results are paginated with page and limit,
+// the total is sent in X-Total-Count
+func GetScheduled() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.D{{"user", c.MustGet("_id").(primitive.ObjectID)}}
+		switch status := c.DefaultQuery("status", models.SchedulePending); status {
+		case models.SchedulePending, models.ScheduleSent, models.ScheduleFailed:
+			filter = append(filter, bson.E{"status", status})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, sent or failed"})
+			return
+		}
+		switch kind := c.Query("kind"); kind {
+		case "":
+		case models.ScheduledMessage, models.ScheduledReminder:
+			filter = append(filter, bson.E{"kind", kind})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be message or reminder"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		scheduledCollection := database.OpenCollection(database.Client, "scheduled")
+
+		total, err := scheduledCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{"send_at", 1}, {"_id", 1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := scheduledCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []models.Scheduled{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// ScheduleMessage queues a message to be sent to the chat at sendAt, an
+// RFC3339 time
+func ScheduleMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		cId, _ := reqData["chatId"].(string)
+		chatId, err := primitive.ObjectIDFromHex(cId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+		content, _ := reqData["content"].(string)
+		if strings.TrimSpace(content) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
+			return
+		}
+		sendAt, ok := parseSendAt(c, reqData["sendAt"])
+		if !ok {
+			return
+		}
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		member, err := isChatMember(ctx, chatId, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if !member {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+			return
+		}
+		if _, ok := canSendToChat(ctx, c, chatId, userId); !ok {
+			return
+		}
+
+		now := time.Now()
+		scheduled := models.Scheduled{
+			Kind:       models.ScheduledMessage,
+			User:       userId,
+			Chat:       chatId,
+			Content:    content,
+			Send_at:    sendAt,
+			Status:     models.SchedulePending,
+			Created_at: now,
+			Updated_at: now,
+		}
+		insertScheduled(ctx, c, scheduled)
+	}
+}
+
+// AddReminder sets a personal reminder about the message at sendAt, an
+// RFC3339 time, with an optional note. Only the user gets the reminder
+func AddReminder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageId, err := primitive.ObjectIDFromHex(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+		sendAt, ok := parseSendAt(c, reqData["sendAt"])
+		if !ok {
+			return
+		}
+		note, _ := reqData["note"].(string)
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var message models.Message
+		messageCollection := database.OpenCollection(database.Client, "message")
+		err = messageCollection.FindOne(ctx, bson.D{{"_id", messageId}, {"deleted_at", bson.D{{"$exists", false}}}}).Decode(&message)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		member, err := isChatMember(ctx, message.Chat, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		if !member {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+
+		now := time.Now()
+		scheduled := models.Scheduled{
+			Kind:       models.ScheduledReminder,
+			User:       userId,
+			Chat:       message.Chat,
+			Message:    messageId,
+			Content:    note,
+			Send_at:    sendAt,
+			Status:     models.SchedulePending,
+			Created_at: now,
+			Updated_at: now,
+		}
+		insertScheduled(ctx, c, scheduled)
+	}
+}
+
+// EditScheduled changes the content and or sendAt of a pending scheduled
+// item of the user. Items already being sent can't be changed
+func EditScheduled() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduledId, err := primitive.ObjectIDFromHex(c.Param("scheduledId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scheduled id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		set := bson.D{{"updated_at", time.Now()}}
+		if content, ok := reqData["content"].(string); ok {
+			set = append(set, bson.E{"content", content})
+		}
+		if raw, ok := reqData["sendAt"]; ok {
+			sendAt, ok := parseSendAt(c, raw)
+			if !ok {
+				return
+			}
+			set = append(set, bson.E{"send_at", sendAt})
+		}
+		if len(set) == 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content or sendAt is required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		scheduled, ok := findEditableScheduled(ctx, c, scheduledId)
+		if !ok {
+			return
+		}
+		if scheduled.Kind == models.ScheduledMessage {
+			if content, ok := reqData["content"].(string); ok && strings.TrimSpace(content) == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
+				return
+			}
+		}
+
+		scheduledCollection := database.OpenCollection(database.Client, "scheduled")
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = scheduledCollection.FindOneAndUpdate(ctx, editableScheduled(scheduledId, scheduled.User), bson.D{{"$set", set}}, opts).Decode(&scheduled)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The scheduled item is already being sent"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, scheduled)
+	}
+}
+
+// CancelScheduled removes a pending scheduled item of the user
+func CancelScheduled() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduledId, err := primitive.ObjectIDFromHex(c.Param("scheduledId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scheduled id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		scheduled, ok := findEditableScheduled(ctx, c, scheduledId)
+		if !ok {
+			return
+		}
+
+		scheduledCollection := database.OpenCollection(database.Client, "scheduled")
+		res, err := scheduledCollection.DeleteOne(ctx, editableScheduled(scheduledId, scheduled.User))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "The scheduled item is already being sent"})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// RunScheduler sends, every interval, the scheduled messages and reminders
+// that are due. Items are kept in the database, so the ones that came due
+// while the backend was down are sent once it's back. It never returns, main
+// runs it in its own goroutine
+func RunScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		if err := sendDueScheduled(ctx, time.Now()); err != nil {
+			log.Println("error while sending scheduled items: ", err)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
+
+// sendDueScheduled sends every pending item due at now, one at a time. Each
+// item is held for scheduleLease while being sent so it's sent only once
+func sendDueScheduled(ctx context.Context, now time.Time) error {
+	scheduledCollection := database.OpenCollection(database.Client, "scheduled")
+
+	due := bson.D{
+		{"status", models.SchedulePending},
+		{"send_at", bson.D{{"$lte", now}}},
+		{"$or", bson.A{
+			bson.D{{"lockedUntil", bson.D{{"$exists", false}}}},
+			bson.D{{"lockedUntil", bson.D{{"$lt", now}}}},
+		}},
+	}
+	claim := bson.D{
+		{"$set", bson.D{{"lockedUntil", now.Add(scheduleLease)}}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{"send_at", 1}}).SetReturnDocument(options.After)
+
+	for {
+		var scheduled models.Scheduled
+		err := scheduledCollection.FindOneAndUpdate(ctx, due, claim, opts).Decode(&scheduled)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		sentId, err := sendScheduled(ctx, scheduled)
+
+		var update bson.D
+		retryLater := false
+		switch {
+		case err == nil:
+			update = bson.D{
+				{"$set", bson.D{{"status", models.ScheduleSent}, {"sent", sentId}, {"sent_at", time.Now()}}},
+				{"$unset", bson.D{{"lockedUntil", ""}, {"error", ""}}},
+			}
+		case errors.Is(err, errUndeliverable) || scheduled.Attempts >= maxScheduleAttempts:
+			update = bson.D{
+				{"$set", bson.D{{"status", models.ScheduleFailed}, {"error", err.Error()}}},
+				{"$unset", bson.D{{"lockedUntil", ""}}},
+			}
+		default:
+			// tried again on the next run
+			log.Println("error while sending scheduled item: ", err)
+			retryLater = true
+			update = bson.D{
+				{"$set", bson.D{{"error", err.Error()}}},
+				{"$unset", bson.D{{"lockedUntil", ""}}},
+			}
+		}
+		if _, err := scheduledCollection.UpdateOne(ctx, bson.D{{"_id", scheduled.Id}}, update); err != nil {
+			return err
+		}
+		if retryLater {
+			// leave the remaining items to the next run rather than
+			// spinning on a failing database
+			return nil
+		}
+	}
+}
+
+// sendScheduled sends a scheduled message to its chat the way SendMessage
+// does, or a reminder to its user. It returns the id of the sent message
+func sendScheduled(ctx context.Context, scheduled models.Scheduled) (primitive.ObjectID, error) {
+	member, err := isChatMember(ctx, scheduled.Chat, scheduled.User)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if !member {
+		return primitive.NilObjectID, fmt.Errorf("%w: user is no longer in the chat", errUndeliverable)
+	}
+
+	if scheduled.Kind == models.ScheduledReminder {
+		return scheduled.Message, sendReminder(ctx, scheduled)
+	}
+
+	chat, err := sendableChat(ctx, scheduled.Chat, scheduled.User)
+	if errors.Is(err, errChatNotFound) || errors.Is(err, errUserBlocked) {
+		return primitive.NilObjectID, fmt.Errorf("%w: %v", errUndeliverable, err)
+	} else if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	outcome, err := filterMessage(ctx, chat, filter.Input{Chat: chat.Id, Sender: scheduled.User, Content: scheduled.Content})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if outcome.Rejected {
+		return primitive.NilObjectID, fmt.Errorf("%w: message rejected, it %s", errUndeliverable, outcome.Reason)
+	}
+	mentions, err := resolveMentions(chat, scheduled.User, outcome.Content)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("%w: %v", errUndeliverable, err)
+	}
+
+	now := time.Now()
+	message := models.Message{
+		Sender:     scheduled.User,
+		Content:    outcome.Content,
+		Chat:       chat.Id,
+		Type:       models.MessageText,
+		Mentions:   mentions,
+		Created_at: now,
+		Updated_at: now,
+	}
+	result, err := deliverMessage(ctx, message, outcome.Flags)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result["_id"].(primitive.ObjectID), nil
+}
+
+// sendReminder pushes the reminder, with the message it's about, to the
+// connections of its user
+func sendReminder(ctx context.Context, scheduled models.Scheduled) error {
+	messageCollection := database.OpenCollection(database.Client, "message")
+	cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{
+		bson.D{{"$match", bson.D{{"_id", scheduled.Message}, {"deleted_at", bson.D{{"$exists", false}}}}}},
+		publicProfileLookup("sender", "sender"),
+		bson.D{{"$project", bson.D{{"hidden", 0}, {"poll.votes", 0}, {"mentions.recipients", 0}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var results []bson.M
+	if err := cursor.All(ctx, &results); err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("%w: message was deleted", errUndeliverable)
+	}
+
+	websocket.PublishToUser(scheduled.User.Hex(), map[string]interface{}{
+		"messageType": "reminder",
+		"reminder":    scheduled,
+		"message":     results[0],
+	})
+	return nil
+}
+
+// insertScheduled stores the new scheduled item and responds with it
+func insertScheduled(ctx context.Context, c *gin.Context, scheduled models.Scheduled) {
+	scheduledCollection := database.OpenCollection(database.Client, "scheduled")
+	insId, err := scheduledCollection.InsertOne(ctx, scheduled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while inserting document"})
+		log.Println(err)
+		return
+	}
+	scheduled.Id = insId.InsertedID.(primitive.ObjectID)
+
+	c.JSON(http.StatusOK, scheduled)
+}
+
+// findEditableScheduled loads a scheduled item of the user, checking it's
+// still pending. Otherwise it writes an error response and returns false
+func findEditableScheduled(ctx context.Context, c *gin.Context, scheduledId primitive.ObjectID) (models.Scheduled, bool) {
+	var scheduled models.Scheduled
+	scheduledCollection := database.OpenCollection(database.Client, "scheduled")
+	err := scheduledCollection.FindOne(ctx, bson.D{{"_id", scheduledId}, {"user", c.MustGet("_id").(primitive.ObjectID)}}).Decode(&scheduled)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled item not found"})
+		return scheduled, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return scheduled, false
+	}
+	if scheduled.Status != models.SchedulePending {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The scheduled item was already " + scheduled.Status})
+		return scheduled, false
+	}
+	return scheduled, true
+}
+
+// editableScheduled matches the pending item of the user when the scheduler
+// isn't sending it
+func editableScheduled(scheduledId, userId primitive.ObjectID) bson.D {
+	return bson.D{
+		{"_id", scheduledId},
+		{"user", userId},
+		{"status", models.SchedulePending},
+		{"$or", bson.A{
+			bson.D{{"lockedUntil", bson.D{{"$exists", false}}}},
+			bson.D{{"lockedUntil", bson.D{{"$lt", time.Now()}}}},
+		}},
+	}
+}
+
+// parseSendAt reads the RFC3339 time an item is scheduled for, which must be
+// in the future and within maxScheduleAhead. It writes an error response and
+// returns false when it isn't
+func parseSendAt(c *gin.Context, value interface{}) (time.Time, bool) {
+	raw, _ := value.(string)
+	sendAt, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sendAt must be an RFC3339 time"})
+		return time.Time{}, false
+	}
+	if !sendAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sendAt must be in the future"})
+		return time.Time{}, false
+	}
+	if sendAt.After(time.Now().Add(maxScheduleAhead)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sendAt can be at most a year ahead"})
+		return time.Time{}, false
+	}
+	return sendAt, true
+}
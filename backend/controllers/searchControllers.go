@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 50
+)
+
+// SearchMessages finds messages in the chats of the active workspace the user
+// belongs to. Besides the
+// q search text it accepts chatId, from (sender id), after and before
+// (RFC3339 or 2006-01-02 dates), page and limit
+func SearchMessages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, exists := c.Get("_id")
+		if !exists {
+			log.Panic("User details not available")
+		}
+		userId := id.(primitive.ObjectID)
+
+		query := search.Parse(c.Query("q"))
+		if query.Empty() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "search text is required"})
+			return
+		}
+
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+		query.Skip = (page - 1) * limit
+		query.Limit = limit
+
+		var err error
+		if from := c.Query("from"); from != "" {
+			if query.Sender, err = primitive.ObjectIDFromHex(from); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sender id"})
+				return
+			}
+		}
+		if query.After, ok = parseDateQuery(c, "after"); !ok {
+			return
+		}
+		if query.Before, ok = parseDateQuery(c, "before"); !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// only ever search the chats the user is a member of
+		if cId := c.Query("chatId"); cId != "" {
+			chatId, err := primitive.ObjectIDFromHex(cId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+				return
+			}
+			member, err := isChatMember(ctx, chatId, userId)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+				log.Println(err)
+				return
+			}
+			if !member {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You're not a member of this chat"})
+				return
+			}
+			query.Chats = []primitive.ObjectID{chatId}
+		} else {
+			query.Chats, err = getUserChatIds(ctx, userId, activeWorkspace(c))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+				log.Println(err)
+				return
+			}
+		}
+
+		result, err := search.Messages.Search(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while searching messages"})
+			log.Println(err)
+			return
+		}
+
+		ids := make([]primitive.ObjectID, len(result.Hits))
+		for i, hit := range result.Hits {
+			ids[i] = hit.MessageId
+		}
+
+		// messages the user deleted for themselves are left out
+		matchStage := bson.D{
+			{
+				"$match", bson.D{
+					{"_id", bson.D{{"$in", ids}}},
+					{"hidden." + userId.Hex(), bson.D{{"$exists", false}}},
+				},
+			},
+		}
+
+		lookupStage := LookUpStage("user", "sender", "_id", "sender")
+
+		projectStage := bson.D{
+			{
+				"$project", bson.D{
+					{"sender.password", 0},
+					{"sender.created_at", 0},
+					{"sender.updated_at", 0},
+					{"sender.isAdmin", 0},
+					{"hidden", 0},
+					{"poll.votes", 0},
+					{"mentions.recipients", 0},
+				},
+			},
+		}
+
+		messageCollection := database.OpenCollection(database.Client, "message")
+		cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage, PublicProfileStage("sender")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while retrieving data"})
+			log.Println(err)
+			return
+		}
+
+		var messages []bson.M
+		if err := cursor.All(ctx, &messages); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while retrieving data"})
+			log.Println(err)
+			return
+		}
+
+		byId := make(map[primitive.ObjectID]bson.M, len(messages))
+		for _, msg := range messages {
+			byId[msg["_id"].(primitive.ObjectID)] = msg
+		}
+
+		// keep the ranking order of the index
+		results := []bson.M{}
+		for _, hit := range result.Hits {
+			msg, exists := byId[hit.MessageId]
+			if !exists {
+				continue
+			}
+			content, _ := msg["content"].(string)
+			msg["snippet"], msg["highlights"] = search.Snippet(content, query)
+			msg["score"] = hit.Score
+			results = append(results, msg)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"results": results,
+			"total":   result.Total,
+			"page":    page,
+			"limit":   limit,
+		})
+	}
+}
+
+// pagination reads the page and limit query values, writing an error
+// response and returning false when they are invalid
+func pagination(c *gin.Context) (int, int, bool) {
+	page, limit := 1, defaultPageSize
+	var err error
+	if p := c.Query("page"); p != "" {
+		if page, err = strconv.Atoi(p); err != nil || page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
+			return 0, 0, false
+		}
+	}
+	if l := c.Query("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return 0, 0, false
+		}
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return page, limit, true
+}
+
+// parseDateQuery reads an optional RFC3339 or 2006-01-02 date from the query,
+// writing an error response and returning false when it's invalid
+func parseDateQuery(c *gin.Context, key string) (time.Time, bool) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": key + " must be a RFC3339 or YYYY-MM-DD date"})
+	return time.Time{}, false
+}
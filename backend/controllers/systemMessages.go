@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// postSystemMessage records the event as a system message of the chat and
+// pushes it to the chat's websocket clients. Failures are only logged, as the
+// change the event describes has already been made
+func postSystemMessage(ctx context.Context, chatId primitive.ObjectID, event models.SystemEvent) {
+	names, err := userNames(ctx, event.Actor, event.Target)
+	if err != nil {
+		log.Println("error while recording system message: ", err)
+		return
+	}
+
+	now := time.Now()
+	message := models.Message{
+		Sender:     event.Actor,
+		Content:    renderEvent(event, names),
+		Chat:       chatId,
+		Type:       models.MessageSystem,
+		Event:      &event,
+		Created_at: now,
+		Updated_at: now,
+	}
+
+	messageCollection := database.OpenCollection(database.Client, "message")
+	insId, err := messageCollection.InsertOne(ctx, message)
+	if err != nil {
+		log.Println("error while recording system message: ", err)
+		return
+	}
+	message.Id = insId.InsertedID.(primitive.ObjectID)
+
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	update := bson.D{{"$set", bson.D{{"latestMessage", message.Id}}}}
+	if _, err := chatCollection.UpdateOne(ctx, bson.D{{"_id", chatId}}, update); err != nil {
+		log.Println("error while recording system message: ", err)
+	}
+
+	websocket.Publish(chatId.Hex(), map[string]interface{}{
+		"messageType": "systemMessage",
+		"message":     message,
+	})
+	emitWebhookEvent(event.Kind, chatId, message)
+}
+
+// renderEvent describes the event in plain text, so clients that don't know
+// an event kind can still show it
+func renderEvent(event models.SystemEvent, names map[primitive.ObjectID]string) string {
+	actor, target := names[event.Actor], names[event.Target]
+
+	switch event.Kind {
+	case models.EventGroupCreated:
+		return fmt.Sprintf("%s created the group %q", actor, event.Value)
+	case models.EventGroupRenamed:
+		return fmt.Sprintf("%s renamed the group to %q", actor, event.Value)
+	case models.EventGroupUpdated:
+		return fmt.Sprintf("%s changed the group %s", actor, event.Value)
+	case models.EventMemberAdded:
+		return fmt.Sprintf("%s added %s", actor, target)
+	case models.EventMemberJoined:
+		return fmt.Sprintf("%s joined the group", actor)
+	case models.EventMemberRemoved:
+		return fmt.Sprintf("%s removed %s", actor, target)
+	case models.EventMemberLeft:
+		return fmt.Sprintf("%s left the group", actor)
+	case models.EventRoleChanged:
+		return fmt.Sprintf("%s made %s %s", actor, target, withArticle(event.Value))
+	case models.EventOwnerChanged:
+		return fmt.Sprintf("%s is now the owner of the group", target)
+	}
+	return event.Kind
+}
+
+func withArticle(role string) string {
+	if role == models.RoleAdmin || role == models.RoleOwner {
+		return "an " + role
+	}
+	return "a " + role
+}
+
+// userNames returns the names of the given users, zero ids are skipped
+func userNames(ctx context.Context, ids ...primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	var lookup []primitive.ObjectID
+	for _, id := range ids {
+		if !id.IsZero() {
+			lookup = append(lookup, id)
+		}
+	}
+
+	userCollection := database.OpenCollection(database.Client, "user")
+	cursor, err := userCollection.Find(ctx, bson.D{{"_id", bson.D{{"$in", lookup}}}},
+		options.Find().SetProjection(bson.D{{"name", 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	names := make(map[primitive.ObjectID]string, len(users))
+	for _, user := range users {
+		names[user.Id] = user.Name
+	}
+	return names, nil
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +17,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // RegisterUser will register the new users to application
@@ -26,10 +30,6 @@ func RegisterUser() gin.HandlerFunc {
 			return
 		}
 
-		if user.Pic == "" {
-			user.SetDefaultPic()
-		}
-
 		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
@@ -57,16 +57,25 @@ func RegisterUser() gin.HandlerFunc {
 		hashedPassowrd := helpers.HashPassowrd(user.Password)
 		user.Password = hashedPassowrd
 
-		insId, err := userCollection.InsertOne(ctx, user)
+		// admin rights are never granted through registration
+		user.IsAdmin = false
+
+		// the id is generated upfront as the default pic is derived from it
+		user.Id = primitive.NewObjectID()
+		if user.Pic == "" {
+			user.SetDefaultPic()
+		}
+		user.SetSearchKeys()
+
+		_, err = userCollection.InsertOne(ctx, user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while registering the user"})
 			log.Panic(err)
 		}
 
-		user.Id = insId.InsertedID.(primitive.ObjectID)
 		id := user.Id.Hex()
-		// generate token for the user
-		if user.Token, err = helpers.GenerateToken(id, user.Name, user.Email); err != nil {
+		// generate token for the user, new users don't belong to a workspace yet
+		if user.Token, err = helpers.GenerateToken(id, user.Name, user.Email, ""); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to generate token"})
 			log.Panic(err)
 		}
@@ -95,6 +104,7 @@ func AuthUser() gin.HandlerFunc {
 		// check if user is a registered user
 		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&registeredUser)
 		if err != nil && errors.Is(err, mongo.ErrNoDocuments) {
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditLoginFailed, Details: "unknown email " + user.Email})
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not registered"})
 			return
 		} else if err != nil {
@@ -102,34 +112,150 @@ func AuthUser() gin.HandlerFunc {
 			log.Panic(err)
 		}
 
+		id, ok := registeredUser["_id"].(primitive.ObjectID)
+		if !ok {
+			log.Panic("Type assertion failed")
+		}
+
 		// user exist, check for password validation
 		resgisteredPassword := registeredUser["password"].(string)
 		errMsg, valid := helpers.VerifyPassword(resgisteredPassword, user.Password)
 		if !valid {
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditLoginFailed, Target: id, Details: "wrong password"})
 			c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg})
 			return
 		}
 
-		id, ok := registeredUser["_id"].(primitive.ObjectID)
-		if !ok {
-			log.Panic("Type assertion failed")
+		if suspended, _ := registeredUser["suspended"].(bool); suspended {
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditLoginFailed, Target: id, Details: "account suspended"})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your account is suspended"})
+			return
+		}
+		if _, pending := registeredUser["resetTokenHash"]; pending {
+			recordAudit(ctx, c, models.AuditEntry{Action: models.AuditLoginFailed, Target: id, Details: "password reset pending"})
+			c.JSON(http.StatusForbidden, gin.H{"error": "You need to reset your password"})
+			return
 		}
 
+		// the user starts in the oldest workspace they belong to
+		workspace, err := defaultWorkspace(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Panic(err)
+		}
+		registeredUser["workspace"] = workspace
+
 		// generate token for the user
-		if registeredUser["token"], err = helpers.GenerateToken(id.Hex(), user.Name, user.Email); err != nil {
+		if registeredUser["token"], err = helpers.GenerateToken(id.Hex(), user.Name, user.Email, workspace); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to generate token"})
 			log.Panic(err)
 		}
 
 		delete(registeredUser, "password")
+		delete(registeredUser, "searchKeys")
+		delete(registeredUser, "blockedUsers")
+		delete(registeredUser, "dmPrivacy")
+		delete(registeredUser, "tokensValidAfter")
+		delete(registeredUser, "lastSeenAt")
+		delete(registeredUser, "digest")
+
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditLogin, Actor: id, Target: id})
 		c.JSON(http.StatusOK, registeredUser)
 	}
 }
 
+// ResetPassword sets a new password for the user with the email, given the
+// reset token an admin created for them. Every token issued before is revoked
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "error while decoding user data"})
+			return
+		}
+
+		email, _ := reqData["email"].(string)
+		token, _ := reqData["token"].(string)
+		password, _ := reqData["password"].(string)
+		if email == "" || token == "" || password == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email, token and password are required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		userCollection := database.OpenCollection(database.Client, "user")
+
+		// a wrong email, token or an expired token all look the same
+		filter := bson.D{
+			{"email", email},
+			{"resetTokenHash", helpers.HashToken(token)},
+			{"resetExpiresAt", bson.D{{"$gt", time.Now()}}},
+		}
+		update := bson.D{
+			{"$set", bson.D{
+				{"password", helpers.HashPassowrd(password)},
+				{"tokensValidAfter", time.Now().Truncate(time.Second)},
+				{"updated_at", time.Now()},
+			}},
+			{"$unset", bson.D{{"resetTokenHash", ""}, {"resetExpiresAt", ""}}},
+		}
+		var reset models.User
+		opts := options.FindOneAndUpdate().SetProjection(bson.D{{"_id", 1}})
+		err := userCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&reset)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reset token is invalid or expired"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while updating user data"})
+			log.Println(err)
+			return
+		}
+
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditPasswordReset, Actor: reset.Id, Target: reset.Id})
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset, log in with the new password"})
+	}
+}
+
+// maxSearchLength bounds the search text, longer prefixes can't narrow results further
+const maxSearchLength = 100
+
+// searchText cuts the search text to maxSearchLength characters, dropping
+// invalid UTF-8 which isn't a valid regex
+func searchText(query string) string {
+	runes := []rune(strings.ToValidUTF8(query, ""))
+	if len(runes) > maxSearchLength {
+		runes = runes[:maxSearchLength]
+	}
+	return string(runes)
+}
+
+// SearchUsers finds users whose name, a word of their name or email starts with
+// the search text, ignoring case. The searching user and users blocked by or
+// blocking them are left out, as is everyone outside of the active workspace,
+// and only public profile fields are returned. Results are paginated with page and limit, the total is sent in X-Total-Count
 func SearchUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		query := c.Query("search")
-		log.Println(query)
+		query := searchText(strings.ToLower(strings.TrimSpace(c.Query("search"))))
+
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+
+		if query == "" {
+			c.Header("X-Total-Count", "0")
+			c.JSON(http.StatusOK, []bson.M{})
+			return
+		}
+
+		id, exists := c.Get("_id")
+		if !exists {
+			log.Panic("User details not available")
+		}
+		userId := id.(primitive.ObjectID)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -137,37 +263,79 @@ func SearchUsers() gin.HandlerFunc {
 		// get the user collection
 		userCollection := database.OpenCollection(database.Client, "user")
 
-		matchStage := bson.D{
-			{"$match", bson.D{
-				{"$or",
-					bson.A{
-						bson.D{{"name", bson.D{{"$regex", query}}}},
-						bson.D{{"email", bson.D{{"$regex", query}}}},
-					},
-				},
-			}},
+		var searchingUser models.User
+		if err := userCollection.FindOne(ctx, bson.D{{"_id", userId}}).Decode(&searchingUser); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
 		}
-		projectStage := bson.D{
-			{
-				"$project", bson.D{
-					{"password", 0},
-					{"created_at", 0},
-					{"updated_at", 0},
-				},
-			},
+
+		// an anchored regex on the lowercased keys can use their index
+		excluded := append([]primitive.ObjectID{userId}, searchingUser.BlockedUsers...)
+		ids := bson.D{{"$nin", excluded}}
+
+		// inside a workspace only its members can be found, and outside of
+		// workspaces only users who don't belong to one
+		if workspaceId := activeWorkspace(c); !workspaceId.IsZero() {
+			workspace, ok := findWorkspace(ctx, c, workspaceId)
+			if !ok {
+				return
+			}
+			ids = append(ids, bson.E{"$in", workspace.Members})
+		} else {
+			members, err := workspaceUsers(ctx)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error in the server"})
+				log.Println(err)
+				return
+			}
+			ids = bson.D{{"$nin", append(excluded, members...)}}
 		}
-		cursor, err := userCollection.Aggregate(ctx, mongo.Pipeline{matchStage, projectStage})
-		if err != nil && errors.Is(err, mongo.ErrNoDocuments) {
-			c.Status(http.StatusOK)
+
+		filter := bson.D{
+			{"searchKeys", bson.D{{"$regex", "^" + regexp.QuoteMeta(query)}}},
+			{"_id", ids},
+			{"blockedUsers", bson.D{{"$ne", userId}}},
+		}
+
+		total, err := userCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error in the server"})
+			log.Println(err)
 			return
 		}
 
-		var results []bson.M
+		opts := options.Find().
+			SetProjection(publicProfileProjection()).
+			SetSort(bson.D{{"name", 1}, {"_id", 1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+
+		cursor, err := userCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error in the server"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
 		if err := cursor.All(ctx, &results); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error in the server"})
-			log.Panic(err)
+			log.Println(err)
+			return
 		}
-		log.Println(results)
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
 		c.JSON(http.StatusOK, results)
 	}
 }
+
+// publicProfileProjection includes only the fields of a user other users may see
+func publicProfileProjection() bson.D {
+	return bson.D{
+		{"_id", 1},
+		{"name", 1},
+		{"email", 1},
+		{"pic", 1},
+	}
+}
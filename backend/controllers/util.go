@@ -40,3 +40,26 @@ func ProjectStage(field1, field2, field3, field4, field5 string) bson.D {
 		},
 	}
 }
+
+// PublicProfileStage keeps only the public profile fields of the users
+// looked up at field, so fields added to users later stay private unless
+// they're added to publicProfileProjection
+func PublicProfileStage(field string) bson.D {
+	profile := bson.D{}
+	for _, e := range publicProfileProjection() {
+		profile = append(profile, bson.E{e.Key, "$$user." + e.Key})
+	}
+	return bson.D{
+		{
+			"$addFields", bson.D{
+				{field, bson.D{
+					{"$map", bson.D{
+						{"input", "$" + field},
+						{"as", "user"},
+						{"in", profile},
+					}},
+				}},
+			},
+		},
+	}
+}
@@ -0,0 +1,646 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/helpers"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// webhookLease is how long a delivery is held while being sent, it's
+	// picked up again after it if the backend stopped meanwhile
+	webhookLease = 2 * time.Minute
+
+	// maxWebhookURLLength bounds the length of webhook urls
+	maxWebhookURLLength = 2048
+)
+
+// webhookWithSecret is how a webhook is sent when it's created, the only
+// time its secret is shown
+type webhookWithSecret struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+// CreateChatWebhook adds a webhook to the group from the url and events
+// fields, posting those events of the group to the url. Only admins manage
+// webhooks
+func CreateChatWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+		createWebhook(ctx, c, models.Webhook{Chat: chatId}, bson.D{{"chat", chatId}})
+	}
+}
+
+// GetChatWebhooks lists the webhooks of the group
+func GetChatWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chatId, err := primitive.ObjectIDFromHex(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireGroupRole(ctx, c, chatId, models.RoleAdmin); !ok {
+			return
+		}
+		sendWebhooks(ctx, c, bson.D{{"chat", chatId}})
+	}
+}
+
+// CreateWorkspaceWebhook adds a webhook to the workspace, posting the events
+// it subscribes to of the channels of the workspace and of the chats the
+// admin creating it is a member of. Only workspace admins manage them
+func CreateWorkspaceWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceId, err := primitive.ObjectIDFromHex(c.Param("workspaceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireWorkspaceRole(ctx, c, workspaceId, models.RoleAdmin); !ok {
+			return
+		}
+		createWebhook(ctx, c, models.Webhook{Workspace: workspaceId}, bson.D{{"workspace", workspaceId}})
+	}
+}
+
+// GetWorkspaceWebhooks lists the webhooks of the workspace
+func GetWorkspaceWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceId, err := primitive.ObjectIDFromHex(c.Param("workspaceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := requireWorkspaceRole(ctx, c, workspaceId, models.RoleAdmin); !ok {
+			return
+		}
+		sendWebhooks(ctx, c, bson.D{{"workspace", workspaceId}})
+	}
+}
+
+// UpdateWebhook changes the url, events or active fields of the webhook that
+// are set. Inactive webhooks aren't sent events
+func UpdateWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhookId, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		hook, ok := findManagedWebhook(ctx, c, webhookId)
+		if !ok || !bindWebhook(c, reqData, &hook) {
+			return
+		}
+		hook.Updated_at = time.Now()
+
+		update := bson.D{{"$set", bson.D{
+			{"url", hook.URL},
+			{"events", hook.Events},
+			{"active", hook.Active},
+			{"updated_at", hook.Updated_at},
+		}}}
+		webhookCollection := database.OpenCollection(database.Client, "webhook")
+		if _, err := webhookCollection.UpdateOne(ctx, bson.D{{"_id", webhookId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, hook)
+	}
+}
+
+// DeleteWebhook removes the webhook along with its delivery log
+func DeleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhookId, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := findManagedWebhook(ctx, c, webhookId); !ok {
+			return
+		}
+
+		webhookCollection := database.OpenCollection(database.Client, "webhook")
+		if _, err := webhookCollection.DeleteOne(ctx, bson.D{{"_id", webhookId}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting webhook"})
+			log.Println(err)
+			return
+		}
+		deliveryCollection := database.OpenCollection(database.Client, "webhookDelivery")
+		if _, err := deliveryCollection.DeleteMany(ctx, bson.D{{"webhook", webhookId}}); err != nil {
+			log.Println("error while deleting webhook deliveries: ", err)
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// PingWebhook sends a ping event to the webhook right away, even when it's
+// inactive, and responds with how the delivery went. Pings are recorded in
+// the delivery log but not retried
+func PingWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhookId, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		hook, ok := findManagedWebhook(ctx, c, webhookId)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		delivery, err := newDelivery(hook.Id, models.EventPing, hook.Workspace, hook.Chat, gin.H{"webhook": hook.Id}, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating delivery"})
+			log.Println(err)
+			return
+		}
+
+		status, err := webhook.Default.Deliver(ctx, hook.URL, hook.Secret, delivery.Event, delivery.Id.Hex(), []byte(delivery.Payload))
+		delivery.Attempts = 1
+		delivery.ResponseStatus = status
+		if err != nil {
+			delivery.Status = models.DeliveryFailed
+			delivery.Error = err.Error()
+		} else {
+			delivery.Status = models.DeliveryDelivered
+			delivery.Delivered_at = time.Now()
+		}
+		delivery.Next_at = time.Time{}
+
+		deliveryCollection := database.OpenCollection(database.Client, "webhookDelivery")
+		if _, err := deliveryCollection.InsertOne(ctx, delivery); err != nil {
+			log.Println("error while recording webhook delivery: ", err)
+		}
+
+		c.JSON(http.StatusOK, delivery)
+	}
+}
+
+// GetWebhookDeliveries lists the deliveries of the webhook, newest first,
+// optionally only those with the status query value. Results are paginated
+// with page and limit, the total is sent in X-Total-Count
+func GetWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhookId, err := primitive.ObjectIDFromHex(c.Param("webhookId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+			return
+		}
+		page, limit, ok := pagination(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.D{{"webhook", webhookId}}
+		switch status := c.Query("status"); status {
+		case "":
+		case models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+			filter = append(filter, bson.E{"status", status})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if _, ok := findManagedWebhook(ctx, c, webhookId); !ok {
+			return
+		}
+
+		deliveryCollection := database.OpenCollection(database.Client, "webhookDelivery")
+		total, err := deliveryCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{"created_at", -1}, {"_id", -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := deliveryCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		deliveries := []models.WebhookDelivery{}
+		if err := cursor.All(ctx, &deliveries); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// createWebhook creates the webhook, of the chat or workspace that scope
+// selects, from the request and responds with it and its secret
+func createWebhook(ctx context.Context, c *gin.Context, hook models.Webhook, scope bson.D) {
+	var reqData map[string]interface{}
+	if err := c.BindJSON(&reqData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+		return
+	}
+
+	hook.Active = true
+	if !bindWebhook(c, reqData, &hook) {
+		return
+	}
+	if hook.URL == "" || len(hook.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url and events are required"})
+		return
+	}
+
+	webhookCollection := database.OpenCollection(database.Client, "webhook")
+	count, err := webhookCollection.CountDocuments(ctx, scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return
+	}
+	if count >= models.MaxWebhooks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("There can be at most %d webhooks", models.MaxWebhooks)})
+		return
+	}
+
+	hook.Secret, err = helpers.RandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating webhook"})
+		log.Println(err)
+		return
+	}
+	hook.CreatedBy = c.MustGet("_id").(primitive.ObjectID)
+	hook.Created_at = time.Now()
+	hook.Updated_at = hook.Created_at
+
+	insId, err := webhookCollection.InsertOne(ctx, hook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating webhook"})
+		log.Println(err)
+		return
+	}
+	hook.Id = insId.InsertedID.(primitive.ObjectID)
+
+	c.JSON(http.StatusOK, webhookWithSecret{Webhook: hook, Secret: hook.Secret})
+}
+
+// bindWebhook sets the url, events and active fields of the request that are
+// present on the webhook. It writes an error response and returns false
+// when one is invalid
+func bindWebhook(c *gin.Context, reqData map[string]interface{}, hook *models.Webhook) bool {
+	if raw, exists := reqData["url"]; exists {
+		url, _ := raw.(string)
+		if len(url) > maxWebhookURLLength || webhook.CheckURL(url) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an http or https url"})
+			return false
+		}
+		hook.URL = url
+	}
+
+	if raw, exists := reqData["events"]; exists {
+		items, _ := raw.([]interface{})
+		events := []string{}
+		seen := map[string]bool{}
+		for _, item := range items {
+			event, _ := item.(string)
+			if !isWebhookEvent(event) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown event %q", event)})
+				return false
+			}
+			if !seen[event] {
+				seen[event] = true
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "events must list at least one event"})
+			return false
+		}
+		hook.Events = events
+	}
+
+	if raw, exists := reqData["active"]; exists {
+		active, ok := raw.(bool)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "active must be true or false"})
+			return false
+		}
+		hook.Active = active
+	}
+	return true
+}
+
+// isWebhookEvent reports whether webhooks can subscribe to the event
+func isWebhookEvent(event string) bool {
+	for _, e := range models.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// sendWebhooks responds with the webhooks filter selects, oldest first
+func sendWebhooks(ctx context.Context, c *gin.Context, filter bson.D) {
+	webhookCollection := database.OpenCollection(database.Client, "webhook")
+	cursor, err := webhookCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{"created_at", 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return
+	}
+
+	hooks := []models.Webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
+
+// findManagedWebhook loads the webhook and checks the requesting user is an
+// admin of its chat or workspace. Otherwise it writes an error response and
+// returns false
+func findManagedWebhook(ctx context.Context, c *gin.Context, webhookId primitive.ObjectID) (models.Webhook, bool) {
+	var hook models.Webhook
+
+	webhookCollection := database.OpenCollection(database.Client, "webhook")
+	err := webhookCollection.FindOne(ctx, bson.D{{"_id", webhookId}}).Decode(&hook)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return hook, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return hook, false
+	}
+
+	if !hook.Chat.IsZero() {
+		_, ok := requireGroupRole(ctx, c, hook.Chat, models.RoleAdmin)
+		return hook, ok
+	}
+	_, ok := requireWorkspaceRole(ctx, c, hook.Workspace, models.RoleAdmin)
+	return hook, ok
+}
+
+// newDelivery returns a pending delivery of the event to the webhook, due at
+// now, with the payload it's sent
+func newDelivery(webhookId primitive.ObjectID, event string, workspaceId, chatId primitive.ObjectID, data interface{}, now time.Time) (models.WebhookDelivery, error) {
+	delivery := models.WebhookDelivery{
+		Id:         primitive.NewObjectID(),
+		Webhook:    webhookId,
+		Event:      event,
+		Status:     models.DeliveryPending,
+		Next_at:    now,
+		Created_at: now,
+	}
+
+	payload := webhook.Payload{
+		Id:         delivery.Id.Hex(),
+		Event:      event,
+		Created_at: now,
+		Data:       data,
+	}
+	if !workspaceId.IsZero() {
+		payload.Workspace = workspaceId.Hex()
+	}
+	if !chatId.IsZero() {
+		payload.Chat = chatId.Hex()
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return delivery, err
+	}
+	delivery.Payload = string(body)
+	return delivery, nil
+}
+
+// emitWebhookEvent queues the event of the chat, with data as its payload,
+// for the active webhooks of the chat and of its workspace that subscribe to
+// it. Webhooks of the workspace only get the events of its channels and of
+// the chats their creator is a member of, so direct and private chats aren't
+// posted to anyone outside them. It runs in the background, RunWebhooks
+// sends the deliveries
+func emitWebhookEvent(event string, chatId primitive.ObjectID, data interface{}) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var chat models.Chat
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		if err := chatCollection.FindOne(ctx, bson.D{{"_id", chatId}}).Decode(&chat); err != nil {
+			log.Println("error while queueing webhook event: ", err)
+			return
+		}
+
+		if err := queueWebhookEvent(ctx, event, chat, data); err != nil {
+			log.Println("error while queueing webhook event: ", err)
+		}
+	}()
+}
+
+// queueWebhookEvent queues the event of the chat for the webhooks
+// emitWebhookEvent describes. It takes the chat itself, so events of chats
+// that are already gone can still be queued
+func queueWebhookEvent(ctx context.Context, event string, chat models.Chat, data interface{}) error {
+	scope := bson.A{bson.D{{"chat", chat.Id}}}
+	if !chat.Workspace.IsZero() {
+		workspaceScope := bson.D{{"workspace", chat.Workspace}}
+		if !chat.IsGroupChat || chat.Visibility != models.VisibilityPublic {
+			workspaceScope = append(workspaceScope, bson.E{"createdBy", bson.D{{"$in", chat.Users}}})
+		}
+		scope = append(scope, workspaceScope)
+	}
+	filter := bson.D{{"$or", scope}, {"active", true}, {"events", event}}
+
+	webhookCollection := database.OpenCollection(database.Client, "webhook")
+	cursor, err := webhookCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var hooks []models.Webhook
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := []interface{}{}
+	for _, hook := range hooks {
+		delivery, err := newDelivery(hook.Id, event, chat.Workspace, chat.Id, data, now)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	deliveryCollection := database.OpenCollection(database.Client, "webhookDelivery")
+	_, err = deliveryCollection.InsertMany(ctx, deliveries)
+	return err
+}
+
+// RunWebhooks sends, every interval, the webhook deliveries that are due.
+// Deliveries are kept in the database, so the ones queued while the backend
+// was down are sent once it's back. It never returns, main runs it in its
+// own goroutine
+func RunWebhooks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		if err := sendDueDeliveries(ctx, time.Now()); err != nil {
+			log.Println("error while sending webhook deliveries: ", err)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
+
+// sendDueDeliveries sends every pending delivery due at now, one at a time.
+// Each delivery is held for webhookLease while being sent so it's sent only
+// once. Failed ones are tried again after webhook.Backoff, until they've
+// been tried webhook.MaxAttempts times
+func sendDueDeliveries(ctx context.Context, now time.Time) error {
+	deliveryCollection := database.OpenCollection(database.Client, "webhookDelivery")
+	webhookCollection := database.OpenCollection(database.Client, "webhook")
+
+	due := bson.D{
+		{"status", models.DeliveryPending},
+		{"next_at", bson.D{{"$lte", now}}},
+		{"$or", bson.A{
+			bson.D{{"lockedUntil", bson.D{{"$exists", false}}}},
+			bson.D{{"lockedUntil", bson.D{{"$lt", now}}}},
+		}},
+	}
+	claim := bson.D{
+		{"$set", bson.D{{"lockedUntil", now.Add(webhookLease)}}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{"next_at", 1}}).SetReturnDocument(options.After)
+
+	for {
+		var delivery models.WebhookDelivery
+		err := deliveryCollection.FindOneAndUpdate(ctx, due, claim, opts).Decode(&delivery)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var hook models.Webhook
+		status := 0
+		err = webhookCollection.FindOne(ctx, bson.D{{"_id", delivery.Webhook}}).Decode(&hook)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			err = errors.New("webhook was deleted")
+		case err != nil:
+			return err
+		case !hook.Active:
+			err = errors.New("webhook is inactive")
+		default:
+			sendCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+			status, err = webhook.Default.Deliver(sendCtx, hook.URL, hook.Secret, delivery.Event, delivery.Id.Hex(), []byte(delivery.Payload))
+			cancel()
+		}
+
+		var update bson.D
+		switch {
+		case err == nil:
+			update = bson.D{
+				{"$set", bson.D{{"status", models.DeliveryDelivered}, {"responseStatus", status}, {"delivered_at", time.Now()}}},
+				{"$unset", bson.D{{"lockedUntil", ""}, {"next_at", ""}, {"error", ""}}},
+			}
+		case !hook.Active || delivery.Attempts >= webhook.MaxAttempts:
+			update = bson.D{
+				{"$set", bson.D{{"status", models.DeliveryFailed}, {"responseStatus", status}, {"error", err.Error()}}},
+				{"$unset", bson.D{{"lockedUntil", ""}, {"next_at", ""}}},
+			}
+		default:
+			update = bson.D{
+				{"$set", bson.D{
+					{"responseStatus", status},
+					{"error", err.Error()},
+					{"next_at", time.Now().Add(webhook.Backoff(delivery.Attempts))},
+				}},
+				{"$unset", bson.D{{"lockedUntil", ""}}},
+			}
+		}
+		if _, err := deliveryCollection.UpdateOne(ctx, bson.D{{"_id", delivery.Id}}, update); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/helpers"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxWorkspaceNameLength = 100
+
+// CreateWorkspace creates a workspace owned by the requesting user
+func CreateWorkspace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reqData map[string]interface{}
+
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		name, _ := reqData["name"].(string)
+		name = strings.TrimSpace(name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if len([]rune(name)) > maxWorkspaceNameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name can be at most %d characters", maxWorkspaceNameLength)})
+			return
+		}
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		workspace := models.Workspace{
+			Name:       name,
+			Members:    []primitive.ObjectID{userId},
+			Roles:      map[string]string{userId.Hex(): models.RoleOwner},
+			Created_at: time.Now(),
+			Updated_at: time.Now(),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		workspaceCollection := database.OpenCollection(database.Client, "workspace")
+		insId, err := workspaceCollection.InsertOne(ctx, workspace)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while creating workspace"})
+			log.Println(err)
+			return
+		}
+		workspace.Id = insId.InsertedID.(primitive.ObjectID)
+
+		c.JSON(http.StatusOK, workspace)
+	}
+}
+
+// GetUserWorkspaces lists the workspaces the user belongs to, oldest first
+func GetUserWorkspaces() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		workspaceCollection := database.OpenCollection(database.Client, "workspace")
+		opts := options.Find().SetSort(bson.D{{"_id", 1}})
+		cursor, err := workspaceCollection.Find(ctx, bson.D{{"members", userId}}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		workspaces := []models.Workspace{}
+		if err := cursor.All(ctx, &workspaces); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, workspaces)
+	}
+}
+
+// InviteWorkspaceMember lets admins of the workspace invite a registered user
+// to it by email, the user joins once they accept. The response is the same
+// whether or not the email is registered, so it can't be used to find out
+func InviteWorkspaceMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceId, err := primitive.ObjectIDFromHex(c.Param("workspaceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+		email, ok := reqData["email"].(string)
+		if !ok || email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		workspace, ok := requireWorkspaceRole(ctx, c, workspaceId, models.RoleAdmin)
+		if !ok {
+			return
+		}
+
+		invited := gin.H{"message": "The user is invited if the email is registered"}
+
+		var user models.User
+		userCollection := database.OpenCollection(database.Client, "user")
+		err = userCollection.FindOne(ctx, bson.D{{"email", email}}).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusOK, invited)
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			return
+		}
+
+		if workspace.HasMembers(user.Id) {
+			c.JSON(http.StatusOK, invited)
+			return
+		}
+
+		// a user has at most one pending invite to a workspace
+		inviteCollection := database.OpenCollection(database.Client, "workspaceInvite")
+		filter := bson.D{{"workspace", workspaceId}, {"user", user.Id}, {"status", models.WorkspaceInvitePending}}
+		update := bson.D{{"$setOnInsert", bson.D{
+			{"invitedBy", c.MustGet("_id").(primitive.ObjectID)},
+			{"created_at", time.Now()},
+		}}}
+		result, err := inviteCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		if result.UpsertedCount > 0 {
+			websocket.PublishToUser(user.Id.Hex(), map[string]interface{}{
+				"messageType": "workspaceInvite",
+				"workspace":   gin.H{"_id": workspace.Id, "name": workspace.Name},
+			})
+		}
+
+		c.JSON(http.StatusOK, invited)
+	}
+}
+
+// GetWorkspaceInvites lists the pending invites of the user along with the
+// workspaces they are invited to
+func GetWorkspaceInvites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		matchStage := bson.D{{"$match", bson.D{{"user", userId}, {"status", models.WorkspaceInvitePending}}}}
+
+		lookupStage := LookUpStage("workspace", "workspace", "_id", "workspace")
+
+		projectStage := bson.D{
+			{
+				"$project", bson.D{
+					{"status", 1},
+					{"invitedBy", 1},
+					{"created_at", 1},
+					{"workspace._id", 1},
+					{"workspace.name", 1},
+				},
+			},
+		}
+
+		inviteCollection := database.OpenCollection(database.Client, "workspaceInvite")
+		cursor, err := inviteCollection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, projectStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		results := []bson.M{}
+		if err := cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// DecideWorkspaceInvite accepts or declines a pending invite of the user,
+// given as action "accept" or "decline". Accepting adds the user to the
+// workspace
+func DecideWorkspaceInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		inviteId, err := primitive.ObjectIDFromHex(c.Param("inviteId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite id"})
+			return
+		}
+
+		var reqData map[string]interface{}
+		if err := c.BindJSON(&reqData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while parsing data"})
+			return
+		}
+
+		var status string
+		switch reqData["action"] {
+		case "accept":
+			status = models.WorkspaceInviteAccepted
+		case "decline":
+			status = models.WorkspaceInviteDeclined
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action must be accept or decline"})
+			return
+		}
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// only the invited user decides, and only once
+		inviteCollection := database.OpenCollection(database.Client, "workspaceInvite")
+		filter := bson.D{{"_id", inviteId}, {"user", userId}, {"status", models.WorkspaceInvitePending}}
+		update := bson.D{{"$set", bson.D{{"status", status}, {"decided_at", time.Now()}}}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var invite models.WorkspaceInvite
+		err = inviteCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invite)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pending invite not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		if status == models.WorkspaceInviteAccepted {
+			// users who joined meanwhile keep their role
+			workspaceCollection := database.OpenCollection(database.Client, "workspace")
+			update := bson.D{
+				{"$addToSet", bson.D{{"members", userId}}},
+				{"$set", bson.D{{"roles." + userId.Hex(), models.RoleMember}, {"updated_at", time.Now()}}},
+			}
+			result, err := workspaceCollection.UpdateOne(ctx, bson.D{{"_id", invite.Workspace}, {"members", bson.D{{"$ne", userId}}}}, update)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+				log.Println(err)
+				return
+			}
+			if result.ModifiedCount > 0 {
+				recordAudit(ctx, c, models.AuditEntry{Action: models.AuditWorkspaceMemberAdded, Target: userId, Workspace: invite.Workspace, Details: "invite accepted"})
+			}
+		}
+
+		c.JSON(http.StatusOK, invite)
+	}
+}
+
+// RemoveWorkspaceMember removes a user from the workspace and from every chat
+// of the workspace. Admins can remove members and the owner can remove
+// admins, anyone but the owner can remove themselves to leave
+func RemoveWorkspaceMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceId, err := primitive.ObjectIDFromHex(c.Param("workspaceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+			return
+		}
+		userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		workspace, ok := requireWorkspaceRole(ctx, c, workspaceId, models.RoleMember)
+		if !ok {
+			return
+		}
+
+		actorId := c.MustGet("_id").(primitive.ObjectID)
+		target := workspace.RoleOf(userId)
+		if target == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of the workspace"})
+			return
+		}
+		if target == models.RoleOwner {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The owner can't be removed from the workspace"})
+			return
+		}
+		if userId != actorId && !models.CanManage(workspace.RoleOf(actorId), target) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can't remove this user from the workspace"})
+			return
+		}
+
+		// leave the chats first, so nobody is left in a chat of a workspace
+		// they no longer belong to
+		chatCollection := database.OpenCollection(database.Client, "chat")
+		cursor, err := chatCollection.Find(ctx, bson.D{{"workspace", workspaceId}, {"users", userId}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		var chats []models.Chat
+		if err := cursor.All(ctx, &chats); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		for _, chat := range chats {
+			if err := leaveChat(ctx, chat, userId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+				log.Println(err)
+				return
+			}
+		}
+
+		// and their pending requests to join groups of the workspace can't be approved anymore
+		workspaceChats, err := chatCollection.Distinct(ctx, "_id", bson.D{{"workspace", workspaceId}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return
+		}
+		joinRequestCollection := database.OpenCollection(database.Client, "joinRequest")
+		_, err = joinRequestCollection.UpdateMany(ctx,
+			bson.D{{"user", userId}, {"chat", bson.D{{"$in", workspaceChats}}}, {"status", models.JoinRequestPending}},
+			bson.D{{"$set", bson.D{{"status", models.JoinRequestRejected}, {"decidedBy", actorId}, {"decided_at", time.Now()}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+
+		workspaceCollection := database.OpenCollection(database.Client, "workspace")
+		update := bson.D{
+			{"$pull", bson.D{{"members", userId}}},
+			{"$unset", bson.D{{"roles." + userId.Hex(), ""}}},
+			{"$set", bson.D{{"updated_at", time.Now()}}},
+		}
+		if _, err := workspaceCollection.UpdateOne(ctx, bson.D{{"_id", workspaceId}}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while updating document"})
+			log.Println(err)
+			return
+		}
+		recordAudit(ctx, c, models.AuditEntry{Action: models.AuditWorkspaceMemberRemoved, Target: userId, Workspace: workspaceId})
+
+		sendWorkspace(ctx, c, workspaceId)
+	}
+}
+
+// SwitchWorkspace makes the workspace the active one, by issuing a new token
+// carrying it
+func SwitchWorkspace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceId, err := primitive.ObjectIDFromHex(c.Param("workspaceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workspace id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		workspace, ok := requireWorkspaceRole(ctx, c, workspaceId, models.RoleMember)
+		if !ok {
+			return
+		}
+
+		userId := c.MustGet("_id").(primitive.ObjectID)
+		token, err := helpers.GenerateToken(userId.Hex(), c.GetString("name"), c.GetString("email"), workspaceId.Hex())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to generate token"})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "workspace": workspace})
+	}
+}
+
+// activeWorkspace returns the workspace carried by the token of the request,
+// the zero id when the user isn't working in a workspace
+func activeWorkspace(c *gin.Context) primitive.ObjectID {
+	workspace, _ := c.Get("workspace")
+	id, _ := workspace.(primitive.ObjectID)
+	return id
+}
+
+// workspaceScope matches the chats of the workspace, or the chats created
+// outside of any workspace for the zero id
+func workspaceScope(workspaceId primitive.ObjectID) bson.E {
+	if workspaceId.IsZero() {
+		return bson.E{"workspace", bson.D{{"$exists", false}}}
+	}
+	return bson.E{"workspace", workspaceId}
+}
+
+// defaultWorkspace returns the hex id of the oldest workspace the user belongs
+// to, which is active after login, or "" when they belong to none
+func defaultWorkspace(ctx context.Context, userId primitive.ObjectID) (string, error) {
+	var workspace models.Workspace
+
+	workspaceCollection := database.OpenCollection(database.Client, "workspace")
+	opts := options.FindOne().SetSort(bson.D{{"_id", 1}}).SetProjection(bson.D{{"_id", 1}})
+	err := workspaceCollection.FindOne(ctx, bson.D{{"members", userId}}, opts).Decode(&workspace)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return workspace.Id.Hex(), nil
+}
+
+// requireWorkspaceRole loads the workspace and checks the requesting user has
+// at least the given role in it. Otherwise it writes an error response and
+// returns false
+func requireWorkspaceRole(ctx context.Context, c *gin.Context, workspaceId primitive.ObjectID, role string) (models.Workspace, bool) {
+	userId := c.MustGet("_id").(primitive.ObjectID)
+
+	workspace, ok := findWorkspace(ctx, c, workspaceId)
+	if !ok {
+		return workspace, false
+	}
+
+	current := workspace.RoleOf(userId)
+	if current == "" {
+		// don't reveal workspaces to outsiders
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return workspace, false
+	}
+	if models.RoleRank(current) < models.RoleRank(role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this in the workspace"})
+		return workspace, false
+	}
+	return workspace, true
+}
+
+// requireWorkspaceMembers checks every one of the users belongs to the
+// workspace, writing an error response and returning false when one doesn't.
+// Chats outside of workspaces are for users outside of them, so for the zero
+// id it checks none of the users besides the requesting one belongs to a
+// workspace
+func requireWorkspaceMembers(ctx context.Context, c *gin.Context, workspaceId primitive.ObjectID, userIds ...primitive.ObjectID) bool {
+	if workspaceId.IsZero() {
+		requester := c.MustGet("_id").(primitive.ObjectID)
+		others := []primitive.ObjectID{}
+		for _, userId := range userIds {
+			if userId != requester {
+				others = append(others, userId)
+			}
+		}
+
+		workspaceCollection := database.OpenCollection(database.Client, "workspace")
+		count, err := workspaceCollection.CountDocuments(ctx, bson.D{{"members", bson.D{{"$in", others}}}}, options.Count().SetLimit(1))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+			log.Println(err)
+			return false
+		}
+		if count > 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Users of a workspace can only be reached from it"})
+			return false
+		}
+		return true
+	}
+
+	workspace, ok := findWorkspace(ctx, c, workspaceId)
+	if !ok {
+		return false
+	}
+	if !workspace.HasMembers(userIds...) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Users must be members of the workspace"})
+		return false
+	}
+	return true
+}
+
+// workspaceUsers returns the ids of the users who belong to any workspace
+func workspaceUsers(ctx context.Context) ([]primitive.ObjectID, error) {
+	workspaceCollection := database.OpenCollection(database.Client, "workspace")
+	values, err := workspaceCollection.Distinct(ctx, "members", bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if userId, ok := value.(primitive.ObjectID); ok {
+			userIds = append(userIds, userId)
+		}
+	}
+	return userIds, nil
+}
+
+// findWorkspace loads the workspace, writing an error response and returning
+// false when it doesn't exist
+func findWorkspace(ctx context.Context, c *gin.Context, workspaceId primitive.ObjectID) (models.Workspace, bool) {
+	var workspace models.Workspace
+
+	workspaceCollection := database.OpenCollection(database.Client, "workspace")
+	err := workspaceCollection.FindOne(ctx, bson.D{{"_id", workspaceId}}).Decode(&workspace)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return workspace, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking documents"})
+		log.Println(err)
+		return workspace, false
+	}
+	return workspace, true
+}
+
+// sendWorkspace responds with the current state of the workspace
+func sendWorkspace(ctx context.Context, c *gin.Context, workspaceId primitive.ObjectID) {
+	if workspace, ok := findWorkspace(ctx, c, workspaceId); ok {
+		c.JSON(http.StatusOK, workspace)
+	}
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes lists the indexes each collection needs besides _id
+var indexes = map[string][]mongo.IndexModel{
+	"user": {
+		// user search, named as it was when search created it
+		{Keys: bson.D{{"searchKeys", 1}}, Options: options.Index().SetName("searchKeys")},
+	},
+	"chat": {
+		{Keys: bson.D{{"users", 1}}},
+		{Keys: bson.D{{"visibility", 1}, {"chatName", 1}}},
+		{Keys: bson.D{{"workspace", 1}, {"users", 1}}},
+		{Keys: bson.D{{"deleted_at", 1}}, Options: options.Index().SetSparse(true)},
+	},
+	"message": {
+		{Keys: bson.D{{"chat", 1}, {"sender", 1}, {"created_at", -1}}},
+		{Keys: bson.D{{"deleted_at", 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{"mentions.recipients", 1}, {"created_at", -1}}, Options: options.Index().SetSparse(true)},
+	},
+	"pin": {
+		{Keys: bson.D{{"chat", 1}, {"message", 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"bookmark": {
+		{Keys: bson.D{{"user", 1}, {"message", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"user", 1}, {"created_at", -1}}},
+		{Keys: bson.D{{"message", 1}}},
+		{Keys: bson.D{{"chat", 1}}},
+	},
+	"scheduled": {
+		{Keys: bson.D{{"status", 1}, {"send_at", 1}}},
+		{Keys: bson.D{{"user", 1}, {"status", 1}, {"send_at", 1}}},
+	},
+	"device": {
+		{Keys: bson.D{{"platform", 1}, {"token", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"user", 1}}},
+	},
+	"notificationSetting": {
+		{Keys: bson.D{{"user", 1}, {"chat", 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"messageVersion": {
+		{Keys: bson.D{{"message", 1}, {"replaced_at", 1}}},
+		{Keys: bson.D{{"chat", 1}}},
+	},
+	"invite": {
+		{Keys: bson.D{{"token", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"chat", 1}}},
+	},
+	"report": {
+		{Keys: bson.D{{"status", 1}, {"created_at", 1}}},
+		{Keys: bson.D{{"reporter", 1}, {"status", 1}}},
+	},
+	"moderationLog": {
+		{Keys: bson.D{{"created_at", -1}}},
+	},
+	"audit": {
+		{Keys: bson.D{{"created_at", -1}}},
+		{Keys: bson.D{{"action", 1}, {"created_at", -1}}},
+		{Keys: bson.D{{"actor", 1}, {"created_at", -1}}},
+		{Keys: bson.D{{"target", 1}, {"created_at", -1}}},
+	},
+	"warning": {
+		{Keys: bson.D{{"user", 1}, {"created_at", -1}}},
+	},
+	"workspace": {
+		{Keys: bson.D{{"members", 1}}},
+	},
+	"workspaceInvite": {
+		{Keys: bson.D{{"workspace", 1}, {"user", 1}, {"status", 1}}},
+		{Keys: bson.D{{"user", 1}, {"status", 1}}},
+	},
+	"joinRequest": {
+		{Keys: bson.D{{"chat", 1}, {"status", 1}}},
+		{Keys: bson.D{{"user", 1}, {"chat", 1}, {"status", 1}}},
+	},
+	"webhook": {
+		{Keys: bson.D{{"chat", 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{"workspace", 1}}, Options: options.Index().SetSparse(true)},
+	},
+	"webhookDelivery": {
+		{Keys: bson.D{{"status", 1}, {"next_at", 1}}},
+		{Keys: bson.D{{"webhook", 1}, {"created_at", -1}}},
+		// the delivery log keeps a month of deliveries
+		{Keys: bson.D{{"created_at", 1}}, Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60)},
+	},
+}
+
+// EnsureIndexes creates any missing index listed in indexes
+func EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	for collectionName, models := range indexes {
+		collection := OpenCollection(Client, collectionName)
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package digest builds the emails that sum up the unread messages of a
+// user, works out when they may be sent and signs their unsubscribe links
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"unicode/utf8"
+)
+
+// maxSnippet bounds how much of a message a digest shows
+const maxSnippet = 200
+
+// Message is a message shown in a digest
+type Message struct {
+	Sender  string
+	Content string
+}
+
+// Chat is a chat with unread messages, Messages are the latest of them
+type Chat struct {
+	Name     string
+	Unread   int
+	Messages []Message
+}
+
+// More returns how many unread messages of the chat aren't shown
+func (c Chat) More() int {
+	return c.Unread - len(c.Messages)
+}
+
+// Digest is the email sent to the user Name about their unread messages
+type Digest struct {
+	Name           string
+	Chats          []Chat
+	AppURL         string
+	UnsubscribeURL string
+}
+
+// Unread returns how many unread messages there are across the chats
+func (d Digest) Unread() int {
+	total := 0
+	for _, chat := range d.Chats {
+		total += chat.Unread
+	}
+	return total
+}
+
+var funcs = map[string]interface{}{"snippet": snippet}
+
+var textTemplate = texttemplate.Must(texttemplate.New("text").Funcs(funcs).Parse(`Hi {{.Name}},
+
+You have {{.Unread}} unread {{if eq .Unread 1}}message{{else}}messages{{end}}{{if gt (len .Chats) 1}} in {{len .Chats}} chats{{end}}.
+{{range .Chats}}
+{{.Name}} ({{.Unread}} unread)
+{{range .Messages}}  {{.Sender}}: {{snippet .Content}}
+{{end}}{{if gt .More 0}}  and {{.More}} more
+{{end}}{{end}}
+Open the app to read them: {{.AppURL}}
+
+You get this email because you have unread messages. To stop getting it: {{.UnsubscribeURL}}
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(`<!doctype html>
+<html><body style="font-family:sans-serif">
+<p>Hi {{.Name}},</p>
+<p>You have {{.Unread}} unread {{if eq .Unread 1}}message{{else}}messages{{end}}{{if gt (len .Chats) 1}} in {{len .Chats}} chats{{end}}.</p>
+{{range .Chats}}<h3>{{.Name}} <small>({{.Unread}} unread)</small></h3>
+<ul>
+{{range .Messages}}<li><b>{{.Sender}}</b>: {{snippet .Content}}</li>
+{{end}}{{if gt .More 0}}<li>and {{.More}} more</li>
+{{end}}</ul>
+{{end}}<p><a href="{{.AppURL}}">Open the app to read them</a></p>
+<p style="color:#888;font-size:small">You get this email because you have unread messages. <a href="{{.UnsubscribeURL}}">Unsubscribe</a></p>
+</body></html>
+`))
+
+// Render returns the subject, text and HTML of the email for d. Names and
+// messages are escaped in the HTML
+func Render(d Digest) (subject, text, html string, err error) {
+	subject = fmt.Sprintf("You have %d unread messages", d.Unread())
+	if d.Unread() == 1 {
+		subject = "You have 1 unread message"
+	}
+
+	var buf bytes.Buffer
+	if err := textTemplate.Execute(&buf, d); err != nil {
+		return "", "", "", err
+	}
+	text = buf.String()
+
+	buf.Reset()
+	if err := htmlTemplate.Execute(&buf, d); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, buf.String(), nil
+}
+
+// snippet returns content on one line, cut to maxSnippet characters
+func snippet(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if utf8.RuneCountInString(content) > maxSnippet {
+		content = string([]rune(content)[:maxSnippet-1]) + "…"
+	}
+	return content
+}
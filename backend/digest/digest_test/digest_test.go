@@ -0,0 +1,145 @@
+package digest_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/digest"
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+func TestRender(t *testing.T) {
+	d := digest.Digest{
+		Name: "Ann",
+		Chats: []digest.Chat{
+			{Name: "Team <3", Unread: 5, Messages: []digest.Message{
+				{Sender: "Bob", Content: "<script>alert(1)</script>"},
+				{Sender: "Cat", Content: "multi\nline   message"},
+			}},
+			{Name: "Bob", Unread: 1, Messages: []digest.Message{{Sender: "Bob", Content: "hi"}}},
+		},
+		AppURL:         "https://chat.example.com",
+		UnsubscribeURL: "https://chat.example.com/api/user/digest/unsubscribe?user=1&sig=x",
+	}
+
+	subject, text, html, err := digest.Render(d)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if subject != "You have 6 unread messages" {
+		t.Errorf("Unexpected subject: %q", subject)
+	}
+
+	for _, want := range []string{"Hi Ann,", "6 unread messages in 2 chats", "Team <3 (5 unread)", "Cat: multi line message", "and 3 more", d.UnsubscribeURL} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Unexpected text, missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(html, "<script>") || !strings.Contains(html, "&lt;script&gt;") {
+		t.Errorf("Unexpected html, messages aren't escaped:\n%s", html)
+	}
+	if !strings.Contains(html, "Team &lt;3") {
+		t.Errorf("Unexpected html, chat names aren't escaped:\n%s", html)
+	}
+}
+
+func TestRenderOneMessage(t *testing.T) {
+	subject, text, _, err := digest.Render(digest.Digest{
+		Name:  "Ann",
+		Chats: []digest.Chat{{Name: "Bob", Unread: 1, Messages: []digest.Message{{Sender: "Bob", Content: "hi"}}}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if subject != "You have 1 unread message" {
+		t.Errorf("Unexpected subject: %q", subject)
+	}
+	if strings.Contains(text, "more") || strings.Contains(text, "chats") {
+		t.Errorf("Unexpected text:\n%s", text)
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		name     string
+		settings models.DigestSettings
+		now      time.Time
+		want     time.Time
+	}{
+		{
+			name:     "without quiet hours",
+			settings: models.DigestSettings{},
+			now:      time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "within quiet hours of the day",
+			settings: models.DigestSettings{QuietStart: "09:00", QuietEnd: "17:30"},
+			now:      time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 3, 1, 17, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "after quiet hours of the day",
+			settings: models.DigestSettings{QuietStart: "09:00", QuietEnd: "17:30"},
+			now:      time.Date(2024, 3, 1, 17, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "before midnight of quiet hours past midnight",
+			settings: models.DigestSettings{QuietStart: "22:00", QuietEnd: "07:00"},
+			now:      time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 3, 2, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "after midnight of quiet hours past midnight",
+			settings: models.DigestSettings{QuietStart: "22:00", QuietEnd: "07:00"},
+			now:      time.Date(2024, 3, 2, 6, 59, 0, 0, time.UTC),
+			want:     time.Date(2024, 3, 2, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "outside quiet hours past midnight",
+			settings: models.DigestSettings{QuietStart: "22:00", QuietEnd: "07:00"},
+			now:      time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "in the user's timezone",
+			settings: models.DigestSettings{QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Europe/Berlin"},
+			now:      time.Date(2024, 3, 1, 21, 30, 0, 0, time.UTC), // 22:30 in Berlin
+			want:     time.Date(2024, 3, 2, 7, 0, 0, 0, berlin),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := digest.QuietUntil(test.settings, test.now)
+			if !got.Equal(test.want) {
+				t.Errorf("Unexpected result: %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnsubscribeURL(t *testing.T) {
+	link := digest.UnsubscribeURL("https://chat.example.com", "secret", "64b0c0ffee")
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/api/user/digest/unsubscribe" {
+		t.Errorf("Unexpected path: %s", u.Path)
+	}
+
+	user, sig := u.Query().Get("user"), u.Query().Get("sig")
+	if !digest.Verify("secret", user, sig) {
+		t.Error("Unexpected result: signature isn't valid")
+	}
+	if digest.Verify("other secret", user, sig) {
+		t.Error("Unexpected result: signature is valid with another secret")
+	}
+	if digest.Verify("secret", "64b0c0ffef", sig) {
+		t.Error("Unexpected result: signature is valid for another user")
+	}
+}
@@ -0,0 +1,21 @@
+package digest
+
+import (
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/notify"
+)
+
+// QuietUntil returns when the quiet hours of the settings end if now is
+// within them, and the zero time otherwise
+func QuietUntil(settings models.DigestSettings, now time.Time) time.Time {
+	if settings.QuietStart == "" || settings.QuietEnd == "" {
+		return time.Time{}
+	}
+	return notify.QuietUntil(models.QuietHours{
+		Start:    settings.QuietStart,
+		End:      settings.QuietEnd,
+		Timezone: settings.Timezone,
+	}, now)
+}
@@ -0,0 +1,28 @@
+package digest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+)
+
+// Sign returns the signature that lets the unsubscribe link of the user
+// work without logging in, made with the server's secret
+func Sign(secret, userId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("digest-unsubscribe:" + userId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one Sign makes for the user
+func Verify(secret, userId, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, userId)), []byte(signature))
+}
+
+// UnsubscribeURL returns the link that turns off digests of the user, on
+// the backend at baseURL
+func UnsubscribeURL(baseURL, secret, userId string) string {
+	query := url.Values{"user": {userId}, "sig": {Sign(secret, userId)}}
+	return baseURL + "/api/user/digest/unsubscribe?" + query.Encode()
+}
@@ -0,0 +1,123 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxRules bounds the rules of a workspace or chat
+const MaxRules = 20
+
+// Input is a message about to be stored. Edit is set when existing content
+// is being replaced
+type Input struct {
+	Chat    primitive.ObjectID
+	Sender  primitive.ObjectID
+	Content string
+	Edit    bool
+}
+
+// Outcome is what the pipeline decided about a message. Content holds the
+// content to store, redacted where needed. Rejected messages must not be
+// stored, Reason tells why. Flags describe the rules of flag action that
+// matched, flagged messages are stored and sent to moderators
+type Outcome struct {
+	Content  string
+	Rejected bool
+	Reason   string
+	Flags    []string
+}
+
+// History answers questions about messages already sent
+type History interface {
+	// CountSame counts the messages of sender in the chat with exactly the
+	// content, sent since the given time
+	CountSame(ctx context.Context, chat, sender primitive.ObjectID, content string, since time.Time) (int64, error)
+}
+
+// Filter is one check of the pipeline
+type Filter interface {
+	// Match returns why the input matches the filter, or "" if it doesn't
+	Match(ctx context.Context, in Input) (string, error)
+	// Redact returns the content with the matching parts removed
+	Redact(content string) string
+}
+
+type step struct {
+	filter Filter
+	action string
+}
+
+// Pipeline runs filters in order, each with the action of its rule
+type Pipeline struct {
+	steps []step
+}
+
+// New builds the pipeline of the rules, history is only used by repeat
+// rules. It returns an error describing the first invalid rule
+func New(rules []models.FilterRule, history History) (*Pipeline, error) {
+	if len(rules) > MaxRules {
+		return nil, fmt.Errorf("at most %d filter rules are allowed", MaxRules)
+	}
+
+	p := &Pipeline{}
+	for i, rule := range rules {
+		if rule.Action != models.FilterReject && rule.Action != models.FilterRedact && rule.Action != models.FilterFlag {
+			return nil, fmt.Errorf("rule %d: action must be reject, redact or flag", i+1)
+		}
+
+		var f Filter
+		var err error
+		switch rule.Type {
+		case models.FilterBannedWords:
+			f, err = newBannedWords(rule.Words)
+		case models.FilterLinks:
+			f, err = newLinks(rule.Allow, rule.Deny)
+		case models.FilterMaxLength:
+			f, err = newMaxLength(rule.Max)
+		case models.FilterRepeat:
+			if rule.Action == models.FilterRedact {
+				err = fmt.Errorf("repeated messages can't be redacted")
+			} else {
+				f, err = newRepeat(rule.Max, rule.Window, history)
+			}
+		default:
+			err = fmt.Errorf("type must be bannedWords, links, maxLength or repeat")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+
+		p.steps = append(p.steps, step{f, rule.Action})
+	}
+	return p, nil
+}
+
+// Run passes the input through every filter. It stops at the first filter
+// rejecting it, redacted content is what later filters see
+func (p *Pipeline) Run(ctx context.Context, in Input) (Outcome, error) {
+	var flags []string
+	for _, step := range p.steps {
+		reason, err := step.filter.Match(ctx, in)
+		if err != nil {
+			return Outcome{}, err
+		}
+		if reason == "" {
+			continue
+		}
+
+		switch step.action {
+		case models.FilterReject:
+			return Outcome{Content: in.Content, Rejected: true, Reason: reason}, nil
+		case models.FilterRedact:
+			in.Content = step.filter.Redact(in.Content)
+		case models.FilterFlag:
+			flags = append(flags, reason)
+		}
+	}
+	return Outcome{Content: in.Content, Flags: flags}, nil
+}
@@ -0,0 +1,123 @@
+package filter_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/filter"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// history counts every message as sent count times
+type history struct {
+	count int64
+}
+
+func (h history) CountSame(ctx context.Context, chat, sender primitive.ObjectID, content string, since time.Time) (int64, error) {
+	return h.count, nil
+}
+
+func run(t *testing.T, rules []models.FilterRule, h filter.History, in filter.Input) filter.Outcome {
+	t.Helper()
+	pipeline, err := filter.New(rules, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcome, err := pipeline.Run(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outcome
+}
+
+func TestNew(t *testing.T) {
+	invalid := map[string][]models.FilterRule{
+		"unknown type":        {{Type: "caps", Action: models.FilterReject}},
+		"unknown action":      {{Type: models.FilterMaxLength, Action: "delete", Max: 10}},
+		"no banned words":     {{Type: models.FilterBannedWords, Action: models.FilterReject}},
+		"no domains":          {{Type: models.FilterLinks, Action: models.FilterReject}},
+		"no max length":       {{Type: models.FilterMaxLength, Action: models.FilterReject}},
+		"redacted repeats":    {{Type: models.FilterRepeat, Action: models.FilterRedact, Max: 3, Window: 60}},
+		"repeat has no range": {{Type: models.FilterRepeat, Action: models.FilterFlag, Max: 3}},
+	}
+	for name, rules := range invalid {
+		t.Run("returns error for "+name, func(t *testing.T) {
+			if _, err := filter.New(rules, nil); err == nil {
+				t.Errorf("Unexpected result: got no error for %v", rules)
+			}
+		})
+	}
+}
+
+func TestBannedWords(t *testing.T) {
+	rules := []models.FilterRule{{Type: models.FilterBannedWords, Action: models.FilterRedact, Words: []string{"darn", "heck"}}}
+
+	t.Run("returns redacted whole words ignoring case", func(t *testing.T) {
+		outcome := run(t, rules, nil, filter.Input{Content: "Darn it, what the heck, darned"})
+		if outcome.Content != "**** it, what the ****, darned" {
+			t.Errorf("Unexpected result: got %q", outcome.Content)
+		}
+	})
+
+	t.Run("returns rejection", func(t *testing.T) {
+		rules := []models.FilterRule{{Type: models.FilterBannedWords, Action: models.FilterReject, Words: []string{"darn"}}}
+		outcome := run(t, rules, nil, filter.Input{Content: "oh DARN"})
+		if !outcome.Rejected || outcome.Reason != "contains banned words" {
+			t.Errorf("Unexpected result: got %+v, want rejected", outcome)
+		}
+	})
+}
+
+func TestLinks(t *testing.T) {
+	t.Run("returns denied links redacted", func(t *testing.T) {
+		rules := []models.FilterRule{{Type: models.FilterLinks, Action: models.FilterRedact, Deny: []string{"spam.example"}}}
+		outcome := run(t, rules, nil, filter.Input{Content: "see https://docs.example/x and http://www.spam.example/buy"})
+		if outcome.Content != "see https://docs.example/x and [link removed]" {
+			t.Errorf("Unexpected result: got %q", outcome.Content)
+		}
+	})
+
+	t.Run("returns flags for links outside allow list", func(t *testing.T) {
+		rules := []models.FilterRule{{Type: models.FilterLinks, Action: models.FilterFlag, Allow: []string{"company.example"}}}
+		allowed := run(t, rules, nil, filter.Input{Content: "wiki at https://wiki.company.example/page"})
+		if len(allowed.Flags) != 0 {
+			t.Errorf("Unexpected result: got %v, want no flags", allowed.Flags)
+		}
+		other := run(t, rules, nil, filter.Input{Content: "try www.other.example"})
+		if len(other.Flags) != 1 || other.Content != "try www.other.example" {
+			t.Errorf("Unexpected result: got %+v, want one flag and content kept", other)
+		}
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	rules := []models.FilterRule{
+		{Type: models.FilterMaxLength, Action: models.FilterRedact, Max: 12},
+		{Type: models.FilterBannedWords, Action: models.FilterFlag, Words: []string{"secret"}},
+		{Type: models.FilterRepeat, Action: models.FilterReject, Max: 2, Window: 60},
+	}
+
+	t.Run("returns truncated content with flags", func(t *testing.T) {
+		outcome := run(t, rules, history{0}, filter.Input{Content: "the secret is out"})
+		if outcome.Content != "the secret i" || !reflect.DeepEqual(outcome.Flags, []string{"contains banned words"}) {
+			t.Errorf("Unexpected result: got %+v", outcome)
+		}
+	})
+
+	t.Run("returns rejection for repeated messages", func(t *testing.T) {
+		outcome := run(t, rules, history{2}, filter.Input{Content: "buy now"})
+		if !outcome.Rejected {
+			t.Errorf("Unexpected result: got %+v, want rejected", outcome)
+		}
+	})
+
+	t.Run("returns edits of repeated messages", func(t *testing.T) {
+		outcome := run(t, rules, history{2}, filter.Input{Content: "buy now", Edit: true})
+		if outcome.Rejected {
+			t.Errorf("Unexpected result: got %+v, want accepted", outcome)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxWords   = 500
+	maxDomains = 200
+)
+
+// linkPattern finds links with a scheme, and bare links starting with www.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+type bannedWords struct {
+	pattern *regexp.Regexp
+}
+
+func newBannedWords(words []string) (*bannedWords, error) {
+	if len(words) == 0 {
+		return nil, errors.New("words are required")
+	}
+	if len(words) > maxWords {
+		return nil, fmt.Errorf("at most %d words are allowed", maxWords)
+	}
+
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil, errors.New("words are required")
+	}
+	// whole words only, so banning "ass" leaves "class" alone
+	pattern, err := regexp.Compile(`(?i)(?:^|\b)(?:` + strings.Join(quoted, "|") + `)(?:\b|$)`)
+	if err != nil {
+		return nil, err
+	}
+	return &bannedWords{pattern}, nil
+}
+
+func (f *bannedWords) Match(ctx context.Context, in Input) (string, error) {
+	if f.pattern.MatchString(in.Content) {
+		return "contains banned words", nil
+	}
+	return "", nil
+}
+
+func (f *bannedWords) Redact(content string) string {
+	return f.pattern.ReplaceAllStringFunc(content, func(word string) string {
+		return strings.Repeat("*", len([]rune(word)))
+	})
+}
+
+type links struct {
+	allow []string
+	deny  []string
+}
+
+func newLinks(allow, deny []string) (*links, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, errors.New("allow or deny domains are required")
+	}
+	if len(allow)+len(deny) > maxDomains {
+		return nil, fmt.Errorf("at most %d domains are allowed", maxDomains)
+	}
+	return &links{allow: normalizeDomains(allow), deny: normalizeDomains(deny)}, nil
+}
+
+func (f *links) Match(ctx context.Context, in Input) (string, error) {
+	for _, link := range linkPattern.FindAllString(in.Content, -1) {
+		if !f.permitted(link) {
+			return "contains links to domains that aren't allowed", nil
+		}
+	}
+	return "", nil
+}
+
+func (f *links) Redact(content string) string {
+	return linkPattern.ReplaceAllStringFunc(content, func(link string) string {
+		if f.permitted(link) {
+			return link
+		}
+		return "[link removed]"
+	})
+}
+
+// permitted reports whether the link may stay. Links that can't be parsed
+// are only permitted when no allow list restricts them
+func (f *links) permitted(link string) bool {
+	host := linkHost(link)
+	if host == "" {
+		return len(f.allow) == 0
+	}
+	if matchesDomain(host, f.deny) {
+		return false
+	}
+	return len(f.allow) == 0 || matchesDomain(host, f.allow)
+}
+
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// matchesDomain reports whether host is one of domains or a subdomain of one
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized
+}
+
+type maxLength struct {
+	max int
+}
+
+func newMaxLength(max int) (*maxLength, error) {
+	if max < 1 {
+		return nil, errors.New("max must be a positive number of characters")
+	}
+	return &maxLength{max}, nil
+}
+
+func (f *maxLength) Match(ctx context.Context, in Input) (string, error) {
+	if len([]rune(in.Content)) > f.max {
+		return fmt.Sprintf("is longer than %d characters", f.max), nil
+	}
+	return "", nil
+}
+
+func (f *maxLength) Redact(content string) string {
+	runes := []rune(content)
+	if len(runes) <= f.max {
+		return content
+	}
+	return string(runes[:f.max])
+}
+
+type repeat struct {
+	max     int
+	window  time.Duration
+	history History
+}
+
+func newRepeat(max, window int, history History) (*repeat, error) {
+	if max < 1 {
+		return nil, errors.New("max must be a positive number of messages")
+	}
+	if window < 1 {
+		return nil, errors.New("window must be a positive number of seconds")
+	}
+	return &repeat{max: max, window: time.Duration(window) * time.Second, history: history}, nil
+}
+
+// Match counts earlier messages with the same content, edits aren't counted
+// as sending again
+func (f *repeat) Match(ctx context.Context, in Input) (string, error) {
+	if in.Edit || f.history == nil {
+		return "", nil
+	}
+	count, err := f.history.CountSame(ctx, in.Chat, in.Sender, in.Content, time.Now().Add(-f.window))
+	if err != nil {
+		return "", err
+	}
+	if count >= int64(f.max) {
+		return "repeats the same message too often", nil
+	}
+	return "", nil
+}
+
+func (f *repeat) Redact(content string) string {
+	return content
+}
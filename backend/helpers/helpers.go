@@ -1,6 +1,10 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"log"
 
@@ -25,3 +29,19 @@ func VerifyPassword(hashedPassword string, givenPassowrd string) (string, bool)
 	}
 	return "", isValid
 }
+
+// RandomToken returns a url safe random string carrying n random bytes
+func RandomToken(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// HashToken returns the hex sha256 of a random token, tokens are stored
+// hashed so a database leak doesn't reveal them
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
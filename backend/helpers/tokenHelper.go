@@ -13,17 +13,20 @@ type CustomClaims struct {
 	ID    string
 	Name  string
 	Email string
+	// Workspace is the hex id of the active workspace, empty outside of workspaces
+	Workspace string
 	jwt.RegisteredClaims
 }
 
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
-func GenerateToken(id, name, email string) (string, error) {
+func GenerateToken(id, name, email, workspace string) (string, error) {
 
 	claims := CustomClaims{
-		ID:    id,
-		Name:  name,
-		Email: email,
+		ID:        id,
+		Name:      name,
+		Email:     email,
+		Workspace: workspace,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
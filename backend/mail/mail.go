@@ -0,0 +1,63 @@
+// Package mail sends emails through a Mailer, an SMTP server or the log
+// during development. Nothing is sent unless a backend is configured
+package mail
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// Message is an email to one recipient. HTML is optional and sent as an
+// alternative to Text, Headers are extra headers such as List-Unsubscribe
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Default is the mailer emails are sent through, it's nil when sending
+// emails is off and is accessable to other files
+var Default Mailer
+
+// Init sets up the mailer selected by MAIL_BACKEND: "smtp" sends through
+// SMTP_HOST and SMTP_PORT, logging in with SMTP_USERNAME and SMTP_PASSWORD
+// when set, "log" only logs emails, and it's off otherwise. Emails are sent
+// from MAIL_FROM
+func Init() {
+	switch backend := os.Getenv("MAIL_BACKEND"); backend {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		mailer, err := NewSMTP(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+		if err != nil {
+			log.Fatal("Error setting up mail ", err)
+		}
+		Default = mailer
+		log.Println("sending emails through ", mailer.Addr)
+	case "log":
+		Default = Log{}
+		log.Println("logging emails instead of sending them")
+	case "", "off":
+	default:
+		log.Fatalf("unknown MAIL_BACKEND %q", backend)
+	}
+}
+
+// Log writes emails to the log instead of sending them
+type Log struct{}
+
+// Send logs the recipient, subject and text of m
+func (Log) Send(ctx context.Context, m Message) error {
+	log.Printf("email to %s: %s\n%s", m.To, m.Subject, m.Text)
+	return nil
+}
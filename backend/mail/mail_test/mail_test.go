@@ -0,0 +1,179 @@
+package mail_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/mail"
+)
+
+// received is what the stand-in server was sent
+type received struct {
+	from, to string
+	data     string
+}
+
+// standIn runs an SMTP server that accepts one message, it's sent to the
+// returned channel
+func standIn(t *testing.T) (string, <-chan received) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	out := make(chan received, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost ready")
+
+		var msg received
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL FROM:"):
+				msg.from = line[len("MAIL FROM:"):]
+				reply("250 ok")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				msg.to = line[len("RCPT TO:"):]
+				reply("250 ok")
+			case command == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(line, "."))
+				}
+				msg.data = data.String()
+				reply("250 queued")
+				out <- msg
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), out
+}
+
+func TestSMTP(t *testing.T) {
+	addr, out := standIn(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	mailer, err := mail.NewSMTP(host, port, "", "", "Chat <noreply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = mailer.Send(ctx, mail.Message{
+		To:      "Ann <ann@example.com>",
+		Subject: "3 unread messages – Chat",
+		Text:    "Hi Ann,\nyou have unread messages",
+		HTML:    "<p>Hi Ann,</p><p>you have unread messages</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<https://chat.example.com/unsubscribe>"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var msg received
+	select {
+	case msg = <-out:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unexpected result: no message was received")
+	}
+
+	if msg.from != "<noreply@example.com>" || msg.to != "<ann@example.com>" {
+		t.Errorf("Unexpected envelope: from %s to %s", msg.from, msg.to)
+	}
+
+	parsed, err := netmail.ReadMessage(strings.NewReader(msg.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != "3 unread messages – Chat" {
+		t.Errorf("Unexpected subject: %q", subject)
+	}
+	if got := parsed.Header.Get("List-Unsubscribe"); got != "<https://chat.example.com/unsubscribe>" {
+		t.Errorf("Unexpected List-Unsubscribe: %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Unexpected content type: %s", parsed.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	want := []string{"Hi Ann,\nyou have unread messages", "<p>Hi Ann,</p><p>you have unread messages</p>"}
+	for _, content := range want {
+		part, err := parts.NextRawPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// line breaks are sent as CRLF
+		body, _ := io.ReadAll(quotedprintable.NewReader(part))
+		if strings.ReplaceAll(string(body), "\r\n", "\n") != content {
+			t.Errorf("Unexpected part: %q, want %q", body, content)
+		}
+	}
+}
+
+func TestSMTPRejectsHeaderInjection(t *testing.T) {
+	mailer, err := mail.NewSMTP("127.0.0.1", "1", "", "", "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), mail.Message{
+		To:      "ann@example.com",
+		Subject: "Hello",
+		Text:    "Hi",
+		Headers: map[string]string{"List-Unsubscribe": "<https://x>\r\nBcc: eve@example.com"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "line break") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestNewSMTP(t *testing.T) {
+	if _, err := mail.NewSMTP("", "25", "", "", "noreply@example.com"); err == nil {
+		t.Error("Unexpected result: mailer without host")
+	}
+	if _, err := mail.NewSMTP("localhost", "25", "", "", "not an address"); err == nil {
+		t.Error("Unexpected result: mailer with invalid from address")
+	}
+}
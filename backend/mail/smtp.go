@@ -0,0 +1,196 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SMTP sends emails through an SMTP server. The connection is upgraded with
+// STARTTLS when the server offers it, port 465 uses TLS from the start
+type SMTP struct {
+	Addr string
+
+	host     string
+	username string
+	password string
+	from     *netmail.Address
+}
+
+// NewSMTP returns a mailer sending through the server at host and port as
+// from, an address with an optional name. Without a username it doesn't log in
+func NewSMTP(host, port, username, password, from string) (*SMTP, error) {
+	if host == "" {
+		return nil, errors.New("mail: SMTP host is required")
+	}
+	address, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid from address: %w", err)
+	}
+	return &SMTP{
+		Addr:     net.JoinHostPort(host, port),
+		host:     host,
+		username: username,
+		password: password,
+		from:     address,
+	}, nil
+}
+
+// Send delivers m to the server, giving up once ctx is done
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	to, err := netmail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("mail: invalid recipient: %w", err)
+	}
+	data, err := compose(s.from, to, m)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if strings.HasSuffix(s.Addr, ":465") {
+		conn = tls.Client(conn, &tls.Config{ServerName: s.host})
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		// PlainAuth refuses to send the password unencrypted to other hosts
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose writes m as a MIME message, with the HTML as an alternative to the
+// text when there's one
+func compose(from, to *netmail.Address, m Message) ([]byte, error) {
+	headers := map[string]string{
+		"From":         from.String(),
+		"To":           to.String(),
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   messageId(from.Address),
+		"MIME-Version": "1.0",
+	}
+	for key, value := range m.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+
+	var body bytes.Buffer
+	if m.HTML == "" {
+		headers["Content-Type"] = "text/plain; charset=utf-8"
+		headers["Content-Transfer-Encoding"] = "quoted-printable"
+		if err := writeQuoted(&body, m.Text); err != nil {
+			return nil, err
+		}
+	} else {
+		parts := multipart.NewWriter(&body)
+		headers["Content-Type"] = "multipart/alternative; boundary=" + parts.Boundary()
+		for _, part := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", m.Text},
+			{"text/html; charset=utf-8", m.HTML},
+		} {
+			w, err := parts.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuoted(w, part.content); err != nil {
+				return nil, err
+			}
+		}
+		if err := parts.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var message bytes.Buffer
+	for _, key := range keys {
+		// line breaks in values would let them add headers of their own
+		if strings.ContainsAny(key+headers[key], "\r\n") {
+			return nil, fmt.Errorf("mail: header %s has a line break", key)
+		}
+		fmt.Fprintf(&message, "%s: %s\r\n", key, headers[key])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// writeQuoted writes s to w in the quoted-printable encoding
+func writeQuoted(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// messageId returns a unique id for a message sent from address
+func messageId(address string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	domain := "localhost"
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		domain = address[i+1:]
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
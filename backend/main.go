@@ -3,13 +3,21 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/pmohanj/web-chat-app/controllers"
 	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/mail"
+	"github.com/pmohanj/web-chat-app/push"
 	"github.com/pmohanj/web-chat-app/routes"
+	"github.com/pmohanj/web-chat-app/search"
+	"github.com/pmohanj/web-chat-app/storage"
+	"github.com/pmohanj/web-chat-app/unfurl"
+	"github.com/pmohanj/web-chat-app/webhook"
 	"github.com/pmohanj/web-chat-app/websocket"
 )
 
@@ -21,9 +29,51 @@ func main() {
 
 	r := gin.Default()
 
+	// Only trust the X-Forwarded-For of the proxies in front of the backend,
+	// otherwise clients could choose the IP audit entries record
+	var trustedProxies []string
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = strings.Split(proxies, ",")
+		for i := range trustedProxies {
+			trustedProxies[i] = strings.TrimSpace(trustedProxies[i])
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Error setting trusted proxies ", err)
+	}
+
 	// Initiate Databse
 	MongoDBURL := os.Getenv("MONGODB_URL")
 	database.DBinstance(MongoDBURL)
+	if err := database.EnsureIndexes(); err != nil {
+		log.Fatal("Error creating indexes ", err)
+	}
+
+	// Select where uploaded files are kept
+	storage.Init()
+
+	// Build or check the message search index
+	search.Init()
+
+	// Purge messages and chats deleted for everyone once they're past retention
+	controllers.InitDeletion()
+	go controllers.PurgeDeleted(time.Hour)
+
+	// Optionally limit how long after sending messages can be edited
+	controllers.InitEditing()
+
+	// Fetch previews of links in messages unless turned off
+	unfurl.Init()
+
+	// Set up the push notification providers that are configured
+	push.Init()
+
+	// Set up the mailer digests of unread messages are sent through
+	mail.Init()
+	controllers.InitDigests()
+
+	// Decide which addresses outgoing webhooks may post to
+	webhook.Init()
 
 	// Allows all origins, not suitable for prod environments
 	r.Use(cors.New(cors.Config{
@@ -36,11 +86,28 @@ func main() {
 	routes.AddUserRoutes(api)
 	routes.AddChatRoutes(api)
 	routes.AddMessageRoutes(api)
+	routes.AddWorkspaceRoutes(api)
+	routes.AddModerationRoutes(api)
+	routes.AddAdminRoutes(api)
+	routes.AddWebhookRoutes(api)
 
 	// create websocketserver
 	websocket := websocket.CreateWebSocketsServer()
 
 	go websocket.SendMessage()
+
+	// Send scheduled messages and reminders once they're due, after the
+	// websocket server exists so they reach connected clients
+	go controllers.RunScheduler(10 * time.Second)
+
+	// Email digests of unread messages to users who have been away, which
+	// needs a mailer
+	if mail.Default != nil {
+		go controllers.RunDigests(15 * time.Minute)
+	}
+
+	// Post queued chat events to webhooks, retrying failed deliveries
+	go controllers.RunWebhooks(10 * time.Second)
 	routes.AddWebScoketRouter(api, websocket)
 
 	r.Run(":8000")
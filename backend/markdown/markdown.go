@@ -0,0 +1,348 @@
+// Package markdown renders the Markdown subset messages support into HTML
+// that is safe to insert in a page as is. Rendering is sanitizing: every
+// piece of the source is escaped, and the only markup produced is the tags
+// below with the attributes the renderer sets itself
+//
+//	p br strong em del code pre a ul ol li blockquote
+//
+// Raw HTML in the source comes out as text, and links are kept only when
+// they point to http, https or mailto urls
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MaxLength bounds the source of a message that gets rendered
+const MaxLength = 8000
+
+// maxDepth bounds how deep blockquotes and emphasis nest, deeper markers are
+// left as text
+const maxDepth = 5
+
+var (
+	fencePattern     = regexp.MustCompile("^ {0,3}(```+)\\s*([A-Za-z0-9_+-]*)\\s*$")
+	bulletPattern    = regexp.MustCompile(`^ {0,3}[-*+] +(.*)$`)
+	orderedPattern   = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)] +(.*)$`)
+	quotePattern     = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	allowedLinkRoots = map[string]bool{"http": true, "https": true, "mailto": true}
+)
+
+// Render returns the HTML for src
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\r", "\n")
+
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), 0)
+	return b.String()
+}
+
+// renderBlocks renders lines as code blocks, quotes, lists and paragraphs
+func renderBlocks(b *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case fencePattern.MatchString(line):
+			match := fencePattern.FindStringSubmatch(line)
+			fence, lang := match[1], match[2]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) && strings.Trim(strings.TrimSpace(lines[i]), "`") == "" {
+					i++
+					break
+				}
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code")
+			if lang != "" {
+				b.WriteString(` class="language-` + html.EscapeString(strings.ToLower(lang)) + `"`)
+			}
+			b.WriteString(">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>")
+
+		case depth < maxDepth && quotePattern.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && quotePattern.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quotePattern.FindStringSubmatch(lines[i])[1])
+			}
+			b.WriteString("<blockquote>")
+			renderBlocks(b, quoted, depth+1)
+			b.WriteString("</blockquote>")
+
+		case bulletPattern.MatchString(line):
+			i = renderList(b, lines, i, bulletPattern, "ul", depth)
+
+		case orderedPattern.MatchString(line):
+			i = renderList(b, lines, i, orderedPattern, "ol", depth)
+
+		default:
+			var paragraph []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i], depth); i++ {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+			}
+			b.WriteString("<p>")
+			renderLines(b, paragraph, depth)
+			b.WriteString("</p>")
+		}
+	}
+}
+
+// renderList renders the items of a list starting at line i, lines that
+// aren't items continue the previous one. It returns the line after the list
+func renderList(b *strings.Builder, lines []string, i int, item *regexp.Regexp, tag string, depth int) int {
+	first := i
+	var items [][]string
+	for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+		if match := item.FindStringSubmatch(lines[i]); match != nil {
+			items = append(items, []string{strings.TrimSpace(match[len(match)-1])})
+			continue
+		}
+		if startsBlock(lines[i], depth) {
+			break
+		}
+		items[len(items)-1] = append(items[len(items)-1], strings.TrimSpace(lines[i]))
+	}
+
+	b.WriteString("<" + tag)
+	if tag == "ol" {
+		// lists numbered from other than 1 keep their first number
+		if start := strings.TrimLeft(orderedPattern.FindStringSubmatch(lines[first])[1], "0"); start != "1" {
+			if start == "" {
+				start = "0"
+			}
+			b.WriteString(` start="` + start + `"`)
+		}
+	}
+	b.WriteString(">")
+	for _, lines := range items {
+		b.WriteString("<li>")
+		renderLines(b, lines, depth)
+		b.WriteString("</li>")
+	}
+	b.WriteString("</" + tag + ">")
+	return i
+}
+
+// startsBlock reports whether line begins a block other than a paragraph
+func startsBlock(line string, depth int) bool {
+	return fencePattern.MatchString(line) ||
+		(depth < maxDepth && quotePattern.MatchString(line)) ||
+		bulletPattern.MatchString(line) ||
+		orderedPattern.MatchString(line)
+}
+
+// renderLines renders lines of text joined by line breaks
+func renderLines(b *strings.Builder, lines []string, depth int) {
+	for n, line := range lines {
+		if n > 0 {
+			b.WriteString("<br>")
+		}
+		renderInline(b, line, depth)
+	}
+}
+
+// renderInline renders code spans, links, emphasis and escapes of s
+func renderInline(b *strings.Builder, s string, depth int) {
+	var text strings.Builder
+	flush := func() {
+		b.WriteString(html.EscapeString(text.String()))
+		text.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_~[]()#+-.!>", s[i+1]) >= 0:
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			run := runLength(s, i, '`')
+			fence := s[i : i+run]
+			if end := strings.Index(s[i+run:], fence); end >= 0 {
+				flush()
+				code := s[i+run : i+run+end]
+				if trimmed := strings.TrimSpace(code); trimmed != "" {
+					code = trimmed
+				}
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += run + end + run
+				continue
+			}
+			text.WriteString(fence)
+			i += run
+			continue
+
+		case c == '[' && depth < maxDepth:
+			if label, target, n, ok := parseLink(s[i:]); ok {
+				flush()
+				b.WriteString(`<a href="` + html.EscapeString(target) + `" rel="nofollow noopener noreferrer" target="_blank">`)
+				renderInline(b, label, maxDepth) // no emphasis or links inside links
+				b.WriteString("</a>")
+				i += n
+				continue
+			}
+
+		case (c == 'h' || c == 'H') && depth < maxDepth && startsWord(s, i):
+			if target, n := parseAutolink(s[i:]); n > 0 {
+				flush()
+				b.WriteString(`<a href="` + html.EscapeString(target) + `" rel="nofollow noopener noreferrer" target="_blank">` + html.EscapeString(target) + "</a>")
+				i += n
+				continue
+			}
+
+		case (c == '*' || c == '_' || c == '~') && depth < maxDepth:
+			if tag, inner, n, ok := parseEmphasis(s, i); ok {
+				flush()
+				b.WriteString("<" + tag + ">")
+				renderInline(b, inner, depth+1)
+				b.WriteString("</" + tag + ">")
+				i += n
+				continue
+			}
+		}
+
+		text.WriteByte(c)
+		i++
+	}
+	flush()
+}
+
+// parseLink reads [label](target) at the start of s. It returns false when
+// s doesn't start with a link or its target isn't an allowed url
+func parseLink(s string) (label, target string, n int, ok bool) {
+	close := matchingBracket(s)
+	if close < 0 || close+1 >= len(s) || s[close+1] != '(' {
+		return "", "", 0, false
+	}
+	end := strings.IndexByte(s[close+2:], ')')
+	if end < 0 {
+		return "", "", 0, false
+	}
+	label = s[1:close]
+	target = strings.TrimSpace(s[close+2 : close+2+end])
+	if label == "" || !allowedURL(target) {
+		return "", "", 0, false
+	}
+	return label, target, close + 2 + end + 1, true
+}
+
+// matchingBracket returns the index of the ] closing the [ at the start of
+// s, or -1
+func matchingBracket(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseAutolink reads a bare http or https url at the start of s, leaving
+// out trailing punctuation. It returns the url and its length, 0 if none
+func parseAutolink(s string) (string, int) {
+	lower := strings.ToLower(s)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return "", 0
+	}
+	n := strings.IndexAny(s, " \t<>\"'`")
+	if n < 0 {
+		n = len(s)
+	}
+	n = len(strings.TrimRight(s[:n], ".,;:!?)*_~]"))
+	if !allowedURL(s[:n]) {
+		return "", 0
+	}
+	return s[:n], n
+}
+
+// allowedURL reports whether target is an absolute url with an allowed
+// scheme, which keeps out javascript: and data: urls
+func allowedURL(target string) bool {
+	if target == "" || strings.ContainsAny(target, " \t\n") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil || !allowedLinkRoots[strings.ToLower(u.Scheme)] {
+		return false
+	}
+	return u.Scheme == "mailto" || u.Host != ""
+}
+
+// parseEmphasis reads emphasis opened at s[i]: ** or __ for strong, * or _
+// for em and ~~ for del. The text must not start or end with a space, and _
+// only works on word boundaries so snake_case stays as is
+func parseEmphasis(s string, i int) (tag, inner string, n int, ok bool) {
+	c := s[i]
+	run := runLength(s, i, c)
+	var marker string
+	switch {
+	case c == '~' && run >= 2:
+		tag, marker = "del", "~~"
+	case c != '~' && run >= 2:
+		tag, marker = "strong", s[i:i+2]
+	case c != '~':
+		tag, marker = "em", s[i:i+1]
+	default:
+		return "", "", 0, false
+	}
+	if c == '_' && !startsWord(s, i) {
+		return "", "", 0, false
+	}
+
+	start := i + len(marker)
+	if start >= len(s) || s[start] == ' ' {
+		return "", "", 0, false
+	}
+	for j := start + 1; j < len(s); j++ {
+		if s[j] != c || s[j-1] == c || s[j-1] == ' ' || s[j-1] == '\\' {
+			continue
+		}
+		// the marker closes with the end of a run, so markers of emphasis
+		// inside it close first. A run of two doesn't close a single marker
+		run := runLength(s, j, c)
+		end := j + run - len(marker)
+		if run < len(marker) || (len(marker) == 1 && run == 2) ||
+			(c == '_' && end+len(marker) < len(s) && isWordByte(s[end+len(marker)])) {
+			j += run - 1
+			continue
+		}
+		return tag, s[start:end], end + len(marker) - i, true
+	}
+	return "", "", 0, false
+}
+
+// runLength returns how many times c repeats from s[i]
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// startsWord reports whether s[i] is at the start of a word
+func startsWord(s string, i int) bool {
+	return i == 0 || !isWordByte(s[i-1])
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
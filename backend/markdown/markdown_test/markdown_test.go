@@ -0,0 +1,135 @@
+package markdown_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pmohanj/web-chat-app/markdown"
+)
+
+func TestRender(t *testing.T) {
+	tests := map[string]struct {
+		src  string
+		want string
+	}{
+		"plain text": {
+			src:  "hello there",
+			want: "<p>hello there</p>",
+		},
+		"line breaks and paragraphs": {
+			src:  "one\ntwo\n\nthree",
+			want: "<p>one<br>two</p><p>three</p>",
+		},
+		"emphasis": {
+			src:  "**bold** *it* _also it_ ~~gone~~",
+			want: "<p><strong>bold</strong> <em>it</em> <em>also it</em> <del>gone</del></p>",
+		},
+		"nested emphasis": {
+			src:  "**bold *and it***",
+			want: "<p><strong>bold <em>and it</em></strong></p>",
+		},
+		"snake case is not emphasis": {
+			src:  "call get_user_name now",
+			want: "<p>call get_user_name now</p>",
+		},
+		"unclosed markers stay": {
+			src:  "2 * 3 = 6 and **not bold",
+			want: "<p>2 * 3 = 6 and **not bold</p>",
+		},
+		"escaped markers": {
+			src:  `\*not it\*`,
+			want: "<p>*not it*</p>",
+		},
+		"inline code is not parsed": {
+			src:  "run `rm -rf *` *now*",
+			want: "<p>run <code>rm -rf *</code> <em>now</em></p>",
+		},
+		"code block": {
+			src:  "```go\nfmt.Println(\"<hi>\")\n```",
+			want: "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>",
+		},
+		"unclosed code block runs to the end": {
+			src:  "```\n**x**",
+			want: "<pre><code>**x**</code></pre>",
+		},
+		"links": {
+			src:  "see [the *docs*](https://example.com/a?b=1&c=2)",
+			want: `<p>see <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer" target="_blank">the *docs*</a></p>`,
+		},
+		"autolinks": {
+			src:  "go to https://example.com/x.",
+			want: `<p>go to <a href="https://example.com/x" rel="nofollow noopener noreferrer" target="_blank">https://example.com/x</a>.</p>`,
+		},
+		"bullet list": {
+			src:  "- one\n- two\n  more\n* three",
+			want: "<ul><li>one</li><li>two<br>more</li><li>three</li></ul>",
+		},
+		"ordered list": {
+			src:  "3. three\n4. four",
+			want: `<ol start="3"><li>three</li><li>four</li></ol>`,
+		},
+		"blockquote": {
+			src:  "> quoted **text**\n> - item\n\nafter",
+			want: "<blockquote><p>quoted <strong>text</strong></p><ul><li>item</li></ul></blockquote><p>after</p>",
+		},
+		"raw html is text": {
+			src:  `<img src=x onerror="alert(1)">`,
+			want: "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>",
+		},
+		"javascript links are text": {
+			src:  "[click](javascript:alert(1))",
+			want: "<p>[click](javascript:alert(1))</p>",
+		},
+		"data links are text": {
+			src:  "[x](data:text/html;base64,PHNjcmlwdD4=)",
+			want: "<p>[x](data:text/html;base64,PHNjcmlwdD4=)</p>",
+		},
+		"quotes in links are escaped": {
+			src:  `[x](https://e.com/"onmouseover="alert(1))`,
+			want: `<p><a href="https://e.com/&#34;onmouseover=&#34;alert(1" rel="nofollow noopener noreferrer" target="_blank">x</a>)</p>`,
+		},
+		"code block language is restricted": {
+			src:  "```go\"><script>\nx\n```",
+			want: "<p>```go&#34;&gt;&lt;script&gt;<br>x</p><pre><code></code></pre>",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := markdown.Render(test.src); got != test.want {
+				t.Errorf("Unexpected result: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderDeepNesting(t *testing.T) {
+	src := strings.Repeat("> ", 50) + "deep"
+	got := markdown.Render(src)
+	if n := strings.Count(got, "<blockquote>"); n > 5 {
+		t.Errorf("Unexpected result: got %v blockquotes, want at most %v", n, 5)
+	}
+	if !strings.Contains(got, "deep") {
+		t.Errorf("Unexpected result: %q is missing the text", got)
+	}
+}
+
+func TestRenderOnlyAllowedTags(t *testing.T) {
+	allowed := map[string]bool{
+		"p": true, "br": true, "strong": true, "em": true, "del": true, "code": true,
+		"pre": true, "a": true, "ul": true, "ol": true, "li": true, "blockquote": true,
+	}
+	src := "<script>alert(1)</script>\n**<b>x</b>** [<i>y</i>](https://e.com) `<u>`\n- <iframe>\n> <style>"
+	got := markdown.Render(src)
+
+	for i := 0; i < len(got); i++ {
+		if got[i] != '<' {
+			continue
+		}
+		end := strings.IndexAny(got[i:], " >")
+		tag := strings.TrimPrefix(got[i+1:i+end], "/")
+		if !allowed[tag] {
+			t.Errorf("Unexpected tag %q in %q", tag, got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package mention finds the users a message mentions. Clients insert @ and
+// the id of the user picked from the chat members, showing their name in
+// its place, and @all or @here to mention the whole chat or the members
+// online
+package mention
+
+import (
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Keywords mentioning more than one user
+const (
+	All  = "all"
+	Here = "here"
+)
+
+// mentionPattern matches @ followed by a user id or keyword, where the @
+// starts a word so emails aren't taken for mentions
+var mentionPattern = regexp.MustCompile(`(?i)(?:^|[^\w@.])@([0-9a-f]{24}|all|here)\b`)
+
+// Parsed is what a message mentions: users by id, in the order they first
+// appear, and whether @all or @here was used
+type Parsed struct {
+	Users []primitive.ObjectID
+	All   bool
+	Here  bool
+}
+
+// Empty reports whether nothing is mentioned
+func (p Parsed) Empty() bool {
+	return len(p.Users) == 0 && !p.All && !p.Here
+}
+
+// Parse returns the mentions in content
+func Parse(content string) Parsed {
+	var parsed Parsed
+	seen := make(map[primitive.ObjectID]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		switch token := strings.ToLower(match[1]); token {
+		case All:
+			parsed.All = true
+		case Here:
+			parsed.Here = true
+		default:
+			id, err := primitive.ObjectIDFromHex(token)
+			if err != nil || seen[id] {
+				continue
+			}
+			seen[id] = true
+			parsed.Users = append(parsed.Users, id)
+		}
+	}
+	return parsed
+}
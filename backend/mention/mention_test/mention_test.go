@@ -0,0 +1,71 @@
+package mention_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pmohanj/web-chat-app/mention"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParse(t *testing.T) {
+	alice := primitive.NewObjectID()
+	bob := primitive.NewObjectID()
+
+	tests := map[string]struct {
+		content string
+		want    mention.Parsed
+	}{
+		"no mentions": {
+			content: "hello there",
+			want:    mention.Parsed{},
+		},
+		"users in order without repeats": {
+			content: "@" + bob.Hex() + " and @" + alice.Hex() + ", ping @" + bob.Hex(),
+			want:    mention.Parsed{Users: []primitive.ObjectID{bob, alice}},
+		},
+		"upper case id": {
+			content: "hi @" + strings.ToUpper(alice.Hex()),
+			want:    mention.Parsed{Users: []primitive.ObjectID{alice}},
+		},
+		"all and here": {
+			content: "@All standup in 5, @here first",
+			want:    mention.Parsed{All: true, Here: true},
+		},
+		"emails are not mentions": {
+			content: "mail user@all.com or bob@here",
+			want:    mention.Parsed{},
+		},
+		"keyword must end the word": {
+			content: "@allison @hereafter",
+			want:    mention.Parsed{},
+		},
+		"after punctuation": {
+			content: "(@here)",
+			want:    mention.Parsed{Here: true},
+		},
+		"invalid id": {
+			content: "@" + alice.Hex()[:23] + "z",
+			want:    mention.Parsed{},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := mention.Parse(test.content)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Unexpected result: got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	if !(mention.Parsed{}).Empty() {
+		t.Errorf("Unexpected result: got %v, want %v", false, true)
+	}
+	if (mention.Parsed{Here: true}).Empty() {
+		t.Errorf("Unexpected result: got %v, want %v", true, false)
+	}
+}
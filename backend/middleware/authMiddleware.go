@@ -1,17 +1,28 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/pmohanj/web-chat-app/database"
 	"github.com/pmohanj/web-chat-app/helpers"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// seenResolution is how stale the time a user was last seen gets before a
+// request updates it, so not every request writes to the user
+const seenResolution = 5 * time.Minute
+
 // Authenticate acts as authorization middleware that receives the client request
 // and performs validation of the provided token
 func Authenticate() gin.HandlerFunc {
@@ -33,9 +44,68 @@ func Authenticate() gin.HandlerFunc {
 		if err != nil {
 			log.Panic(err)
 		}
+
+		// suspension and revoked tokens take effect immediately, so the
+		// current state of the user is checked on every request
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var user models.User
+		userCollection := database.OpenCollection(database.Client, "user")
+		opts := options.FindOne().SetProjection(bson.D{{"suspended", 1}, {"tokensValidAfter", 1}, {"lastSeenAt", 1}})
+		err = userCollection.FindOne(ctx, bson.D{{"_id", id}}, opts).Decode(&user)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+			log.Println(err)
+			c.Abort()
+			return
+		}
+		if user.Suspended {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your account is suspended"})
+			c.Abort()
+			return
+		}
+		if claims.IssuedAt == nil || claims.IssuedAt.Time.Before(user.TokensValidAfter) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+			c.Abort()
+			return
+		}
+		if now := time.Now(); now.Sub(user.LastSeenAt) > seenResolution {
+			if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", id}}, bson.D{{"$set", bson.D{{"lastSeenAt", now}}}}); err != nil {
+				log.Println("error while updating last seen: ", err)
+			}
+		}
 		c.Set("_id", id)
 		c.Set("name", claims.Name)
 		c.Set("email", claims.Email)
+
+		// the active workspace is the zero id for users outside of workspaces,
+		// and for users removed from the workspace of their token since it
+		// was issued
+		var workspace primitive.ObjectID
+		if claims.Workspace != "" {
+			if workspace, err = primitive.ObjectIDFromHex(claims.Workspace); err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+				c.Abort()
+				return
+			}
+			workspaceCollection := database.OpenCollection(database.Client, "workspace")
+			member, err := workspaceCollection.CountDocuments(ctx, bson.D{{"_id", workspace}, {"members", id}}, options.Count().SetLimit(1))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error while querying user data"})
+				log.Println(err)
+				c.Abort()
+				return
+			}
+			if member == 0 {
+				workspace = primitive.NilObjectID
+			}
+		}
+		c.Set("workspace", workspace)
 		c.Next()
 	}
 }
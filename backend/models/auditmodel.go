@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditLogin                  = "login"
+	AuditLoginFailed            = "login.failed"
+	AuditPasswordReset          = "password.reset"
+	AuditTokensRevoked          = "tokens.revoked"
+	AuditMemberAdded            = "member.added"
+	AuditMemberRemoved          = "member.removed"
+	AuditMemberJoined           = "member.joined"
+	AuditMemberLeft             = "member.left"
+	AuditRoleChanged            = "role.changed"
+	AuditOwnerChanged           = "owner.changed"
+	AuditChatDeleted            = "chat.deleted"
+	AuditChatRestored           = "chat.restored"
+	AuditMessageDeleted         = "message.deleted"
+	AuditMessageRestored        = "message.restored"
+	AuditUserSuspended          = "user.suspended"
+	AuditUserUnsuspended        = "user.unsuspended"
+	AuditReportModerated        = "report.moderated"
+	AuditWorkspaceMemberAdded   = "workspace.member.added"
+	AuditWorkspaceMemberRemoved = "workspace.member.removed"
+)
+
+// AuditEntry records a security or administrative action. Actor is zero when
+// nobody is known, like a failed login for an unknown email. Entries are
+// append-only, nothing updates or deletes them
+type AuditEntry struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Action     string             `json:"action" bson:"action"`
+	Actor      primitive.ObjectID `json:"actor,omitempty" bson:"actor,omitempty"`
+	Target     primitive.ObjectID `json:"target,omitempty" bson:"target,omitempty"`
+	Chat       primitive.ObjectID `json:"chat,omitempty" bson:"chat,omitempty"`
+	Message    primitive.ObjectID `json:"message,omitempty" bson:"message,omitempty"`
+	Workspace  primitive.ObjectID `json:"workspace,omitempty" bson:"workspace,omitempty"`
+	Details    string             `json:"details,omitempty" bson:"details,omitempty"`
+	IP         string             `json:"ip" bson:"ip"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -6,13 +6,92 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Visibility of a group chat. Public group chats are channels listed in the
+// directory that anyone can join, chats without a visibility are private
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+)
+
+// Roles a user can have in a group chat, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
 type Chat struct {
 	Id            primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
 	IsGroupChat   bool                 `json:"isGroupChat" bson:"isGroupChat"` // should default to false
 	ChatName      string               `json:"chatName" bson:"chatName"`
 	Users         []primitive.ObjectID `json:"users" bson:"users"`
 	LatestMessage primitive.ObjectID   `json:"latestMessage" bson:"latestMessage"`
-	GroupAdmin    primitive.ObjectID   `json:"groupAdmin" bson:"groupAdmin"`
+	GroupAdmin    primitive.ObjectID   `json:"groupAdmin" bson:"groupAdmin"`           // owner of the group chat
+	Roles         map[string]string    `json:"roles,omitempty" bson:"roles,omitempty"` // user id hex -> role, group chats only
+	Visibility    string               `json:"visibility,omitempty" bson:"visibility,omitempty"`
+	Topic         string               `json:"topic,omitempty" bson:"topic,omitempty"`
+	Description   string               `json:"description,omitempty" bson:"description,omitempty"`
+	Workspace     primitive.ObjectID   `json:"workspace,omitempty" bson:"workspace,omitempty"` // unset for chats outside of workspaces
+	Filters       []FilterRule         `json:"filters,omitempty" bson:"filters,omitempty"`     // run after the filters of the workspace
+	Cleared       map[string]time.Time `json:"-" bson:"cleared,omitempty"`                     // user id hex -> when they deleted the chat for themselves
+	DeletedBy     primitive.ObjectID   `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"` // set when deleted for everyone
+	Deleted_at    time.Time            `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 	Created_at    time.Time            `json:"created_at" bson:"created_at"`
 	Updated_at    time.Time            `json:"updated_at" bson:"updated_at"`
 }
+
+// RoleOf returns the role of the user in the group chat, or "" if they
+// aren't a member. Groups created before roles existed only have a GroupAdmin,
+// who is treated as the owner
+func (c *Chat) RoleOf(userId primitive.ObjectID) string {
+	if role, exists := c.Roles[userId.Hex()]; exists {
+		return role
+	}
+	for _, user := range c.Users {
+		if user == userId {
+			if userId == c.GroupAdmin {
+				return RoleOwner
+			}
+			return RoleMember
+		}
+	}
+	return ""
+}
+
+// RoleRank orders roles by privilege, unknown roles rank lowest
+func RoleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case RoleAdmin:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// CanManage reports whether a user with role actor may change the membership
+// or role of a user with role target, which needs a strictly higher role
+func CanManage(actor, target string) bool {
+	return RoleRank(actor) > RoleRank(target)
+}
+
+// NextOwner picks who takes over the group when the owner leaves: the
+// earliest added admin, else the earliest added member. It returns false
+// when nobody else is left
+func (c *Chat) NextOwner() (primitive.ObjectID, bool) {
+	var member primitive.ObjectID
+	found := false
+	for _, user := range c.Users {
+		switch c.RoleOf(user) {
+		case RoleAdmin:
+			return user, true
+		case RoleMember:
+			if !found {
+				member, found = user, true
+			}
+		}
+	}
+	return member, found
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Platforms a device receives push notifications through
+const (
+	PlatformWebPush = "webpush"
+	PlatformFCM     = "fcm"
+	PlatformAPNs    = "apns"
+)
+
+// MaxDevices bounds how many devices a user can register
+const MaxDevices = 20
+
+// Device is where a user gets push notifications while they aren't
+// connected. Token is the registration token for fcm and apns, and the
+// endpoint of the subscription for webpush, which also has Keys
+type Device struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	User       primitive.ObjectID `json:"user" bson:"user"`
+	Platform   string             `json:"platform" bson:"platform"`
+	Token      string             `json:"token" bson:"token"`
+	Keys       *WebPushKeys       `json:"-" bson:"keys,omitempty"`
+	Name       string             `json:"name,omitempty" bson:"name,omitempty"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// WebPushKeys are the keys of a web push subscription, base64url encoded.
+// P256dh is the public key the payload is encrypted for and Auth the secret
+// it's authenticated with
+type WebPushKeys struct {
+	P256dh string `json:"p256dh" bson:"p256dh"`
+	Auth   string `json:"auth" bson:"auth"`
+}
+
+// How many of the messages of a chat notify the user. Users without a level
+// are notified of all messages
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions" // only messages mentioning them or matching their keywords
+)
+
+// MaxKeywords bounds how many keywords a user can be alerted of
+const MaxKeywords = 20
+
+// NotificationSetting holds how a user is notified of messages, for one chat
+// or, without a chat, for all of them. A mute without an end lasts until
+// it's lifted. Keywords alert the user of messages containing them even at
+// the mentions level, the ones of both settings apply. DND is only set for
+// all chats and holds back push and email notifications on its schedule
+type NotificationSetting struct {
+	Id          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	User        primitive.ObjectID `json:"user" bson:"user"`
+	Chat        primitive.ObjectID `json:"chat,omitempty" bson:"chat,omitempty"`
+	Muted       bool               `json:"muted" bson:"muted"`
+	Muted_until time.Time          `json:"muted_until,omitempty" bson:"muted_until,omitempty"`
+	Level       string             `json:"level" bson:"level,omitempty"`
+	Keywords    []string           `json:"keywords" bson:"keywords,omitempty"`
+	DND         *QuietHours        `json:"dnd,omitempty" bson:"dnd,omitempty"`
+	Updated_at  time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// QuietHours is a daily period between "HH:MM" times in Timezone, which
+// may wrap past midnight. With Days it's only on those weekdays, 0 being
+// Sunday, by the day it starts
+type QuietHours struct {
+	Start    string `json:"start" bson:"start"`
+	End      string `json:"end" bson:"end"`
+	Timezone string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	Days     []int  `json:"days,omitempty" bson:"days,omitempty"`
+}
+
+// IsMuted reports whether the setting mutes notifications at now
+func (s *NotificationSetting) IsMuted(now time.Time) bool {
+	return s.Muted && (s.Muted_until.IsZero() || now.Before(s.Muted_until))
+}
@@ -0,0 +1,34 @@
+package models
+
+// Types of content filter rule
+const (
+	FilterBannedWords = "bannedWords"
+	FilterLinks       = "links"
+	FilterMaxLength   = "maxLength"
+	FilterRepeat      = "repeat"
+)
+
+// What happens to a message matched by a content filter rule
+const (
+	FilterReject = "reject"
+	FilterRedact = "redact"
+	FilterFlag   = "flag"
+)
+
+// FilterRule configures one content filter of a workspace or chat. Which
+// fields apply depends on Type:
+//   - bannedWords matches any of Words, ignoring case
+//   - links matches links to Deny domains, or when Allow is set, to any
+//     domain not in Allow
+//   - maxLength matches content longer than Max characters
+//   - repeat matches the same content sent more than Max times within the
+//     last Window seconds, it can't redact
+type FilterRule struct {
+	Type   string   `json:"type" bson:"type"`
+	Action string   `json:"action" bson:"action"`
+	Words  []string `json:"words,omitempty" bson:"words,omitempty"`
+	Allow  []string `json:"allow,omitempty" bson:"allow,omitempty"`
+	Deny   []string `json:"deny,omitempty" bson:"deny,omitempty"`
+	Max    int      `json:"max,omitempty" bson:"max,omitempty"`
+	Window int      `json:"window,omitempty" bson:"window,omitempty"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses of a request to join a group through an invite
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestRejected = "rejected"
+)
+
+// Invite is a shareable token that lets users join a group chat by themselves
+type Invite struct {
+	Id               primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Token            string             `json:"token" bson:"token"`
+	Chat             primitive.ObjectID `json:"chat" bson:"chat"`
+	CreatedBy        primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	ExpiresAt        time.Time          `json:"expiresAt" bson:"expiresAt"` // zero means it never expires
+	MaxUses          int                `json:"maxUses" bson:"maxUses"`     // zero means unlimited
+	Uses             int                `json:"uses" bson:"uses"`
+	Revoked          bool               `json:"revoked" bson:"revoked"`
+	RequiresApproval bool               `json:"requiresApproval" bson:"requiresApproval"`
+	Created_at       time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Usable reports whether the invite can still be used to join at now
+func (i *Invite) Usable(now time.Time) bool {
+	if i.Revoked {
+		return false
+	}
+	if !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt) {
+		return false
+	}
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
+
+// JoinRequest is created when a user joins through an invite that requires
+// approval, it waits for an admin of the group to decide on it
+type JoinRequest struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Chat       primitive.ObjectID `json:"chat" bson:"chat"`
+	User       primitive.ObjectID `json:"user" bson:"user"`
+	Invite     primitive.ObjectID `json:"invite" bson:"invite"`
+	Status     string             `json:"status" bson:"status"`
+	DecidedBy  primitive.ObjectID `json:"decidedBy,omitempty" bson:"decidedBy,omitempty"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+	Decided_at time.Time          `json:"decided_at,omitempty" bson:"decided_at,omitempty"`
+}
@@ -6,12 +6,124 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Types of message, messages stored before types existed have none and are
+// text messages
+const (
+	MessageText   = "text"
+	MessageSystem = "system"
+	MessagePoll   = "poll"
+)
+
+// Formats of the content of text messages, messages without one are plain
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// DeletedContent replaces the content of messages deleted for everyone
+const DeletedContent = "This message was deleted"
+
+// Kinds of system event recorded in a chat
+const (
+	EventGroupCreated  = "group.created"
+	EventGroupRenamed  = "group.renamed"
+	EventGroupUpdated  = "group.updated"
+	EventMemberAdded   = "member.added"
+	EventMemberJoined  = "member.joined"
+	EventMemberRemoved = "member.removed"
+	EventMemberLeft    = "member.left"
+	EventRoleChanged   = "role.changed"
+	EventOwnerChanged  = "owner.changed"
+)
+
 type Message struct {
-	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Sender     primitive.ObjectID `json:"sender" bson:"sender"`
-	Content    string             `json:"content" bson:"content"`
-	Chat       primitive.ObjectID `json:"chat" bson:"chat"`
-	IsEdited   bool               `json:"isedited" bson:"isedited"`
-	Created_at time.Time          `json:"created_at" bson:"created_at"`
-	Updated_at time.Time          `json:"updated_at" bson:"updated_at"`
+	Id         primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	Sender     primitive.ObjectID   `json:"sender" bson:"sender"`
+	Content    string               `json:"content" bson:"content"`
+	Format     string               `json:"format,omitempty" bson:"format,omitempty"`
+	Rendered   string               `json:"rendered,omitempty" bson:"rendered,omitempty"` // sanitized HTML of markdown content
+	Chat       primitive.ObjectID   `json:"chat" bson:"chat"`
+	IsEdited   bool                 `json:"isedited" bson:"isedited"`
+	Type       string               `json:"type" bson:"type"`
+	Event      *SystemEvent         `json:"event,omitempty" bson:"event,omitempty"`
+	Poll       *Poll                `json:"poll,omitempty" bson:"poll,omitempty"`
+	Mentions   *Mentions            `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	Previews   []LinkPreview        `json:"previews,omitempty" bson:"previews,omitempty"`
+	Hidden     map[string]time.Time `json:"-" bson:"hidden,omitempty"`                      // user id hex -> when they deleted it for themselves
+	DeletedBy  primitive.ObjectID   `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"` // set when deleted for everyone
+	Deleted_at time.Time            `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	Created_at time.Time            `json:"created_at" bson:"created_at"`
+	Updated_at time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// SystemEvent describes a membership or metadata change of a chat. Actor
+// made the change, Target is the user it applied to if any, and Value holds
+// the new name or role
+type SystemEvent struct {
+	Kind   string             `json:"kind" bson:"kind"`
+	Actor  primitive.ObjectID `json:"actor" bson:"actor"`
+	Target primitive.ObjectID `json:"target,omitempty" bson:"target,omitempty"`
+	Value  string             `json:"value,omitempty" bson:"value,omitempty"`
+}
+
+// Mentions are the users a message mentions by id, and whether it mentions
+// the whole chat with @all or the members online with @here. Recipients are
+// every user the mentions notified, which the mentions feed is built from
+type Mentions struct {
+	Users      []primitive.ObjectID `json:"users" bson:"users"`
+	All        bool                 `json:"all,omitempty" bson:"all,omitempty"`
+	Here       bool                 `json:"here,omitempty" bson:"here,omitempty"`
+	Recipients []primitive.ObjectID `json:"-" bson:"recipients"`
+}
+
+// LinkPreview describes the page a link in a message points to, from its
+// Open Graph or Twitter card tags. Previews are added to the message once
+// they're fetched, after it was sent
+type LinkPreview struct {
+	URL         string `json:"url" bson:"url"`
+	Title       string `json:"title,omitempty" bson:"title,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	Image       string `json:"image,omitempty" bson:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty" bson:"siteName,omitempty"`
+}
+
+// Bounds on the options of a poll
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 10
+)
+
+// Poll is a question, the content of its message, members vote on. Votes
+// holds the options each user picked, it's never sent to clients so votes of
+// anonymous polls stay secret. A poll closes at Closes_at if set, or when its
+// creator closes it
+type Poll struct {
+	Options   []PollOption     `json:"options" bson:"options"`
+	Multiple  bool             `json:"multiple" bson:"multiple"`
+	Anonymous bool             `json:"anonymous" bson:"anonymous"`
+	Closes_at time.Time        `json:"closes_at,omitempty" bson:"closes_at,omitempty"`
+	Closed_at time.Time        `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+	Votes     map[string][]int `json:"-" bson:"votes,omitempty"` // user id hex -> indexes of the options they picked
+}
+
+// PollOption is an answer of a poll along with how many users picked it
+type PollOption struct {
+	Text  string `json:"text" bson:"text"`
+	Votes int    `json:"votes" bson:"votes"`
+}
+
+// IsClosed reports whether the poll no longer takes votes at now
+func (p *Poll) IsClosed(now time.Time) bool {
+	return !p.Closed_at.IsZero() || (!p.Closes_at.IsZero() && !now.Before(p.Closes_at))
+}
+
+// MessageVersion keeps content a message had before it was edited. Created_at
+// is when the content was written and Replaced_at when an edit replaced it
+type MessageVersion struct {
+	Id          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Message     primitive.ObjectID `json:"message" bson:"message"`
+	Chat        primitive.ObjectID `json:"chat" bson:"chat"`
+	Content     string             `json:"content" bson:"content"`
+	Created_at  time.Time          `json:"created_at" bson:"created_at"`
+	Replaced_at time.Time          `json:"replaced_at" bson:"replaced_at"`
 }
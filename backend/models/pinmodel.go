@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxPins bounds how many messages a chat can have pinned at once
+const MaxPins = 50
+
+// Pin marks a message of a chat as pinned, every member sees it
+type Pin struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Chat       primitive.ObjectID `json:"chat" bson:"chat"`
+	Message    primitive.ObjectID `json:"message" bson:"message"`
+	PinnedBy   primitive.ObjectID `json:"pinnedBy" bson:"pinnedBy"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Bookmark is a message a user saved for themselves, nobody else sees it
+type Bookmark struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	User       primitive.ObjectID `json:"user" bson:"user"`
+	Message    primitive.ObjectID `json:"message" bson:"message"`
+	Chat       primitive.ObjectID `json:"chat" bson:"chat"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a report is about
+const (
+	ReportMessage = "message"
+	ReportUser    = "user"
+)
+
+// Why content is reported
+const (
+	ReasonSpam       = "spam"
+	ReasonHarassment = "harassment"
+	ReasonHate       = "hate"
+	ReasonOther      = "other"
+	ReasonFilter     = "filter" // flagged by a content filter, the report has no reporter
+)
+
+// Statuses of a report in the moderation queue
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned"
+)
+
+// Actions a moderator can take on a report
+const (
+	ModerationDismiss       = "dismiss"
+	ModerationDeleteMessage = "deleteMessage"
+	ModerationWarn          = "warn"
+	ModerationSuspend       = "suspend"
+)
+
+// Report flags a message or a user for the moderators. For message reports
+// User is the sender, and Content keeps what the message said when reported
+type Report struct {
+	Id          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Kind        string             `json:"kind" bson:"kind"`
+	Reporter    primitive.ObjectID `json:"reporter" bson:"reporter"`
+	User        primitive.ObjectID `json:"user" bson:"user"`
+	Message     primitive.ObjectID `json:"message,omitempty" bson:"message,omitempty"`
+	Chat        primitive.ObjectID `json:"chat,omitempty" bson:"chat,omitempty"`
+	Content     string             `json:"content,omitempty" bson:"content,omitempty"`
+	Reason      string             `json:"reason" bson:"reason"`
+	Details     string             `json:"details,omitempty" bson:"details,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	Action      string             `json:"action,omitempty" bson:"action,omitempty"`
+	ModeratedBy primitive.ObjectID `json:"moderatedBy,omitempty" bson:"moderatedBy,omitempty"`
+	Created_at  time.Time          `json:"created_at" bson:"created_at"`
+	Resolved_at time.Time          `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+// ModerationEntry records a decision of a moderator, entries are never
+// changed once written
+type ModerationEntry struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Report     primitive.ObjectID `json:"report" bson:"report"`
+	Moderator  primitive.ObjectID `json:"moderator" bson:"moderator"`
+	Action     string             `json:"action" bson:"action"`
+	Target     primitive.ObjectID `json:"target" bson:"target"`
+	Message    primitive.ObjectID `json:"message,omitempty" bson:"message,omitempty"`
+	Note       string             `json:"note,omitempty" bson:"note,omitempty"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Warning is sent to a user by a moderator acting on a report
+type Warning struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	User       primitive.ObjectID `json:"user" bson:"user"`
+	Report     primitive.ObjectID `json:"report" bson:"report"`
+	Reason     string             `json:"reason" bson:"reason"`
+	Note       string             `json:"note,omitempty" bson:"note,omitempty"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What is scheduled, a message sent to a chat or a reminder about a message
+// sent back to its user
+const (
+	ScheduledMessage  = "message"
+	ScheduledReminder = "reminder"
+)
+
+// Statuses of a scheduled item, pending ones can still be edited or cancelled
+const (
+	SchedulePending = "pending"
+	ScheduleSent    = "sent"
+	ScheduleFailed  = "failed"
+)
+
+// Scheduled is a message or reminder the scheduler sends at Send_at. For
+// messages Content is what gets sent to Chat; for reminders Message is the
+// message to be reminded about and Content an optional note. Once a message
+// is sent, Sent is the id of the message it became
+type Scheduled struct {
+	Id          primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Kind        string             `json:"kind" bson:"kind"`
+	User        primitive.ObjectID `json:"user" bson:"user"`
+	Chat        primitive.ObjectID `json:"chat" bson:"chat"`
+	Message     primitive.ObjectID `json:"message,omitempty" bson:"message,omitempty"`
+	Content     string             `json:"content" bson:"content"`
+	Send_at     time.Time          `json:"send_at" bson:"send_at"`
+	Status      string             `json:"status" bson:"status"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	Sent        primitive.ObjectID `json:"sent,omitempty" bson:"sent,omitempty"`
+	Sent_at     time.Time          `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+	LockedUntil time.Time          `json:"-" bson:"lockedUntil,omitempty"`
+	Created_at  time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at  time.Time          `json:"updated_at" bson:"updated_at"`
+}
@@ -1,11 +1,29 @@
 package models
 
 import (
+	"os"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Who can start a direct chat with a user. Users without a setting can be
+// messaged by everyone
+const (
+	DMPrivacyEveryone = "everyone"
+	DMPrivacyShared   = "shared" // users sharing a workspace or group chat with them
+	DMPrivacyNobody   = "nobody"
+)
+
+// How often a user is emailed a digest of their unread messages. Users
+// without a setting get a daily digest
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
 type User struct {
 	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
 	Name       string             `json:"name" bson:"name"`
@@ -16,8 +34,69 @@ type User struct {
 	Created_at time.Time          `json:"-" bson:"created_at"`
 	Updated_at time.Time          `json:"-" bson:"updated_at"`
 	Token      string             `json:"token" bson:"-"`
+
+	// SearchKeys hold the lowercased name, its words and the email, user
+	// search matches prefixes of these through an index
+	SearchKeys   []string             `json:"-" bson:"searchKeys"`
+	BlockedUsers []primitive.ObjectID `json:"-" bson:"blockedUsers,omitempty"`
+	DMPrivacy    string               `json:"-" bson:"dmPrivacy,omitempty"`
+	Suspended    bool                 `json:"-" bson:"suspended,omitempty"` // set by moderators and admins, suspended users are locked out
+
+	// TokensValidAfter revokes every token issued before it, it's kept to
+	// whole seconds like the issue time of tokens
+	TokensValidAfter time.Time `json:"-" bson:"tokensValidAfter,omitempty"`
+	// ResetTokenHash is set while an admin forced password reset is pending,
+	// the user can't log in until they set a new password with the token
+	ResetTokenHash string    `json:"-" bson:"resetTokenHash,omitempty"`
+	ResetExpiresAt time.Time `json:"-" bson:"resetExpiresAt,omitempty"`
+
+	// LastSeenAt is when the user last used the app, messages sent after it
+	// are unread and go into their digest
+	LastSeenAt time.Time       `json:"-" bson:"lastSeenAt,omitempty"`
+	Digest     *DigestSettings `json:"-" bson:"digest,omitempty"`
 }
 
+// DigestSettings are when the user gets digest emails. No digest is sent
+// during the quiet hours, "HH:MM" times in the user's timezone, which may
+// wrap past midnight
+type DigestSettings struct {
+	Frequency  string `json:"frequency" bson:"frequency"`
+	QuietStart string `json:"quietStart" bson:"quietStart,omitempty"`
+	QuietEnd   string `json:"quietEnd" bson:"quietEnd,omitempty"`
+	Timezone   string `json:"timezone" bson:"timezone,omitempty"`
+
+	// Last_at is when the last digest was compiled, it covers messages up to
+	// then. Next_at is when the next one may be, it also holds off other
+	// instances while a digest is being sent
+	Last_at time.Time `json:"-" bson:"last_at,omitempty"`
+	Next_at time.Time `json:"-" bson:"next_at,omitempty"`
+}
+
+// Period returns how long apart digests are sent, zero when they're off
+func (s DigestSettings) Period() time.Duration {
+	switch s.Frequency {
+	case DigestOff:
+		return 0
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// SetDefaultPic points the user's pic to the avatar served by the backend,
+// which is generated from the user id until they upload one. Id must be set
 func (u *User) SetDefaultPic() {
-	u.Pic = "https://res.cloudinary.com/dkqc4za4f/image/upload/v1671523788/default_toic85.png"
+	u.Pic = os.Getenv("PUBLIC_URL") + "/api/user/avatar/" + u.Id.Hex()
+}
+
+// SetSearchKeys derives SearchKeys from the name and email of the user
+func (u *User) SetSearchKeys() {
+	name := strings.ToLower(strings.TrimSpace(u.Name))
+	keys := []string{name}
+	for _, word := range strings.Fields(name) {
+		if word != name {
+			keys = append(keys, word)
+		}
+	}
+	u.SearchKeys = append(keys, strings.ToLower(u.Email))
 }
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Events outgoing webhooks are sent besides the system events of chats,
+// such as member.added, which keep the kind of their system message. Ping
+// is only sent when testing a webhook
+const (
+	EventMessageCreated = "message.created"
+	EventMessageUpdated = "message.updated"
+	EventMessageDeleted = "message.deleted"
+	EventChatDeleted    = "chat.deleted"
+	EventPing           = "ping"
+)
+
+// WebhookEvents lists the events webhooks can subscribe to
+var WebhookEvents = []string{
+	EventMessageCreated, EventMessageUpdated, EventMessageDeleted, EventChatDeleted,
+	EventGroupCreated, EventGroupRenamed, EventGroupUpdated,
+	EventMemberAdded, EventMemberJoined, EventMemberRemoved, EventMemberLeft,
+	EventRoleChanged, EventOwnerChanged,
+}
+
+// MaxWebhooks bounds how many webhooks a chat or workspace can have
+const MaxWebhooks = 10
+
+// Webhook posts the events it subscribed to, of one chat or of the channels
+// of a workspace and the chats CreatedBy is a member of, to its URL. Payloads are signed with Secret, which is only
+// shown when the webhook is created
+type Webhook struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Workspace  primitive.ObjectID `json:"workspace,omitempty" bson:"workspace,omitempty"`
+	Chat       primitive.ObjectID `json:"chat,omitempty" bson:"chat,omitempty"`
+	URL        string             `json:"url" bson:"url"`
+	Events     []string           `json:"events" bson:"events"`
+	Secret     string             `json:"-" bson:"secret"`
+	Active     bool               `json:"active" bson:"active"`
+	CreatedBy  primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Status of a webhook delivery. Pending deliveries are retried with growing
+// delays until they're delivered or run out of attempts
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an event sent to a webhook, and how the attempts to
+// send it went. The same payload is sent on every attempt
+type WebhookDelivery struct {
+	Id             primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Webhook        primitive.ObjectID `json:"webhook" bson:"webhook"`
+	Event          string             `json:"event" bson:"event"`
+	Payload        string             `json:"payload" bson:"payload"`
+	Status         string             `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	ResponseStatus int                `json:"responseStatus,omitempty" bson:"responseStatus,omitempty"` // of the last attempt
+	Error          string             `json:"error,omitempty" bson:"error,omitempty"`                   // of the last attempt
+	Next_at        time.Time          `json:"next_at,omitempty" bson:"next_at,omitempty"`               // when a pending delivery is attempted
+	LockedUntil    time.Time          `json:"-" bson:"lockedUntil,omitempty"`                           // held while being sent
+	Created_at     time.Time          `json:"created_at" bson:"created_at"`
+	Delivered_at   time.Time          `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
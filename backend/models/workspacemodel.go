@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Workspace is a team sharing the deployment. Its members only find each
+// other, and chats created in it stay in it
+type Workspace struct {
+	Id         primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	Name       string               `json:"name" bson:"name"`
+	Members    []primitive.ObjectID `json:"members" bson:"members"`
+	Roles      map[string]string    `json:"roles" bson:"roles"`                         // user id hex -> owner, admin or member
+	Filters    []FilterRule         `json:"filters,omitempty" bson:"filters,omitempty"` // content filters of every chat in it
+	Created_at time.Time            `json:"created_at" bson:"created_at"`
+	Updated_at time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// RoleOf returns the role of the user in the workspace, or "" if they
+// aren't a member
+func (w *Workspace) RoleOf(userId primitive.ObjectID) string {
+	return w.Roles[userId.Hex()]
+}
+
+// HasMembers reports whether every one of the users belongs to the workspace
+func (w *Workspace) HasMembers(userIds ...primitive.ObjectID) bool {
+	for _, userId := range userIds {
+		if w.RoleOf(userId) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Statuses of an invite to join a workspace
+const (
+	WorkspaceInvitePending  = "pending"
+	WorkspaceInviteAccepted = "accepted"
+	WorkspaceInviteDeclined = "declined"
+)
+
+// WorkspaceInvite is created when an admin invites a user to the workspace,
+// the user only joins it once they accept
+type WorkspaceInvite struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Workspace  primitive.ObjectID `json:"workspace" bson:"workspace"`
+	User       primitive.ObjectID `json:"user" bson:"user"`
+	InvitedBy  primitive.ObjectID `json:"invitedBy" bson:"invitedBy"`
+	Status     string             `json:"status" bson:"status"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+	Decided_at time.Time          `json:"decided_at,omitempty" bson:"decided_at,omitempty"`
+}
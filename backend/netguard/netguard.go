@@ -0,0 +1,86 @@
+// Package netguard keeps outgoing requests the server makes on behalf of
+// users, like fetching link previews or posting to push endpoints they
+// registered, from reaching its own network
+package netguard
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrBlocked is returned when connecting to a private, loopback or otherwise
+// internal address, or to a port other than 80 and 443
+var ErrBlocked = errors.New("netguard: address not allowed")
+
+// blockedNetworks are the ranges not covered by the net.IP predicates that
+// still aren't reachable on the public internet
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // this network
+	"100.64.0.0/10",   // carrier grade NAT
+	"192.0.0.0/24",    // protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, broadcast included
+	"64:ff9b::/96",    // NAT64, maps onto IPv4 addresses
+	"2001:db8::/32",   // documentation
+)
+
+// Dialer returns a dialer that only connects to public addresses. They're
+// checked once resolved, right before connecting, so a host name can't
+// resolve to a public address for a check and a private one for the
+// connection
+func Dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Control: Control}
+}
+
+// Control is a dialer's Control hook, it refuses to connect to addresses
+// that aren't public or to ports other than 80 and 443
+func Control(network, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if port != "80" && port != "443" {
+		return ErrBlocked
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !PublicIP(ip) {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// PublicIP reports whether ip is a public unicast address
+func PublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+// maxKeyword bounds the length of a keyword
+const maxKeyword = 50
+
+// ErrInvalidKeywords is returned for keywords that are empty, too long or
+// too many
+var ErrInvalidKeywords = fmt.Errorf("notify: at most %d keywords of at most %d characters", models.MaxKeywords, maxKeyword)
+
+// CleanKeywords returns the keywords trimmed, lowercased and without
+// duplicates, or an error when they can't be alerted of
+func CleanKeywords(keywords []string) ([]string, error) {
+	cleaned := []string{}
+	seen := make(map[string]bool)
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.Join(strings.Fields(keyword), " "))
+		if keyword == "" || utf8.RuneCountInString(keyword) > maxKeyword {
+			return nil, ErrInvalidKeywords
+		}
+		if !seen[keyword] {
+			seen[keyword] = true
+			cleaned = append(cleaned, keyword)
+		}
+	}
+	if len(cleaned) > models.MaxKeywords {
+		return nil, ErrInvalidKeywords
+	}
+	return cleaned, nil
+}
+
+// KeywordPattern returns a case insensitive regular expression matching any
+// of the keywords as whole words. It works with Go and MongoDB alike
+func KeywordPattern(keywords []string) (string, error) {
+	if len(keywords) == 0 {
+		return "", errors.New("notify: no keywords")
+	}
+	quoted := make([]string, len(keywords))
+	for i, keyword := range keywords {
+		quoted[i] = regexp.QuoteMeta(keyword)
+	}
+	return `(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)(?:[^\p{L}\p{N}_]|$)`, nil
+}
+
+// MatchKeyword returns the first of the keywords content contains as a
+// whole word, ignoring case, or "" when it contains none
+func MatchKeyword(content string, keywords []string) string {
+	pattern, err := KeywordPattern(keywords)
+	if err != nil {
+		return ""
+	}
+	match := regexp.MustCompile(pattern).FindStringSubmatch(content)
+	if match == nil {
+		return ""
+	}
+	return strings.ToLower(match[1])
+}
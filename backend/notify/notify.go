@@ -0,0 +1,69 @@
+// Package notify decides how users are notified of messages from their
+// notification settings: mutes, the level of each chat, keyword alerts and
+// do-not-disturb schedules
+package notify
+
+import (
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+// Why a user is notified of a message
+const (
+	ReasonMessage = "message"
+	ReasonMention = "mention"
+	ReasonKeyword = "keyword"
+)
+
+// Decision is how a user is notified of a message. Quiet decisions are
+// within the user's do-not-disturb schedule, they aren't pushed or emailed
+// and clients don't alert for them
+type Decision struct {
+	Notify  bool
+	Reason  string
+	Keyword string // the keyword the message matched, for keyword alerts
+	Quiet   bool
+}
+
+// Decide works out how the user with the global setting, for all chats, and
+// the chat setting is notified at now of a message with content, which
+// mentions them when mentioned. Either setting may be nil
+func Decide(global, chat *models.NotificationSetting, content string, mentioned bool, now time.Time) Decision {
+	if global == nil {
+		global = &models.NotificationSetting{}
+	}
+	if chat == nil {
+		chat = &models.NotificationSetting{}
+	}
+	if global.IsMuted(now) || chat.IsMuted(now) {
+		return Decision{}
+	}
+
+	decision := Decision{Notify: true, Reason: ReasonMessage}
+	keywords := append(append([]string{}, global.Keywords...), chat.Keywords...)
+	switch keyword := MatchKeyword(content, keywords); {
+	case mentioned:
+		decision.Reason = ReasonMention
+	case keyword != "":
+		decision.Reason, decision.Keyword = ReasonKeyword, keyword
+	case Level(global, chat) == models.NotifyMentions:
+		return Decision{}
+	}
+
+	if global.DND != nil {
+		decision.Quiet = !QuietUntil(*global.DND, now).IsZero()
+	}
+	return decision
+}
+
+// Level returns the level the user is notified at in the chat: the level of
+// the chat setting, else the one for all chats, else all messages
+func Level(global, chat *models.NotificationSetting) string {
+	for _, setting := range []*models.NotificationSetting{chat, global} {
+		if setting != nil && setting.Level != "" {
+			return setting.Level
+		}
+	}
+	return models.NotifyAll
+}
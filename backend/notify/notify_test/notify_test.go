@@ -0,0 +1,214 @@
+package notify_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/notify"
+)
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		name      string
+		global    *models.NotificationSetting
+		chat      *models.NotificationSetting
+		content   string
+		mentioned bool
+		want      notify.Decision
+	}{
+		{
+			name:    "without settings",
+			content: "hello",
+			want:    notify.Decision{Notify: true, Reason: notify.ReasonMessage},
+		},
+		{
+			name:      "muted chat",
+			chat:      &models.NotificationSetting{Muted: true},
+			content:   "hello",
+			mentioned: true,
+		},
+		{
+			name:    "muted all chats",
+			global:  &models.NotificationSetting{Muted: true, Muted_until: now.Add(time.Hour)},
+			content: "hello",
+		},
+		{
+			name:    "snooze that ended",
+			chat:    &models.NotificationSetting{Muted: true, Muted_until: now.Add(-time.Minute)},
+			content: "hello",
+			want:    notify.Decision{Notify: true, Reason: notify.ReasonMessage},
+		},
+		{
+			name:    "mentions level without mention",
+			chat:    &models.NotificationSetting{Level: models.NotifyMentions},
+			content: "hello",
+		},
+		{
+			name:      "mentions level with mention",
+			chat:      &models.NotificationSetting{Level: models.NotifyMentions},
+			content:   "hello",
+			mentioned: true,
+			want:      notify.Decision{Notify: true, Reason: notify.ReasonMention},
+		},
+		{
+			name:    "mentions level with keyword of all chats",
+			global:  &models.NotificationSetting{Keywords: []string{"deploy"}},
+			chat:    &models.NotificationSetting{Level: models.NotifyMentions},
+			content: "Starting the Deploy now",
+			want:    notify.Decision{Notify: true, Reason: notify.ReasonKeyword, Keyword: "deploy"},
+		},
+		{
+			name:    "chat level overrides level of all chats",
+			global:  &models.NotificationSetting{Level: models.NotifyMentions},
+			chat:    &models.NotificationSetting{Level: models.NotifyAll},
+			content: "hello",
+			want:    notify.Decision{Notify: true, Reason: notify.ReasonMessage},
+		},
+		{
+			name:    "within do not disturb",
+			global:  &models.NotificationSetting{DND: &models.QuietHours{Start: "09:00", End: "17:00"}},
+			content: "hello",
+			want:    notify.Decision{Notify: true, Reason: notify.ReasonMessage, Quiet: true},
+		},
+		{
+			name:    "do not disturb on other days",
+			global:  &models.NotificationSetting{DND: &models.QuietHours{Start: "09:00", End: "17:00", Days: []int{0, 6}}},
+			content: "hello",
+			want:    notify.Decision{Notify: true, Reason: notify.ReasonMessage},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := notify.Decide(test.global, test.chat, test.content, test.mentioned, now)
+			if got != test.want {
+				t.Errorf("Unexpected result: %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchKeyword(t *testing.T) {
+	keywords := []string{"release", "c++", "on call"}
+
+	tests := map[string]string{
+		"The RELEASE is out":         "release",
+		"who's on call tonight?":     "on call",
+		"I like c++.":                "c++",
+		"releases are weekly":        "",
+		"prerelease build":           "",
+		"nothing to see here":        "",
+		"Größe release-notes fertig": "release",
+	}
+	for content, want := range tests {
+		if got := notify.MatchKeyword(content, keywords); got != want {
+			t.Errorf("Unexpected result for %q: %q, want %q", content, got, want)
+		}
+	}
+
+	if got := notify.MatchKeyword("release", nil); got != "" {
+		t.Errorf("Unexpected result without keywords: %q", got)
+	}
+}
+
+func TestCleanKeywords(t *testing.T) {
+	got, err := notify.CleanKeywords([]string{" Deploy ", "deploy", "On   Call"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []string{"deploy", "on call"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected result: %q, want %q", got, want)
+	}
+
+	if _, err := notify.CleanKeywords([]string{"  "}); err == nil {
+		t.Error("Unexpected result: empty keyword accepted")
+	}
+	tooMany := make([]string, models.MaxKeywords+1)
+	for i := range tooMany {
+		tooMany[i] = string(rune('a' + i))
+	}
+	if _, err := notify.CleanKeywords(tooMany); err == nil {
+		t.Error("Unexpected result: too many keywords accepted")
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	friday := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		quiet models.QuietHours
+		now   time.Time
+		want  time.Time
+	}{
+		{
+			name:  "within quiet hours of the day",
+			quiet: models.QuietHours{Start: "09:00", End: "17:30"},
+			now:   friday.Add(12 * time.Hour),
+			want:  friday.Add(17*time.Hour + 30*time.Minute),
+		},
+		{
+			name:  "after midnight of quiet hours past midnight",
+			quiet: models.QuietHours{Start: "22:00", End: "07:00"},
+			now:   friday.Add(6 * time.Hour),
+			want:  friday.Add(7 * time.Hour),
+		},
+		{
+			name:  "after midnight of quiet hours starting on another day",
+			quiet: models.QuietHours{Start: "22:00", End: "07:00", Days: []int{5}},
+			now:   friday.Add(6 * time.Hour), // they started on Thursday
+		},
+		{
+			name:  "quiet hours starting on the day",
+			quiet: models.QuietHours{Start: "22:00", End: "07:00", Days: []int{5}},
+			now:   friday.Add(23 * time.Hour),
+			want:  friday.Add(31 * time.Hour),
+		},
+		{
+			name:  "outside quiet hours",
+			quiet: models.QuietHours{Start: "22:00", End: "07:00"},
+			now:   friday.Add(12 * time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := notify.QuietUntil(test.quiet, test.now)
+			if !got.Equal(test.want) {
+				t.Errorf("Unexpected result: %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckQuietHours(t *testing.T) {
+	if err := notify.CheckQuietHours(models.QuietHours{Start: "22:00", End: "07:00", Timezone: "Asia/Tokyo", Days: []int{1, 2}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, quiet := range []models.QuietHours{
+		{Start: "22:00"},
+		{Start: "7", End: "08:00"},
+		{Start: "08:00", End: "08:00"},
+		{Start: "22:00", End: "07:00", Timezone: "Nowhere/Land"},
+		{Start: "22:00", End: "07:00", Days: []int{7}},
+	} {
+		if err := notify.CheckQuietHours(quiet); err == nil {
+			t.Errorf("Unexpected result: %+v accepted", quiet)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	if minutes, err := notify.ParseClock("07:45"); err != nil || minutes != 465 {
+		t.Errorf("Unexpected result: %d, %v", minutes, err)
+	}
+	for _, s := range []string{"", "7", "24:00", "12:60", "noon"} {
+		if _, err := notify.ParseClock(s); err == nil {
+			t.Errorf("Unexpected result: %q parsed", s)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"errors"
+	"time"
+
+	// the timezones of users are needed even where the system has none
+	_ "time/tzdata"
+
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+// ErrInvalidClock is returned for times of day that aren't "HH:MM"
+var ErrInvalidClock = errors.New("notify: time must be HH:MM")
+
+// ParseClock returns the minutes after midnight of s, a "HH:MM" time
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, ErrInvalidClock
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Location returns the timezone named name, UTC for unknown names
+func Location(name string) *time.Location {
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// CheckQuietHours returns an error when q isn't a period quiet hours can be
+// kept on
+func CheckQuietHours(q models.QuietHours) error {
+	start, err := ParseClock(q.Start)
+	if err != nil {
+		return err
+	}
+	end, err := ParseClock(q.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return errors.New("notify: quiet hours must end at another time than they start")
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		return errors.New("notify: unknown timezone")
+	}
+	for _, day := range q.Days {
+		if day < 0 || day > 6 {
+			return errors.New("notify: days must be 0 (Sunday) to 6")
+		}
+	}
+	return nil
+}
+
+// QuietUntil returns when the quiet hours end if now is within them, and
+// the zero time otherwise
+func QuietUntil(q models.QuietHours, now time.Time) time.Time {
+	start, err := ParseClock(q.Start)
+	if err != nil {
+		return time.Time{}
+	}
+	end, err := ParseClock(q.End)
+	if err != nil || start == end {
+		return time.Time{}
+	}
+
+	local := now.In(Location(q.Timezone))
+	minute := local.Hour()*60 + local.Minute()
+	startDay, endDay := local, local
+
+	switch {
+	case start < end && start <= minute && minute < end:
+	case start > end && minute >= start:
+		endDay = local.AddDate(0, 0, 1)
+	case start > end && minute < end:
+		startDay = local.AddDate(0, 0, -1)
+	default:
+		return time.Time{}
+	}
+	if !onDay(q.Days, startDay.Weekday()) {
+		return time.Time{}
+	}
+	return time.Date(endDay.Year(), endDay.Month(), endDay.Day(), end/60, end%60, 0, 0, local.Location())
+}
+
+// onDay reports whether quiet hours on days are kept on day, they're kept
+// every day without days
+func onDay(days []int, day time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, d := range days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
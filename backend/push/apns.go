@@ -0,0 +1,147 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+// Hosts of the APNs provider API
+const (
+	APNsProduction = "https://api.push.apple.com"
+	APNsSandbox    = "https://api.sandbox.push.apple.com"
+)
+
+// apnsTokenLifetime is how long a provider token is reused, APNs rejects
+// tokens older than an hour and ones renewed more often than every 20 minutes
+const apnsTokenLifetime = 40 * time.Minute
+
+// APNs sends notifications to Apple devices with token based authentication
+type APNs struct {
+	Client   *http.Client
+	Endpoint string // APNsProduction or APNsSandbox
+
+	key    *ecdsa.PrivateKey
+	keyId  string
+	teamId string
+	topic  string // bundle id of the app
+
+	mu        sync.Mutex
+	token     string
+	issued_at time.Time
+}
+
+// NewAPNs returns a provider signing with the .p8 key of keyId from the
+// developer team, for the app with the bundle id topic
+func NewAPNs(keyPEM []byte, keyId, teamId, topic string) (*APNs, error) {
+	if keyId == "" || teamId == "" || topic == "" {
+		return nil, errors.New("push: APNs needs a key id, team id and topic")
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &APNs{
+		Client:   &http.Client{Timeout: 15 * time.Second},
+		Endpoint: APNsProduction,
+		key:      key,
+		keyId:    keyId,
+		teamId:   teamId,
+		topic:    topic,
+	}, nil
+}
+
+// Send sends the notification as an alert to the device token
+func (a *APNs) Send(ctx context.Context, device models.Device, n Notification) error {
+	token, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": n.Title, "body": n.Body},
+			"sound": "default",
+		},
+	}
+	for key, value := range n.Data {
+		if key != "aps" {
+			payload[key] = value
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint+"/3/device/"+url.PathEscape(device.Token), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	req.Header.Set("apns-expiration", strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10))
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+
+	switch {
+	case resp.StatusCode == http.StatusGone, result.Reason == "BadDeviceToken", result.Reason == "Unregistered":
+		return ErrInvalidToken
+	case result.Reason == "ExpiredProviderToken":
+		a.mu.Lock()
+		a.token = ""
+		a.mu.Unlock()
+	}
+	return fmt.Errorf("push: APNs returned %s %s", resp.Status, result.Reason)
+}
+
+// providerToken returns the signed token authenticating the team, reusing
+// it for apnsTokenLifetime
+func (a *APNs) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Since(a.issued_at) < apnsTokenLifetime {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.teamId,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.keyId
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.issued_at = signed, now
+	return signed, nil
+}
@@ -0,0 +1,180 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+// fcmScope is the OAuth scope access tokens for sending messages need
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCM sends notifications to Android and other Firebase apps with the FCM
+// HTTP v1 API, authenticating as a service account
+type FCM struct {
+	Client   *http.Client
+	Endpoint string // messages:send url of the project
+
+	account serviceAccount
+	key     *rsa.PrivateKey
+
+	mu          sync.Mutex
+	accessToken string
+	expires_at  time.Time
+}
+
+// serviceAccount holds the fields of a service account key file FCM needs
+type serviceAccount struct {
+	ProjectId   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// NewFCM returns a provider for the project of the service account key file
+func NewFCM(credentials []byte) (*FCM, error) {
+	var account serviceAccount
+	if err := json.Unmarshal(credentials, &account); err != nil {
+		return nil, err
+	}
+	if account.ProjectId == "" || account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, errors.New("push: FCM credentials need project_id, client_email and token_uri")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return &FCM{
+		Client:   &http.Client{Timeout: 15 * time.Second},
+		Endpoint: "https://fcm.googleapis.com/v1/projects/" + url.PathEscape(account.ProjectId) + "/messages:send",
+		account:  account,
+		key:      key,
+	}, nil
+}
+
+// Send sends the notification to the registration token of the device
+func (f *FCM) Send(ctx context.Context, device models.Device, n Notification) error {
+	accessToken, err := f.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	message := map[string]interface{}{
+		"token":        device.Token,
+		"notification": map[string]string{"title": n.Title, "body": n.Body},
+	}
+	if len(n.Data) > 0 {
+		message["data"] = n.Data
+	}
+	body, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result)
+
+	switch {
+	case result.Error.Status == "UNREGISTERED" || resp.StatusCode == http.StatusNotFound:
+		return ErrInvalidToken
+	case result.Error.Status == "INVALID_ARGUMENT" && strings.Contains(result.Error.Message, "registration token"):
+		return ErrInvalidToken
+	case resp.StatusCode == http.StatusUnauthorized:
+		// the access token was revoked early, get a new one next time
+		f.mu.Lock()
+		f.accessToken = ""
+		f.mu.Unlock()
+	}
+	return fmt.Errorf("push: FCM returned %s %s", resp.Status, result.Error.Status)
+}
+
+// token returns an access token of the service account, exchanging a
+// signed assertion for a new one when the last is about to expire
+func (f *FCM) token(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.accessToken != "" && time.Now().Before(f.expires_at) {
+		return f.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   f.account.ClientEmail,
+		"scope": fcmScope,
+		"aud":   f.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(f.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("push: FCM token exchange returned %s", resp.Status)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("push: FCM token exchange returned no token")
+	}
+
+	// renewed a minute early so it doesn't expire on the way
+	f.accessToken = result.AccessToken
+	f.expires_at = now.Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return f.accessToken, nil
+}
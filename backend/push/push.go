@@ -0,0 +1,98 @@
+// Package push delivers notifications to the devices users registered,
+// through a provider per platform: Web Push for browsers, FCM for Android
+// and APNs for Apple devices. Providers are only set up when configured
+package push
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+var (
+	// ErrInvalidToken is returned when the provider no longer accepts the
+	// device, because the app was uninstalled or the subscription expired.
+	// The device should be forgotten
+	ErrInvalidToken = errors.New("push: device is no longer registered")
+	// ErrNoProvider is returned for devices of a platform that isn't set up
+	ErrNoProvider = errors.New("push: no provider for the platform")
+)
+
+// Notification is what's shown on a device. Data is passed to the app along
+// with it, such as which chat and message it's about
+type Notification struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// Provider sends notifications to devices of one platform
+type Provider interface {
+	Send(ctx context.Context, device models.Device, n Notification) error
+}
+
+// Providers holds the provider of each platform that's set up and is
+// accessable to other files
+var Providers = map[string]Provider{}
+
+// Init sets up the providers whose env variables are set. Web Push needs
+// VAPID_PRIVATE_KEY and VAPID_SUBJECT, FCM needs FCM_CREDENTIALS pointing to
+// a service account key file, and APNs needs APNS_KEY_FILE, APNS_KEY_ID,
+// APNS_TEAM_ID and APNS_TOPIC, with APNS_SANDBOX to use the sandbox
+func Init() {
+	if key := os.Getenv("VAPID_PRIVATE_KEY"); key != "" {
+		provider, err := NewWebPush(key, os.Getenv("VAPID_SUBJECT"))
+		if err != nil {
+			log.Fatal("Error setting up web push ", err)
+		}
+		Providers[models.PlatformWebPush] = provider
+		log.Println("using web push notifications")
+	}
+
+	if file := os.Getenv("FCM_CREDENTIALS"); file != "" {
+		credentials, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal("Error reading FCM credentials ", err)
+		}
+		provider, err := NewFCM(credentials)
+		if err != nil {
+			log.Fatal("Error setting up FCM ", err)
+		}
+		Providers[models.PlatformFCM] = provider
+		log.Println("using FCM push notifications")
+	}
+
+	if file := os.Getenv("APNS_KEY_FILE"); file != "" {
+		key, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal("Error reading APNs key ", err)
+		}
+		provider, err := NewAPNs(key, os.Getenv("APNS_KEY_ID"), os.Getenv("APNS_TEAM_ID"), os.Getenv("APNS_TOPIC"))
+		if err != nil {
+			log.Fatal("Error setting up APNs ", err)
+		}
+		if os.Getenv("APNS_SANDBOX") == "true" {
+			provider.Endpoint = APNsSandbox
+		}
+		Providers[models.PlatformAPNs] = provider
+		log.Println("using APNs push notifications")
+	}
+}
+
+// Send delivers n to the device through the provider of its platform
+func Send(ctx context.Context, device models.Device, n Notification) error {
+	provider, ok := Providers[device.Platform]
+	if !ok {
+		return ErrNoProvider
+	}
+	return provider.Send(ctx, device, n)
+}
+
+// Enabled reports whether notifications can be sent to devices of platform
+func Enabled(platform string) bool {
+	_, ok := Providers[platform]
+	return ok
+}
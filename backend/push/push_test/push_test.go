@@ -0,0 +1,310 @@
+package push_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/netguard"
+	"github.com/pmohanj/web-chat-app/push"
+	"golang.org/x/crypto/hkdf"
+)
+
+var notification = push.Notification{
+	Title: "alice",
+	Body:  "hello there",
+	Data:  map[string]string{"chatId": "abc"},
+}
+
+// subscription is the browser side of a web push subscription
+type subscription struct {
+	key  *ecdsa.PrivateKey
+	auth []byte
+}
+
+func newSubscription(t *testing.T) subscription {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return subscription{key: key, auth: auth}
+}
+
+func (s subscription) keys() *models.WebPushKeys {
+	return &models.WebPushKeys{
+		P256dh: base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), s.key.X, s.key.Y)),
+		Auth:   base64.RawURLEncoding.EncodeToString(s.auth),
+	}
+}
+
+// decrypt reverses the aes128gcm encoding of a web push payload the way a
+// browser does
+func (s subscription) decrypt(t *testing.T, body []byte) []byte {
+	salt, rs, idlen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	if rs != 4096 {
+		t.Errorf("Unexpected record size: got %v, want %v", rs, 4096)
+	}
+	asPublic, ciphertext := body[21:21+idlen], body[21+idlen:]
+
+	curve := elliptic.P256()
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	sharedX, _ := curve.ScalarMult(asX, asY, s.key.D.Bytes())
+	shared := make([]byte, 32)
+	sharedX.FillBytes(shared)
+
+	uaPublic := elliptic.Marshal(curve, s.key.X, s.key.Y)
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, shared, s.auth, keyInfo), ikm)
+	cek := make([]byte, 16)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	nonce := make([]byte, 12)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error decrypting payload: %v", err)
+	}
+	if plain[len(plain)-1] != 0x02 {
+		t.Fatalf("Unexpected result: the payload isn't a last record")
+	}
+	return plain[:len(plain)-1]
+}
+
+func TestWebPush(t *testing.T) {
+	vapidKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	provider, err := push.NewWebPush(base64.RawURLEncoding.EncodeToString(vapidKey.D.FillBytes(make([]byte, 32))), "mailto:ops@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), vapidKey.X, vapidKey.Y)); provider.PublicKey() != want {
+		t.Errorf("Unexpected public key: got %v, want %v", provider.PublicKey(), want)
+	}
+
+	sub := newSubscription(t)
+	var received push.Notification
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+			t.Errorf("Unexpected headers: %v", r.Header)
+		}
+
+		// the token must be signed by the key the header carries, for the
+		// origin of the endpoint
+		var token, key string
+		for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "vapid "), ", ") {
+			if strings.HasPrefix(part, "t=") {
+				token = part[2:]
+			} else if strings.HasPrefix(part, "k=") {
+				key = part[2:]
+			}
+		}
+		if key != provider.PublicKey() {
+			t.Errorf("Unexpected vapid key: got %v, want %v", key, provider.PublicKey())
+		}
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return &vapidKey.PublicKey, nil }); err != nil {
+			t.Errorf("Unexpected error verifying vapid token: %v", err)
+		}
+		if claims["aud"] != "https://"+r.Host || claims["sub"] != "mailto:ops@example.com" {
+			t.Errorf("Unexpected claims: %v", claims)
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(sub.decrypt(t, body), &received)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	provider.Client = server.Client()
+
+	device := models.Device{Platform: models.PlatformWebPush, Token: server.URL + "/push/1", Keys: sub.keys()}
+	if err := provider.Send(context.Background(), device, notification); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if received.Title != notification.Title || received.Body != notification.Body || received.Data["chatId"] != "abc" {
+		t.Errorf("Unexpected result: got %+v, want %+v", received, notification)
+	}
+
+	device.Token = server.URL + "/gone"
+	if err := provider.Send(context.Background(), device, notification); !errors.Is(err, push.ErrInvalidToken) {
+		t.Errorf("Unexpected error: got %v, want %v", err, push.ErrInvalidToken)
+	}
+}
+
+func TestWebPushBlocksPrivateEndpoints(t *testing.T) {
+	vapidKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	provider, _ := push.NewWebPush(base64.RawURLEncoding.EncodeToString(vapidKey.D.FillBytes(make([]byte, 32))), "mailto:ops@example.com")
+
+	var hits int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	device := models.Device{Platform: models.PlatformWebPush, Token: server.URL + "/push/1", Keys: newSubscription(t).keys()}
+	if err := provider.Send(context.Background(), device, notification); !errors.Is(err, netguard.ErrBlocked) {
+		t.Errorf("Unexpected error: got %v, want %v", err, netguard.ErrBlocked)
+	}
+	if got := atomic.LoadInt32(&hits); got != 0 {
+		t.Errorf("Unexpected result: the server got %v requests, want %v", got, 0)
+	}
+}
+
+func TestNewWebPush(t *testing.T) {
+	if _, err := push.NewWebPush("short", "mailto:ops@example.com"); err == nil {
+		t.Errorf("Unexpected result: got no error for an invalid key")
+	}
+	key := base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	if _, err := push.NewWebPush(key, "ops@example.com"); err == nil {
+		t.Errorf("Unexpected result: got no error for an invalid subject")
+	}
+}
+
+func TestFCM(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	var exchanges int32
+	var received map[string]map[string]interface{}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&exchanges, 1)
+		r.ParseForm()
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(r.Form.Get("assertion"), claims, func(*jwt.Token) (interface{}, error) { return &rsaKey.PublicKey, nil }); err != nil {
+			t.Errorf("Unexpected error verifying assertion: %v", err)
+		}
+		if claims["iss"] != "svc@project.iam.gserviceaccount.com" || claims["aud"] != server.URL+"/token" {
+			t.Errorf("Unexpected claims: %v", claims)
+		}
+		w.Write([]byte(`{"access_token":"access-1","expires_in":3600,"token_type":"Bearer"}`))
+	})
+	mux.HandleFunc("/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-1" {
+			t.Errorf("Unexpected authorization: %v", r.Header.Get("Authorization"))
+		}
+		received = nil
+		json.NewDecoder(r.Body).Decode(&received)
+		if received["message"]["token"] == "stale" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`))
+		}
+	})
+
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "project",
+		"client_email": "svc@project.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    server.URL + "/token",
+	})
+	provider, err := push.NewFCM(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.Endpoint != "https://fcm.googleapis.com/v1/projects/project/messages:send" {
+		t.Errorf("Unexpected endpoint: %v", provider.Endpoint)
+	}
+	provider.Endpoint = server.URL + "/send"
+
+	device := models.Device{Platform: models.PlatformFCM, Token: "device-1"}
+	for i := 0; i < 2; i++ {
+		if err := provider.Send(context.Background(), device, notification); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&exchanges); got != 1 {
+		t.Errorf("Unexpected result: got %v token exchanges, want %v", got, 1)
+	}
+	if received["message"]["token"] != "device-1" {
+		t.Errorf("Unexpected message: %v", received)
+	}
+
+	device.Token = "stale"
+	if err := provider.Send(context.Background(), device, notification); !errors.Is(err, push.ErrInvalidToken) {
+		t.Errorf("Unexpected error: got %v, want %v", err, push.ErrInvalidToken)
+	}
+}
+
+func TestAPNs(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	var received map[string]interface{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/3/device/stale" {
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason":"Unregistered"}`))
+			return
+		}
+		if r.URL.Path != "/3/device/device-1" || r.Header.Get("apns-topic") != "com.example.chat" || r.Header.Get("apns-push-type") != "alert" {
+			t.Errorf("Unexpected request: %v %v", r.URL.Path, r.Header)
+		}
+		token, err := jwt.Parse(strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "), func(*jwt.Token) (interface{}, error) { return &ecKey.PublicKey, nil })
+		if err != nil {
+			t.Errorf("Unexpected error verifying provider token: %v", err)
+		} else if token.Header["kid"] != "KEY123" || token.Claims.(jwt.MapClaims)["iss"] != "TEAM123" {
+			t.Errorf("Unexpected provider token: %v %v", token.Header, token.Claims)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	provider, err := push.NewAPNs(keyPEM, "KEY123", "TEAM123", "com.example.chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Client = server.Client()
+	provider.Endpoint = server.URL
+
+	device := models.Device{Platform: models.PlatformAPNs, Token: "device-1"}
+	if err := provider.Send(context.Background(), device, notification); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	alert := received["aps"].(map[string]interface{})["alert"].(map[string]interface{})
+	if alert["title"] != "alice" || alert["body"] != "hello there" || received["chatId"] != "abc" {
+		t.Errorf("Unexpected payload: %v", received)
+	}
+
+	device.Token = "stale"
+	if err := provider.Send(context.Background(), device, notification); !errors.Is(err, push.ErrInvalidToken) {
+		t.Errorf("Unexpected error: got %v, want %v", err, push.ErrInvalidToken)
+	}
+}
+
+func TestSendWithoutProvider(t *testing.T) {
+	device := models.Device{Platform: models.PlatformAPNs, Token: "device-1"}
+	if err := push.Send(context.Background(), device, notification); !errors.Is(err, push.ErrNoProvider) {
+		t.Errorf("Unexpected error: got %v, want %v", err, push.ErrNoProvider)
+	}
+}
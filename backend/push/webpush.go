@@ -0,0 +1,246 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/netguard"
+	"golang.org/x/crypto/hkdf"
+)
+
+// recordSize is the record size declared in the header of encrypted
+// payloads, payloads always fit in one record
+const recordSize = 4096
+
+// maxPayload bounds the payload of a notification before encryption, push
+// services accept about 4KB once encrypted
+const maxPayload = 3000
+
+// WebPush sends notifications to browser subscriptions, encrypting the
+// payload for the subscription (RFC 8291) and identifying the server with
+// its VAPID key (RFC 8292)
+type WebPush struct {
+	Client *http.Client
+	TTL    time.Duration // how long the push service keeps undelivered notifications
+
+	key     *ecdsa.PrivateKey
+	subject string
+}
+
+// NewWebPush returns a provider signing with the VAPID private key, the
+// base64url encoded P-256 scalar, and contact subject, a mailto: or https:
+// url push services can reach the operator at
+func NewWebPush(privateKey, subject string) (*WebPush, error) {
+	d, err := decodeBase64(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("push: VAPID private key must be 32 base64url encoded bytes")
+	}
+	if !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https://") {
+		return nil, errors.New("push: VAPID subject must be a mailto: or https: url")
+	}
+
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d)
+
+	// subscriptions can point anywhere, so only public addresses are posted to
+	transport := &http.Transport{
+		Proxy:               nil,
+		DialContext:         netguard.Dialer(10 * time.Second).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+	return &WebPush{
+		Client:  &http.Client{Transport: transport, Timeout: 15 * time.Second},
+		TTL:     24 * time.Hour,
+		key:     key,
+		subject: subject,
+	}, nil
+}
+
+// PublicKey returns the VAPID public key, base64url encoded, which browsers
+// subscribe with
+func (w *WebPush) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(w.key.Curve, w.key.X, w.key.Y))
+}
+
+// Send posts the encrypted notification to the endpoint of the subscription
+func (w *WebPush) Send(ctx context.Context, device models.Device, n Notification) error {
+	if device.Keys == nil {
+		return ErrInvalidToken
+	}
+	endpoint, err := url.Parse(device.Token)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return ErrInvalidToken
+	}
+
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxPayload {
+		return fmt.Errorf("push: payload of %d bytes is too large", len(payload))
+	}
+	body, err := encrypt(payload, device.Keys)
+	if err != nil {
+		return ErrInvalidToken // the keys the browser gave are unusable
+	}
+
+	token, err := w.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(w.TTL.Seconds())))
+	req.Header.Set("Urgency", "high")
+	req.Header.Set("Authorization", "vapid t="+token+", k="+w.PublicKey())
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrInvalidToken
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push: web push service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// vapidToken signs the claims identifying the server to the push service at
+// audience, the origin of the endpoint
+func (w *WebPush) vapidToken(audience string) (string, error) {
+	claims := jwt.MapClaims{
+		"aud": audience,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": w.subject,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(w.key)
+}
+
+// encrypt encrypts payload for the subscription keys with the aes128gcm
+// content encoding, as a single record with an ephemeral key
+func encrypt(payload []byte, keys *models.WebPushKeys) ([]byte, error) {
+	curve := elliptic.P256()
+
+	uaPublic, err := decodeBase64(keys.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("push: invalid p256dh key")
+	}
+	authSecret, err := decodeBase64(keys.Auth)
+	if err != nil || len(authSecret) < 16 {
+		return nil, errors.New("push: invalid auth secret")
+	}
+
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, asX, asY)
+
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	sharedSecret := make([]byte, 32)
+	sharedX.FillBytes(sharedSecret)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, authSecret, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// the header is the salt, record size and the key the payload was
+	// encrypted with, the 0x02 delimiter marks the last record
+	header := make([]byte, 16+4+1, 16+4+1+len(asPublic))
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[16:], recordSize)
+	header[20] = byte(len(asPublic))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, append(payload, 0x02), nil), nil
+}
+
+// decodeBase64 decodes base64url with or without padding, which is how
+// browsers and key generators hand out keys
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// CheckSubscription reports whether endpoint and keys make up a web push
+// subscription notifications can be encrypted for and sent to
+func CheckSubscription(endpoint string, keys *models.WebPushKeys) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return errors.New("push: endpoint must be an https url")
+	}
+	if keys == nil {
+		return errors.New("push: subscription keys are missing")
+	}
+	public, err := decodeBase64(keys.P256dh)
+	if err != nil {
+		return errors.New("push: invalid p256dh key")
+	}
+	if x, _ := elliptic.Unmarshal(elliptic.P256(), public); x == nil {
+		return errors.New("push: invalid p256dh key")
+	}
+	if auth, err := decodeBase64(keys.Auth); err != nil || len(auth) != 16 {
+		return errors.New("push: invalid auth secret")
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/controllers"
+	"github.com/pmohanj/web-chat-app/middleware"
+)
+
+func AddAdminRoutes(r *gin.RouterGroup) {
+	admin := r.Group("/admin")
+	admin.GET("/users", middleware.Authenticate(), middleware.RequireAdmin(), controllers.AdminGetUsers())
+	admin.PUT("/users/:userId/suspend", middleware.Authenticate(), middleware.RequireAdmin(), controllers.AdminSuspendUser())
+	admin.PUT("/users/:userId/unsuspend", middleware.Authenticate(), middleware.RequireAdmin(), controllers.AdminUnsuspendUser())
+	admin.POST("/users/:userId/reset", middleware.Authenticate(), middleware.RequireAdmin(), controllers.AdminForcePasswordReset())
+	admin.DELETE("/chats/:chatId", middleware.Authenticate(), middleware.RequireAdmin(), controllers.AdminDeleteChat())
+	admin.GET("/stats", middleware.Authenticate(), middleware.RequireAdmin(), controllers.AdminGetStats())
+	admin.GET("/audit", middleware.Authenticate(), middleware.RequireAdmin(), controllers.GetAuditLog())
+	admin.GET("/audit/export", middleware.Authenticate(), middleware.RequireAdmin(), controllers.ExportAuditLog())
+}
@@ -11,9 +11,32 @@ func AddChatRoutes(r *gin.RouterGroup) {
 	chat.POST("/", middleware.Authenticate(), controllers.AddChatUser())
 	chat.GET("/", middleware.Authenticate(), controllers.GetUserChats())
 	chat.DELETE("/:chatId", middleware.Authenticate(), controllers.DeleteUserConversation())
+	chat.POST("/:chatId/restore", middleware.Authenticate(), controllers.RestoreConversation())
 	chat.POST("/group", middleware.Authenticate(), controllers.CreateGroupChat())
 	chat.PUT("/grouprename", middleware.Authenticate(), controllers.RenameGroupChatName())
 	chat.PUT("/groupadd", middleware.Authenticate(), controllers.AddUserToGroupChat())
 	chat.PUT("/groupremove", middleware.Authenticate(), controllers.DeleteUserFromGroupChat())
 	chat.PUT("/groupexit", middleware.Authenticate(), controllers.UserExitGroup())
+	chat.PUT("/grouprole", middleware.Authenticate(), controllers.ChangeGroupRole())
+	chat.PUT("/groupowner", middleware.Authenticate(), controllers.TransferGroupOwnership())
+	chat.PUT("/groupinfo", middleware.Authenticate(), controllers.UpdateGroupInfo())
+	chat.GET("/channels", middleware.Authenticate(), controllers.GetChannels())
+	chat.POST("/channels/:chatId/join", middleware.Authenticate(), controllers.JoinChannel())
+	chat.POST("/channels/:chatId/leave", middleware.Authenticate(), controllers.LeaveChannel())
+	chat.POST("/:chatId/invites", middleware.Authenticate(), controllers.CreateInvite())
+	chat.GET("/:chatId/invites", middleware.Authenticate(), controllers.GetGroupInvites())
+	chat.DELETE("/:chatId/invites/:inviteId", middleware.Authenticate(), controllers.RevokeInvite())
+	chat.GET("/:chatId/filters", middleware.Authenticate(), controllers.GetChatFilters())
+	chat.PUT("/:chatId/filters", middleware.Authenticate(), controllers.UpdateChatFilters())
+	chat.GET("/:chatId/requests", middleware.Authenticate(), controllers.GetJoinRequests())
+	chat.GET("/:chatId/pins", middleware.Authenticate(), controllers.GetPinnedMessages())
+	chat.POST("/:chatId/pins", middleware.Authenticate(), controllers.PinMessage())
+	chat.DELETE("/:chatId/pins/:messageId", middleware.Authenticate(), controllers.UnpinMessage())
+	chat.GET("/:chatId/notifications", middleware.Authenticate(), controllers.GetChatNotificationSettings())
+	chat.PUT("/:chatId/notifications", middleware.Authenticate(), controllers.UpdateChatNotificationSettings())
+	chat.POST("/:chatId/webhooks", middleware.Authenticate(), controllers.CreateChatWebhook())
+	chat.GET("/:chatId/webhooks", middleware.Authenticate(), controllers.GetChatWebhooks())
+	chat.PUT("/:chatId/requests/:requestId", middleware.Authenticate(), controllers.DecideJoinRequest())
+	chat.GET("/invite/:token", middleware.Authenticate(), controllers.GetInvite())
+	chat.POST("/invite/:token", middleware.Authenticate(), controllers.JoinByInvite())
 }
@@ -10,7 +10,20 @@ func AddMessageRoutes(router *gin.RouterGroup) {
 	messageRouter := router.Group("/message")
 
 	messageRouter.POST("/", middleware.Authenticate(), controllers.SendMessage())
+	messageRouter.GET("/search", middleware.Authenticate(), controllers.SearchMessages())
+	messageRouter.GET("/scheduled", middleware.Authenticate(), controllers.GetScheduled())
+	messageRouter.GET("/mentions", middleware.Authenticate(), controllers.GetMentions())
+	messageRouter.POST("/scheduled", middleware.Authenticate(), controllers.ScheduleMessage())
+	messageRouter.PUT("/scheduled/:scheduledId", middleware.Authenticate(), controllers.EditScheduled())
+	messageRouter.DELETE("/scheduled/:scheduledId", middleware.Authenticate(), controllers.CancelScheduled())
 	messageRouter.GET("/:chatId", middleware.Authenticate(), controllers.GetMessages())
 	messageRouter.PUT("/", middleware.Authenticate(), controllers.EditUserMessage())
 	messageRouter.DELETE("/:messageId", middleware.Authenticate(), controllers.DeleteUserMessage())
+	messageRouter.POST("/:messageId/restore", middleware.Authenticate(), controllers.RestoreMessage())
+	messageRouter.POST("/:messageId/reminder", middleware.Authenticate(), controllers.AddReminder())
+	messageRouter.GET("/history/:messageId", middleware.Authenticate(), controllers.GetMessageHistory())
+	messageRouter.POST("/poll", middleware.Authenticate(), controllers.CreatePoll())
+	messageRouter.GET("/poll/:messageId", middleware.Authenticate(), controllers.GetPoll())
+	messageRouter.PUT("/poll/:messageId/vote", middleware.Authenticate(), controllers.VotePoll())
+	messageRouter.PUT("/poll/:messageId/close", middleware.Authenticate(), controllers.ClosePoll())
 }
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/controllers"
+	"github.com/pmohanj/web-chat-app/middleware"
+)
+
+func AddModerationRoutes(r *gin.RouterGroup) {
+	r.POST("/report", middleware.Authenticate(), controllers.ReportContent())
+
+	moderation := r.Group("/moderation")
+	moderation.GET("/reports", middleware.Authenticate(), middleware.RequireAdmin(), controllers.GetReports())
+	moderation.PUT("/reports/:reportId", middleware.Authenticate(), middleware.RequireAdmin(), controllers.ModerateReport())
+	moderation.GET("/log", middleware.Authenticate(), middleware.RequireAdmin(), controllers.GetModerationLog())
+}
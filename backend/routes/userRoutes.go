@@ -12,4 +12,27 @@ func AddUserRoutes(router *gin.RouterGroup) {
 	userRouter.GET("/search", middleware.Authenticate(), controllers.SearchUsers())
 	userRouter.POST("/", controllers.RegisterUser())
 	userRouter.POST("/login", controllers.AuthUser())
+	userRouter.POST("/password/reset", controllers.ResetPassword())
+	userRouter.POST("/avatar", middleware.Authenticate(), controllers.UploadAvatar())
+	userRouter.DELETE("/avatar", middleware.Authenticate(), controllers.DeleteAvatar())
+	userRouter.GET("/avatar/:userId", controllers.GetAvatar())
+	userRouter.GET("/blocks", middleware.Authenticate(), controllers.GetBlockedUsers())
+	userRouter.POST("/blocks", middleware.Authenticate(), controllers.BlockUser())
+	userRouter.DELETE("/blocks/:userId", middleware.Authenticate(), controllers.UnblockUser())
+	userRouter.GET("/privacy", middleware.Authenticate(), controllers.GetPrivacySettings())
+	userRouter.PUT("/privacy", middleware.Authenticate(), controllers.UpdatePrivacySettings())
+	userRouter.GET("/warnings", middleware.Authenticate(), controllers.GetUserWarnings())
+	userRouter.GET("/bookmarks", middleware.Authenticate(), controllers.GetBookmarks())
+	userRouter.POST("/bookmarks", middleware.Authenticate(), controllers.AddBookmark())
+	userRouter.DELETE("/bookmarks/:messageId", middleware.Authenticate(), controllers.RemoveBookmark())
+	userRouter.GET("/push", middleware.Authenticate(), controllers.GetPushConfig())
+	userRouter.GET("/devices", middleware.Authenticate(), controllers.GetDevices())
+	userRouter.POST("/devices", middleware.Authenticate(), controllers.RegisterDevice())
+	userRouter.DELETE("/devices/:deviceId", middleware.Authenticate(), controllers.DeleteDevice())
+	userRouter.GET("/notifications", middleware.Authenticate(), controllers.GetNotificationSettings())
+	userRouter.PUT("/notifications", middleware.Authenticate(), controllers.UpdateNotificationSettings())
+	userRouter.GET("/digest", middleware.Authenticate(), controllers.GetDigestSettings())
+	userRouter.PUT("/digest", middleware.Authenticate(), controllers.UpdateDigestSettings())
+	userRouter.GET("/digest/unsubscribe", controllers.UnsubscribeDigest())
+	userRouter.POST("/digest/unsubscribe", controllers.UnsubscribeDigest())
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/controllers"
+	"github.com/pmohanj/web-chat-app/middleware"
+)
+
+func AddWebhookRoutes(r *gin.RouterGroup) {
+	webhook := r.Group("/webhook")
+	webhook.PUT("/:webhookId", middleware.Authenticate(), controllers.UpdateWebhook())
+	webhook.DELETE("/:webhookId", middleware.Authenticate(), controllers.DeleteWebhook())
+	webhook.POST("/:webhookId/ping", middleware.Authenticate(), controllers.PingWebhook())
+	webhook.GET("/:webhookId/deliveries", middleware.Authenticate(), controllers.GetWebhookDeliveries())
+}
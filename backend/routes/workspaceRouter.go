@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pmohanj/web-chat-app/controllers"
+	"github.com/pmohanj/web-chat-app/middleware"
+)
+
+func AddWorkspaceRoutes(r *gin.RouterGroup) {
+	workspace := r.Group("/workspace")
+	workspace.POST("/", middleware.Authenticate(), controllers.CreateWorkspace())
+	workspace.GET("/", middleware.Authenticate(), controllers.GetUserWorkspaces())
+	workspace.GET("/invites", middleware.Authenticate(), controllers.GetWorkspaceInvites())
+	workspace.POST("/invites/:inviteId", middleware.Authenticate(), controllers.DecideWorkspaceInvite())
+	workspace.POST("/:workspaceId/members", middleware.Authenticate(), controllers.InviteWorkspaceMember())
+	workspace.DELETE("/:workspaceId/members/:userId", middleware.Authenticate(), controllers.RemoveWorkspaceMember())
+	workspace.GET("/:workspaceId/filters", middleware.Authenticate(), controllers.GetWorkspaceFilters())
+	workspace.PUT("/:workspaceId/filters", middleware.Authenticate(), controllers.UpdateWorkspaceFilters())
+	workspace.POST("/:workspaceId/webhooks", middleware.Authenticate(), controllers.CreateWorkspaceWebhook())
+	workspace.GET("/:workspaceId/webhooks", middleware.Authenticate(), controllers.GetWorkspaceWebhooks())
+	workspace.POST("/:workspaceId/switch", middleware.Authenticate(), controllers.SwitchWorkspace())
+}
@@ -0,0 +1,65 @@
+package search
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Hit is a message matching a search, Score orders hits by relevance
+type Hit struct {
+	MessageId primitive.ObjectID
+	Score     float64
+}
+
+// Result is one page of hits along with the total number of matches
+type Result struct {
+	Hits  []Hit
+	Total int64
+}
+
+// Index finds messages matching a Query. The handlers that change messages
+// keep it up to date through Add and Remove
+type Index interface {
+	Search(ctx context.Context, q Query) (Result, error)
+	Add(msg models.Message)
+	Remove(messageId primitive.ObjectID)
+	RemoveChat(chatId primitive.ObjectID)
+}
+
+// Messages holds the message index and is accessable to other files
+var Messages Index
+
+// Init selects the index from the SEARCH_BACKEND env variable. "memory"
+// keeps a built-in inverted index for deployments whose database doesn't
+// support text indexes, anything else uses a mongo text index. It also
+// prepares the users for user search
+func Init() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if err := FillSearchKeys(ctx); err != nil {
+		log.Fatal("Error filling user search keys ", err)
+	}
+
+	switch os.Getenv("SEARCH_BACKEND") {
+	case "memory":
+		index := NewMemoryIndex()
+		if err := index.Load(ctx); err != nil {
+			log.Fatal("Error building search index ", err)
+		}
+		Messages = index
+		log.Println("using in-memory search index")
+	default:
+		index := NewMongoIndex()
+		if err := index.EnsureIndex(ctx); err != nil {
+			log.Fatal("Error creating text index ", err)
+		}
+		Messages = index
+		log.Println("using mongo text search index")
+	}
+}
@@ -0,0 +1,214 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryIndex is an inverted index of message content kept in memory
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[primitive.ObjectID]*indexedMessage
+	postings map[string]map[primitive.ObjectID]int // term -> message -> term frequency
+}
+
+type indexedMessage struct {
+	chat       primitive.ObjectID
+	sender     primitive.ObjectID
+	created_at time.Time
+	tokens     []string
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     make(map[primitive.ObjectID]*indexedMessage),
+		postings: make(map[string]map[primitive.ObjectID]int),
+	}
+}
+
+// Load indexes every message already stored in the database
+func (m *MemoryIndex) Load(ctx context.Context) error {
+	messageCollection := database.OpenCollection(database.Client, "message")
+	cursor, err := messageCollection.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var msg models.Message
+		if err := cursor.Decode(&msg); err != nil {
+			return err
+		}
+		m.Add(msg)
+	}
+	return cursor.Err()
+}
+
+func (m *MemoryIndex) Add(msg models.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(msg.Id)
+	// system messages describe chat changes and aren't searchable, nor are
+	// messages deleted for everyone
+	if msg.Type == models.MessageSystem || !msg.Deleted_at.IsZero() {
+		return
+	}
+	doc := &indexedMessage{
+		chat:       msg.Chat,
+		sender:     msg.Sender,
+		created_at: msg.Created_at,
+		tokens:     Tokenize(msg.Content),
+	}
+	m.docs[msg.Id] = doc
+	for _, token := range doc.tokens {
+		if m.postings[token] == nil {
+			m.postings[token] = make(map[primitive.ObjectID]int)
+		}
+		m.postings[token][msg.Id]++
+	}
+}
+
+func (m *MemoryIndex) Remove(messageId primitive.ObjectID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(messageId)
+}
+
+func (m *MemoryIndex) RemoveChat(chatId primitive.ObjectID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, doc := range m.docs {
+		if doc.chat == chatId {
+			m.remove(id)
+		}
+	}
+}
+
+// remove drops a message from the index, callers must hold the write lock
+func (m *MemoryIndex) remove(messageId primitive.ObjectID) {
+	doc, exists := m.docs[messageId]
+	if !exists {
+		return
+	}
+	for _, token := range doc.tokens {
+		delete(m.postings[token], messageId)
+		if len(m.postings[token]) == 0 {
+			delete(m.postings, token)
+		}
+	}
+	delete(m.docs, messageId)
+}
+
+// Search scores matches with tf-idf over the query terms, with a bonus for
+// every phrase, and orders ties by newest first
+func (m *MemoryIndex) Search(ctx context.Context, q Query) (Result, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chats := make(map[primitive.ObjectID]bool, len(q.Chats))
+	for _, chat := range q.Chats {
+		chats[chat] = true
+	}
+
+	// candidates contain at least one term, or the first word of every phrase
+	candidates := make(map[primitive.ObjectID]bool)
+	for _, term := range q.Terms {
+		for id := range m.postings[term] {
+			candidates[id] = true
+		}
+	}
+	if len(q.Terms) == 0 {
+		for _, phrase := range q.Phrases {
+			for id := range m.postings[phrase[0]] {
+				candidates[id] = true
+			}
+		}
+	}
+
+	type scored struct {
+		Hit
+		created_at time.Time
+	}
+	var matches []scored
+	total := float64(len(m.docs))
+
+	for id := range candidates {
+		doc := m.docs[id]
+		if !chats[doc.chat] || !m.matchesFilters(doc, q) {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range q.Terms {
+			if tf := m.postings[term][id]; tf > 0 {
+				idf := math.Log(1 + total/float64(len(m.postings[term])))
+				score += float64(tf) * idf
+			}
+		}
+		score += float64(len(q.Phrases))
+		score /= math.Sqrt(float64(len(doc.tokens)))
+
+		matches = append(matches, scored{Hit{MessageId: id, Score: score}, doc.created_at})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].created_at.After(matches[j].created_at)
+	})
+
+	result := Result{Total: int64(len(matches))}
+	for i := q.Skip; i < len(matches) && len(result.Hits) < q.Limit; i++ {
+		result.Hits = append(result.Hits, matches[i].Hit)
+	}
+	return result, nil
+}
+
+// matchesFilters checks sender, dates, phrases and excluded terms of q
+func (m *MemoryIndex) matchesFilters(doc *indexedMessage, q Query) bool {
+	if !q.Sender.IsZero() && doc.sender != q.Sender {
+		return false
+	}
+	if !q.After.IsZero() && doc.created_at.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !doc.created_at.Before(q.Before) {
+		return false
+	}
+
+	for _, term := range q.Excluded {
+		for _, token := range doc.tokens {
+			if token == term {
+				return false
+			}
+		}
+	}
+
+	for _, phrase := range q.Phrases {
+		found := false
+		for i := 0; i+len(phrase) <= len(doc.tokens) && !found; i++ {
+			found = true
+			for j, word := range phrase {
+				if doc.tokens[i+j] != word {
+					found = false
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package search
+
+import (
+	"context"
+
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoIndex searches the message collection through a mongo text index,
+// mongo keeps the index up to date itself
+type MongoIndex struct{}
+
+func NewMongoIndex() *MongoIndex {
+	return &MongoIndex{}
+}
+
+// EnsureIndex creates the text index on message content if it's missing
+func (m *MongoIndex) EnsureIndex(ctx context.Context) error {
+	messageCollection := database.OpenCollection(database.Client, "message")
+	_, err := messageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"content", "text"}},
+		Options: options.Index().SetName("content_text"),
+	})
+	return err
+}
+
+func (m *MongoIndex) Search(ctx context.Context, q Query) (Result, error) {
+	messageCollection := database.OpenCollection(database.Client, "message")
+
+	filter := bson.D{
+		{"$text", bson.D{{"$search", q.String()}}},
+		{"chat", bson.D{{"$in", q.Chats}}},
+		{"type", bson.D{{"$ne", models.MessageSystem}}},
+		{"deleted_at", bson.D{{"$exists", false}}},
+	}
+	if !q.Sender.IsZero() {
+		filter = append(filter, bson.E{"sender", q.Sender})
+	}
+	created := bson.D{}
+	if !q.After.IsZero() {
+		created = append(created, bson.E{"$gte", q.After})
+	}
+	if !q.Before.IsZero() {
+		created = append(created, bson.E{"$lt", q.Before})
+	}
+	if len(created) > 0 {
+		filter = append(filter, bson.E{"created_at", created})
+	}
+
+	total, err := messageCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return Result{}, err
+	}
+
+	score := bson.D{{"score", bson.D{{"$meta", "textScore"}}}}
+	opts := options.Find().
+		SetProjection(score).
+		SetSort(bson.D{{"score", bson.D{{"$meta", "textScore"}}}, {"created_at", -1}}).
+		SetSkip(int64(q.Skip)).
+		SetLimit(int64(q.Limit))
+
+	cursor, err := messageCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var docs []struct {
+		Id    primitive.ObjectID `bson:"_id"`
+		Score float64            `bson:"score"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Total: total}
+	for _, doc := range docs {
+		result.Hits = append(result.Hits, Hit{MessageId: doc.Id, Score: doc.Score})
+	}
+	return result, nil
+}
+
+func (m *MongoIndex) Add(msg models.Message)               {}
+func (m *MongoIndex) Remove(messageId primitive.ObjectID)  {}
+func (m *MongoIndex) RemoveChat(chatId primitive.ObjectID) {}
@@ -0,0 +1,117 @@
+package search
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Query is a parsed message search. Terms match if any of them is present,
+// like mongo $text, while every phrase must appear and no excluded term may
+type Query struct {
+	Terms    []string
+	Phrases  [][]string
+	Excluded []string
+
+	// Chats restricts the search to these chats, it must always be set to
+	// the chats the searching user belongs to
+	Chats  []primitive.ObjectID
+	Sender primitive.ObjectID
+	After  time.Time
+	Before time.Time
+
+	Skip  int
+	Limit int
+}
+
+// Parse splits the raw search text into terms, "quoted phrases" and
+// -excluded terms
+func Parse(text string) Query {
+	var q Query
+	for len(text) > 0 {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			break
+		}
+
+		if text[0] == '"' {
+			end := strings.IndexByte(text[1:], '"')
+			var phrase string
+			if end < 0 {
+				phrase, text = text[1:], ""
+			} else {
+				phrase, text = text[1:end+1], text[end+2:]
+			}
+			if tokens := Tokenize(phrase); len(tokens) > 0 {
+				q.Phrases = append(q.Phrases, tokens)
+			}
+			continue
+		}
+
+		word := text
+		if end := strings.IndexFunc(text, unicode.IsSpace); end >= 0 {
+			word, text = text[:end], text[end:]
+		} else {
+			text = ""
+		}
+		if strings.HasPrefix(word, "-") {
+			q.Excluded = append(q.Excluded, Tokenize(word[1:])...)
+		} else {
+			q.Terms = append(q.Terms, Tokenize(word)...)
+		}
+	}
+	return q
+}
+
+// Empty reports whether the query has nothing to match on
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// String formats the query in mongo $text search syntax
+func (q Query) String() string {
+	var parts []string
+	parts = append(parts, q.Terms...)
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `"`+strings.Join(phrase, " ")+`"`)
+	}
+	for _, term := range q.Excluded {
+		parts = append(parts, "-"+term)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Tokenize lowercases text and splits it into words of letters and digits
+func Tokenize(text string) []string {
+	var tokens []string
+	for _, t := range tokenSpans(text) {
+		tokens = append(tokens, t.word)
+	}
+	return tokens
+}
+
+type span struct {
+	word       string
+	start, end int // rune offsets into the original text
+}
+
+func tokenSpans(text string) []span {
+	var spans []span
+	runes := []rune(text)
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, span{strings.ToLower(string(runes[start:i])), start, i})
+			start = -1
+		}
+	}
+	return spans
+}
@@ -0,0 +1,116 @@
+package search_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/search"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("returns terms phrases and excluded terms", func(t *testing.T) {
+		query := search.Parse(`Deploy "release notes" -friday Go`)
+
+		if !reflect.DeepEqual(query.Terms, []string{"deploy", "go"}) {
+			t.Errorf("Unexpected result: got %v, want %v", query.Terms, []string{"deploy", "go"})
+		}
+		if !reflect.DeepEqual(query.Phrases, [][]string{{"release", "notes"}}) {
+			t.Errorf("Unexpected result: got %v, want %v", query.Phrases, [][]string{{"release", "notes"}})
+		}
+		if !reflect.DeepEqual(query.Excluded, []string{"friday"}) {
+			t.Errorf("Unexpected result: got %v, want %v", query.Excluded, []string{"friday"})
+		}
+		if query.String() != `deploy go "release notes" -friday` {
+			t.Errorf("Unexpected result: got %v, want %v", query.String(), `deploy go "release notes" -friday`)
+		}
+	})
+}
+
+func TestMemoryIndex(t *testing.T) {
+	index := search.NewMemoryIndex()
+	chat := primitive.NewObjectID()
+	otherChat := primitive.NewObjectID()
+	alice := primitive.NewObjectID()
+	bob := primitive.NewObjectID()
+	now := time.Now()
+
+	messages := []models.Message{
+		{Id: primitive.NewObjectID(), Chat: chat, Sender: alice, Content: "the release notes are ready", Created_at: now.Add(-3 * time.Hour)},
+		{Id: primitive.NewObjectID(), Chat: chat, Sender: bob, Content: "notes for the release", Created_at: now.Add(-2 * time.Hour)},
+		{Id: primitive.NewObjectID(), Chat: chat, Sender: bob, Content: "release release release", Created_at: now.Add(-1 * time.Hour)},
+		{Id: primitive.NewObjectID(), Chat: otherChat, Sender: alice, Content: "release notes in another chat", Created_at: now},
+	}
+	for _, msg := range messages {
+		index.Add(msg)
+	}
+
+	find := func(text string, modify func(q *search.Query)) []primitive.ObjectID {
+		query := search.Parse(text)
+		query.Chats = []primitive.ObjectID{chat}
+		query.Limit = 10
+		if modify != nil {
+			modify(&query)
+		}
+		result, err := index.Search(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []primitive.ObjectID
+		for _, hit := range result.Hits {
+			ids = append(ids, hit.MessageId)
+		}
+		return ids
+	}
+
+	t.Run("returns only messages of the given chats", func(t *testing.T) {
+		ids := find("release", nil)
+		if len(ids) != 3 {
+			t.Errorf("Unexpected result: got %v, want %v", len(ids), 3)
+		}
+		if ids[0] != messages[2].Id {
+			t.Errorf("Unexpected result: got %v, want %v ranked first", ids[0], messages[2].Id)
+		}
+	})
+
+	t.Run("returns messages containing the phrase", func(t *testing.T) {
+		ids := find(`"release notes"`, nil)
+		if !reflect.DeepEqual(ids, []primitive.ObjectID{messages[0].Id}) {
+			t.Errorf("Unexpected result: got %v, want %v", ids, []primitive.ObjectID{messages[0].Id})
+		}
+	})
+
+	t.Run("returns messages of sender within dates", func(t *testing.T) {
+		ids := find("release", func(q *search.Query) {
+			q.Sender = bob
+			q.Before = now.Add(-90 * time.Minute)
+		})
+		if !reflect.DeepEqual(ids, []primitive.ObjectID{messages[1].Id}) {
+			t.Errorf("Unexpected result: got %v, want %v", ids, []primitive.ObjectID{messages[1].Id})
+		}
+	})
+
+	t.Run("returns no removed messages", func(t *testing.T) {
+		index.Remove(messages[2].Id)
+		ids := find("release -notes", nil)
+		if len(ids) != 0 {
+			t.Errorf("Unexpected result: got %v, want %v", len(ids), 0)
+		}
+	})
+}
+
+func TestSnippet(t *testing.T) {
+	t.Run("returns highlighted matches", func(t *testing.T) {
+		snippet, highlights := search.Snippet("Are the Release notes ready?", search.Parse("release"))
+
+		if snippet != "Are the Release notes ready?" {
+			t.Errorf("Unexpected result: got %v, want %v", snippet, "Are the Release notes ready?")
+		}
+		if !reflect.DeepEqual(highlights, [][2]int{{8, 15}}) {
+			t.Errorf("Unexpected result: got %v, want %v", highlights, [][2]int{{8, 15}})
+		}
+	})
+}
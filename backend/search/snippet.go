@@ -0,0 +1,82 @@
+package search
+
+// snippetRadius is the number of runes kept on each side of the first match
+const snippetRadius = 60
+
+// Snippet returns the part of content around the first match of q, along with
+// the [start, end) rune offsets of every match inside the snippet. Offsets are
+// returned instead of markup so clients never have to render message text as html
+func Snippet(content string, q Query) (string, [][2]int) {
+	spans := tokenSpans(content)
+	matched := make([]bool, len(spans))
+
+	terms := make(map[string]bool, len(q.Terms))
+	for _, term := range q.Terms {
+		terms[term] = true
+	}
+	for i, s := range spans {
+		if terms[s.word] {
+			matched[i] = true
+		}
+	}
+	for _, phrase := range q.Phrases {
+		for i := 0; i+len(phrase) <= len(spans); i++ {
+			if phraseAt(spans, i, phrase) {
+				for j := range phrase {
+					matched[i+j] = true
+				}
+			}
+		}
+	}
+
+	runes := []rune(content)
+	first := -1
+	for i := range spans {
+		if matched[i] {
+			first = i
+			break
+		}
+	}
+
+	start, end := 0, len(runes)
+	if first >= 0 && len(runes) > 2*snippetRadius {
+		start = spans[first].start - snippetRadius
+		if start < 0 {
+			start = 0
+		}
+		end = start + 2*snippetRadius
+		if end > len(runes) {
+			end = len(runes)
+			start = end - 2*snippetRadius
+		}
+	} else if len(runes) > 2*snippetRadius {
+		end = 2 * snippetRadius
+	}
+
+	snippet := string(runes[start:end])
+	offset := 0
+	if start > 0 {
+		snippet = "…" + snippet
+		offset = 1
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+
+	var highlights [][2]int
+	for i, s := range spans {
+		if matched[i] && s.start >= start && s.end <= end {
+			highlights = append(highlights, [2]int{s.start - start + offset, s.end - start + offset})
+		}
+	}
+	return snippet, highlights
+}
+
+func phraseAt(spans []span, i int, phrase []string) bool {
+	for j, word := range phrase {
+		if spans[i+j].word != word {
+			return false
+		}
+	}
+	return true
+}
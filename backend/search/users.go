@@ -0,0 +1,34 @@
+package search
+
+import (
+	"context"
+
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FillSearchKeys fills in the search keys of users registered before they
+// existed, user search only finds users by them
+func FillSearchKeys(ctx context.Context) error {
+	userCollection := database.OpenCollection(database.Client, "user")
+
+	cursor, err := userCollection.Find(ctx, bson.D{{"searchKeys", bson.D{{"$exists", false}}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		user.SetSearchKeys()
+		update := bson.D{{"$set", bson.D{{"searchKeys", user.SearchKeys}}}}
+		if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", user.Id}}, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
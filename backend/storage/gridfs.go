@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/pmohanj/web-chat-app/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFSStorage stores files in a MongoDB GridFS bucket, using the key as
+// the file name. Put replaces any earlier file with the same key
+type GridFSStorage struct {
+	Bucket string
+}
+
+func NewGridFSStorage(bucket string) *GridFSStorage {
+	return &GridFSStorage{Bucket: bucket}
+}
+
+func (g *GridFSStorage) bucket() (*gridfs.Bucket, error) {
+	db := database.Client.Database("cluster0")
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(g.Bucket))
+}
+
+func (g *GridFSStorage) Put(ctx context.Context, key string, data []byte) error {
+	bucket, err := g.bucket()
+	if err != nil {
+		return err
+	}
+	if err := g.Delete(ctx, key); err != nil {
+		return err
+	}
+	_, err = bucket.UploadFromStream(key, bytes.NewReader(data))
+	return err
+}
+
+func (g *GridFSStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	bucket, err := g.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	_, err = bucket.DownloadToStreamByName(key, &buf)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *GridFSStorage) Delete(ctx context.Context, key string) error {
+	bucket, err := g.bucket()
+	if err != nil {
+		return err
+	}
+
+	cursor, err := bucket.Find(bson.D{{"filename", key}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var file struct {
+			Id interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&file); err != nil {
+			return err
+		}
+		if err := bucket.Delete(file.Id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+// ErrNotFound is returned by a Storage when the requested key doesn't exist
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage is the backend used to persist files uploaded by users, such as
+// avatars. Keys are slash separated paths like "avatars/<userId>/128.png"
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Store holds the storage backend and is accessable to other files
+var Store Storage
+
+// Init selects the storage backend from the STORAGE_BACKEND env variable.
+// "gridfs" keeps files in MongoDB, anything else stores them on local disk
+// under STORAGE_DIR
+func Init() {
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "gridfs":
+		Store = NewGridFSStorage("uploads")
+		log.Println("using GridFS storage backend")
+	default:
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		Store = NewDiskStorage(dir)
+		log.Println("using disk storage backend at", dir)
+	}
+}
@@ -0,0 +1,74 @@
+package unfurl
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+)
+
+// cache keeps the outcome of unfurling links for a while. When full, expired
+// entries are dropped first, then the one closest to expiring
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	preview    models.LinkPreview
+	err        error
+	expires_at time.Time
+}
+
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{size: size, ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+// get returns the cached outcome for link, ok is false when there's none
+func (c *cache) get(link string) (models.LinkPreview, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[link]
+	if !ok {
+		return models.LinkPreview{}, nil, false
+	}
+	if time.Now().After(entry.expires_at) {
+		delete(c.entries, link)
+		return models.LinkPreview{}, nil, false
+	}
+	return entry.preview, entry.err, true
+}
+
+// set caches the outcome for link, failures for at most failureTTL
+func (c *cache) set(link string, preview models.LinkPreview, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := c.ttl
+	if err != nil && ttl > failureTTL {
+		ttl = failureTTL
+	}
+	if _, ok := c.entries[link]; !ok && len(c.entries) >= c.size {
+		c.evict()
+	}
+	c.entries[link] = cacheEntry{preview: preview, err: err, expires_at: time.Now().Add(ttl)}
+}
+
+// evict makes room for one entry, c.mu must be held
+func (c *cache) evict() {
+	now := time.Now()
+	var soonest string
+	for link, entry := range c.entries {
+		if now.After(entry.expires_at) {
+			delete(c.entries, link)
+		} else if soonest == "" || entry.expires_at.Before(c.entries[soonest].expires_at) {
+			soonest = link
+		}
+	}
+	if len(c.entries) >= c.size {
+		delete(c.entries, soonest)
+	}
+}
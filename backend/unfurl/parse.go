@@ -0,0 +1,116 @@
+package unfurl
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmohanj/web-chat-app/models"
+	"golang.org/x/net/html"
+)
+
+// Bounds on the text kept from a page
+const (
+	maxTitle       = 200
+	maxDescription = 500
+	maxSiteName    = 100
+	maxImageURL    = 2048
+)
+
+// tags are the meta tags of a page by property or name, lower cased, with
+// the first value each had, and its title
+type tags struct {
+	meta  map[string]string
+	title string
+}
+
+// readTags reads the meta tags and title from the head of the page in r. It
+// stops at the body, since previews are only described in the head
+func readTags(r io.Reader) tags {
+	t := tags{meta: make(map[string]string)}
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return t
+
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return t
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				return t
+			case "title":
+				if t.title == "" && z.Next() == html.TextToken {
+					t.title = string(z.Text())
+				}
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var attr, value []byte
+					attr, value, hasAttr = z.TagAttr()
+					switch string(attr) {
+					case "property", "name":
+						if key == "" {
+							key = strings.ToLower(strings.TrimSpace(string(value)))
+						}
+					case "content":
+						content = string(value)
+					}
+				}
+				if _, ok := t.meta[key]; key != "" && !ok {
+					t.meta[key] = content
+				}
+			}
+		}
+	}
+}
+
+// preview builds the preview of the link from the tags, preferring Open
+// Graph over Twitter card tags over plain html. Relative image urls are
+// resolved against base, where the page was fetched from after redirects
+func (t tags) preview(link string, base *url.URL) models.LinkPreview {
+	preview := models.LinkPreview{
+		URL:         link,
+		Title:       clean(t.first("og:title", "twitter:title"), maxTitle),
+		Description: clean(t.first("og:description", "twitter:description", "description"), maxDescription),
+		SiteName:    clean(t.first("og:site_name"), maxSiteName),
+	}
+	if preview.Title == "" {
+		preview.Title = clean(t.title, maxTitle)
+	}
+
+	if image := strings.TrimSpace(t.first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src")); image != "" {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil {
+			if s := u.String(); len(s) <= maxImageURL {
+				preview.Image = s
+			}
+		}
+	}
+	return preview
+}
+
+// first returns the first of the meta tags that has a value
+func (t tags) first(keys ...string) string {
+	for _, key := range keys {
+		if value := strings.TrimSpace(t.meta[key]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// clean collapses whitespace in s and cuts it to max characters
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
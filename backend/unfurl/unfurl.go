@@ -0,0 +1,229 @@
+// Package unfurl builds previews of the links in messages from the Open
+// Graph and Twitter card tags of the pages they point to. Pages are fetched
+// with strict timeouts and size limits, only from public addresses so links
+// can't be used to reach the server's own network, and results are cached
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/models"
+	"github.com/pmohanj/web-chat-app/netguard"
+)
+
+var (
+	// ErrBlocked is returned for links to private, loopback or otherwise
+	// internal addresses, or to ports other than 80 and 443
+	ErrBlocked = netguard.ErrBlocked
+	// ErrNotHTML is returned when the link doesn't point to an HTML page
+	ErrNotHTML = errors.New("unfurl: not an html page")
+	// ErrNoPreview is returned when the page has no title or description
+	ErrNoPreview = errors.New("unfurl: page has nothing to preview")
+)
+
+// Defaults for the zero values of Options
+const (
+	DefaultTimeout       = 5 * time.Second
+	DefaultMaxBytes      = 512 << 10
+	DefaultCacheTTL      = 6 * time.Hour
+	DefaultCacheSize     = 1000
+	DefaultMaxConcurrent = 4
+)
+
+// maxRedirects bounds how many redirects are followed for a link
+const maxRedirects = 3
+
+// failureTTL bounds how long links that couldn't be unfurled are cached, so
+// pages that were down get another try
+const failureTTL = 10 * time.Minute
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'\x60]+`)
+
+// Options configure an Unfurler
+type Options struct {
+	Timeout       time.Duration // for fetching a page, redirects included
+	MaxBytes      int64         // read of a page, tags past it are ignored
+	CacheTTL      time.Duration
+	CacheSize     int
+	MaxConcurrent int  // pages fetched at the same time
+	AllowPrivate  bool // lets tests fetch from a local server, never set in production
+}
+
+// Unfurler fetches and caches link previews, it's safe for concurrent use
+type Unfurler struct {
+	client   *http.Client
+	maxBytes int64
+	cache    *cache
+	slots    chan struct{}
+}
+
+// Links holds the unfurler used for messages and is accessable to other
+// files. It's nil when link previews are turned off
+var Links *Unfurler
+
+// Init turns link previews on unless the LINK_PREVIEWS env variable is "off"
+func Init() {
+	if os.Getenv("LINK_PREVIEWS") == "off" {
+		log.Println("link previews are off")
+		return
+	}
+	Links = New(Options{})
+}
+
+// New returns an Unfurler with opts, zero values take the defaults
+func New(opts Options) *Unfurler {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = DefaultCacheTTL
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = DefaultCacheSize
+	}
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultMaxConcurrent
+	}
+
+	dialer := netguard.Dialer(opts.Timeout)
+	if opts.AllowPrivate {
+		dialer = &net.Dialer{Timeout: opts.Timeout}
+	}
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would be dialed instead of the page
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("unfurl: more than %d redirects", maxRedirects)
+			}
+			return checkURL(req.URL)
+		},
+	}
+
+	return &Unfurler{
+		client:   client,
+		maxBytes: opts.MaxBytes,
+		cache:    newCache(opts.CacheSize, opts.CacheTTL),
+		slots:    make(chan struct{}, opts.MaxConcurrent),
+	}
+}
+
+// Unfurl returns the preview of the page at rawURL, from the cache when it
+// was fetched recently. Failures are cached too, for a shorter time
+func (u *Unfurler) Unfurl(ctx context.Context, rawURL string) (models.LinkPreview, error) {
+	if preview, err, ok := u.cache.get(rawURL); ok {
+		return preview, err
+	}
+
+	select {
+	case u.slots <- struct{}{}:
+		defer func() { <-u.slots }()
+	case <-ctx.Done():
+		return models.LinkPreview{}, ctx.Err()
+	}
+
+	preview, err := u.fetch(ctx, rawURL)
+	if ctx.Err() == nil {
+		// a caller giving up isn't the page's fault
+		u.cache.set(rawURL, preview, err)
+	}
+	return preview, err
+}
+
+// fetch downloads the start of the page at rawURL and reads its preview
+func (u *Unfurler) fetch(ctx context.Context, rawURL string) (models.LinkPreview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return models.LinkPreview{}, err
+	}
+	if err := checkURL(target); err != nil {
+		return models.LinkPreview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return models.LinkPreview{}, err
+	}
+	req.Header.Set("User-Agent", "web-chat-app link preview")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlocked) {
+			return models.LinkPreview{}, ErrBlocked
+		}
+		return models.LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.LinkPreview{}, fmt.Errorf("unfurl: page returned %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return models.LinkPreview{}, ErrNotHTML
+	}
+
+	tags := readTags(io.LimitReader(resp.Body, u.maxBytes))
+	preview := tags.preview(rawURL, resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return models.LinkPreview{}, ErrNoPreview
+	}
+	return preview, nil
+}
+
+// checkURL rejects links that aren't http or https or carry credentials
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unfurl: unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" || u.User != nil {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// ExtractURLs returns the first max distinct http and https links in
+// content, leaving out punctuation that ends the sentence around them
+func ExtractURLs(content string, max int) []string {
+	urls := []string{}
+	seen := make(map[string]bool)
+	for _, match := range urlPattern.FindAllString(content, -1) {
+		if len(urls) == max {
+			break
+		}
+		link := strings.TrimRight(match, ".,;:!?)*_~]")
+		if seen[link] {
+			continue
+		}
+		if u, err := url.Parse(link); err != nil || u.Host == "" {
+			continue
+		}
+		seen[link] = true
+		urls = append(urls, link)
+	}
+	return urls
+}
@@ -0,0 +1,167 @@
+// Package webhook posts chat events to the URLs of outgoing webhooks. Every
+// payload is signed with the webhook's secret so receivers can check it
+// came from the server, and failed deliveries are retried with growing
+// delays
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/netguard"
+)
+
+// Headers sent along with every payload. The signature is "sha256=" and the
+// hex HMAC-SHA256, keyed with the secret, of the timestamp, a dot and the
+// body
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	MaxAttempts = 8                // attempts before a delivery fails for good
+	baseDelay   = 30 * time.Second // before the first retry, doubling after
+	maxDelay    = time.Hour
+)
+
+// Payload is the body posted for an event. Id is the id of the delivery,
+// the same on every attempt so receivers can drop duplicates
+type Payload struct {
+	Id         string      `json:"id"`
+	Event      string      `json:"event"`
+	Workspace  string      `json:"workspace,omitempty"`
+	Chat       string      `json:"chat,omitempty"`
+	Created_at time.Time   `json:"created_at"`
+	Data       interface{} `json:"data"`
+}
+
+// Sender posts payloads to webhooks, it's safe for concurrent use
+type Sender struct {
+	Client *http.Client
+}
+
+// Default is the sender webhooks are delivered with and is accessable to
+// other files
+var Default = NewSender(false)
+
+// Init lets webhooks reach private addresses, such as internal systems, and
+// any port when WEBHOOK_ALLOW_PRIVATE is "true". Otherwise only public
+// addresses on ports 80 and 443 are posted to
+func Init() {
+	if allow, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE")); allow {
+		Default = NewSender(true)
+		log.Println("webhooks may post to private addresses")
+	}
+}
+
+// NewSender returns a sender that, unless allowPrivate, only connects to
+// public addresses
+func NewSender(allowPrivate bool) *Sender {
+	dialer := netguard.Dialer(10 * time.Second)
+	if allowPrivate {
+		dialer = &net.Dialer{Timeout: 10 * time.Second}
+	}
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would be dialed instead of the webhook
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Sender{Client: &http.Client{
+		Transport: transport,
+		Timeout:   15 * time.Second,
+		// a redirect would be followed without the checks of the url
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+}
+
+// Deliver posts body, the payload of the event, to the webhook at rawURL
+// signed with secret. It returns the status the webhook responded with, and
+// an error unless it's a 2xx one
+func (s *Sender) Deliver(ctx context.Context, rawURL, secret, event, deliveryId string, body []byte) (int, error) {
+	if err := CheckURL(rawURL); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "web-chat-app-webhook/1.0")
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderDelivery, deliveryId)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		if errors.Is(err, netguard.ErrBlocked) {
+			return 0, netguard.ErrBlocked
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook: endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature of body sent at timestamp, unix seconds
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one of body sent at timestamp, it
+// is what receivers check payloads with
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff returns how long to wait before the next attempt after attempts
+// failed ones
+func Backoff(attempts int) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// CheckURL returns an error unless rawURL is an http or https url webhooks
+// can be posted to
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return errors.New("webhook: url must be an http or https url")
+	}
+	return nil
+}
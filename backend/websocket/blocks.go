@@ -0,0 +1,235 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/pmohanj/web-chat-app/database"
+	"github.com/pmohanj/web-chat-app/helpers"
+	"github.com/pmohanj/web-chat-app/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errSuspended and errRevoked are returned by identify for users who are
+// suspended and for tokens revoked since they were issued
+var (
+	errSuspended = errors.New("user is suspended")
+	errRevoked   = errors.New("token revoked")
+)
+
+// identify validates the token of the client the way the Authenticate
+// middleware does, and loads the users it blocked so their events are
+// dropped
+func (ws *WebSockets) identify(clientObj *Client, token string) error {
+	claims, err := helpers.ValidateToken(token)
+	if err != nil {
+		return err
+	}
+	userId, err := primitive.ObjectIDFromHex(claims.ID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	userCollection := database.OpenCollection(database.Client, "user")
+	opts := options.FindOne().SetProjection(bson.D{{"blockedUsers", 1}, {"suspended", 1}, {"tokensValidAfter", 1}})
+	if err := userCollection.FindOne(ctx, bson.D{{"_id", userId}}, opts).Decode(&user); err != nil {
+		return err
+	}
+	if user.Suspended {
+		return errSuspended
+	}
+	if claims.IssuedAt == nil || claims.IssuedAt.Time.Before(user.TokensValidAfter) {
+		return errRevoked
+	}
+
+	ws.mu.Lock()
+	clientObj.UserId = claims.ID
+	clientObj.Blocked = blockedSet(user.BlockedUsers)
+	ws.mu.Unlock()
+	return nil
+}
+
+// isChatMember reports whether the user is a member of the chat
+func isChatMember(userId, chatId string) (bool, error) {
+	uId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, err
+	}
+	cId, err := primitive.ObjectIDFromHex(chatId)
+	if err != nil {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	chatCollection := database.OpenCollection(database.Client, "chat")
+	count, err := chatCollection.CountDocuments(ctx, bson.D{{"_id", cId}, {"users", uId}}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// subscribed reports whether the client was set up for the chat
+func (ws *WebSockets) subscribed(clientObj *Client, chatId string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for _, client := range ws.Clients[chatId] {
+		if client == clientObj {
+			return true
+		}
+	}
+	return false
+}
+
+// markSeen records that the user was last seen now, when a connection of
+// theirs closes, since they got events live until then
+func markSeen(userId string) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userCollection := database.OpenCollection(database.Client, "user")
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{"_id", id}}, bson.D{{"$set", bson.D{{"lastSeenAt", time.Now()}}}}); err != nil {
+		log.Println("error while updating last seen: ", err)
+	}
+}
+
+// SetBlocked replaces the blocked users of every connected client of the
+// user, handlers call it when the user blocks or unblocks someone
+func SetBlocked(userId primitive.ObjectID, blocked []primitive.ObjectID) {
+	if Hub == nil {
+		return
+	}
+
+	Hub.mu.Lock()
+	defer Hub.mu.Unlock()
+
+	set := blockedSet(blocked)
+	for _, clients := range Hub.Clients {
+		for _, client := range clients {
+			if client.UserId == userId.Hex() {
+				client.Blocked = set
+			}
+		}
+	}
+}
+
+// Disconnect closes every connection of the user, handlers call it when the
+// user is suspended
+func Disconnect(userId primitive.ObjectID) {
+	if Hub == nil {
+		return
+	}
+
+	// the connection handlers remove the closed clients
+	for _, client := range Hub.userClients(userId.Hex()) {
+		client.Conn.Close()
+	}
+}
+
+// Unsubscribe stops every connection of the user from getting events of the
+// chats, handlers call it when the user leaves or is removed from them
+func Unsubscribe(userId primitive.ObjectID, chatIds ...primitive.ObjectID) {
+	if Hub == nil {
+		return
+	}
+
+	Hub.mu.Lock()
+	defer Hub.mu.Unlock()
+
+	for _, chatId := range chatIds {
+		var kept []*Client
+		for _, client := range Hub.Clients[chatId.Hex()] {
+			if client.UserId != userId.Hex() {
+				kept = append(kept, client)
+			}
+		}
+		if len(kept) == 0 {
+			delete(Hub.Clients, chatId.Hex())
+		} else {
+			Hub.Clients[chatId.Hex()] = kept
+		}
+	}
+}
+
+// Connected reports whether the user has an open connection
+func Connected(userId primitive.ObjectID) bool {
+	if Hub == nil {
+		return false
+	}
+	return len(Hub.userClients(userId.Hex())) > 0
+}
+
+// ConnectedClients returns the number of open connections
+func ConnectedClients() int {
+	if Hub == nil {
+		return 0
+	}
+
+	Hub.mu.Lock()
+	defer Hub.mu.Unlock()
+
+	clients := make(map[*Client]bool)
+	for _, chatClients := range Hub.Clients {
+		for _, client := range chatClients {
+			clients[client] = true
+		}
+	}
+	return len(clients)
+}
+
+// userClients returns the distinct clients identified as the user
+func (ws *WebSockets) userClients(userId string) []*Client {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	seen := make(map[*Client]bool)
+	var clients []*Client
+	for _, chatClients := range ws.Clients {
+		for _, client := range chatClients {
+			if client.UserId == userId && !seen[client] {
+				seen[client] = true
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients
+}
+
+func blockedSet(blocked []primitive.ObjectID) map[string]bool {
+	set := make(map[string]bool, len(blocked))
+	for _, id := range blocked {
+		set[id.Hex()] = true
+	}
+	return set
+}
+
+// eventSender returns the id of the user who sent the event, given as sender
+// or message.sender, either an id or a user object. It's "" when unknown
+func eventSender(data map[string]interface{}) string {
+	if message, ok := data["message"].(map[string]interface{}); ok {
+		data = message
+	}
+	switch sender := data["sender"].(type) {
+	case string:
+		return sender
+	case primitive.ObjectID:
+		return sender.Hex()
+	case map[string]interface{}:
+		id, _ := sender["_id"].(string)
+		return id
+	}
+	return ""
+}
@@ -7,4 +7,10 @@ import (
 type Client struct {
 	WebSockets *WebSockets
 	Conn       *websocket.Conn
+
+	// UserId is set from the token the client must send in its first setup
+	// message, and Blocked holds the ids of the users whose events it
+	// doesn't receive. Both are guarded by mu of WebSockets
+	UserId  string
+	Blocked map[string]bool
 }
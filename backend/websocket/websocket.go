@@ -3,19 +3,82 @@ package websocket
 import (
 	"errors"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// clientEvents are the types of events clients send to the other clients of
+// a chat, everything else is sent by the server
+var clientEvents = map[string]bool{
+	"typing":     true,
+	"stopTyping": true,
+}
+
+// writeWait is how long a client gets to take an event, clients that don't
+// are closed so they can't hold up the others
+const writeWait = 10 * time.Second
+
 type WebSockets struct {
 	Clients   map[string][]*Client
 	Broadcast chan map[string]interface{}
+
+	// mu guards Clients, which connection handlers and SendMessage share
+	mu sync.Mutex
 }
 
+// Hub holds the websocket server, so handlers can push events to chats
+var Hub *WebSockets
+
 func CreateWebSocketsServer() *WebSockets {
-	return &WebSockets{
-		Clients:   make(map[string][]*Client),
-		Broadcast: make(chan map[string]interface{}),
+	Hub = &WebSockets{
+		Clients: make(map[string][]*Client),
+		// buffered so handlers publishing events don't wait on the clients
+		// being written to, events are dropped once it's full
+		Broadcast: make(chan map[string]interface{}, 256),
+	}
+	return Hub
+}
+
+// Publish sends data to every client of the chat. It does nothing when no
+// websocket server was created, like in tests
+func Publish(chatId string, data map[string]interface{}) {
+	if Hub == nil {
+		return
+	}
+	data["chat"] = chatId
+	Hub.enqueue(data)
+}
+
+// PublishToUser sends data to every connection identified as the user,
+// whichever chats they are set up for
+func PublishToUser(userId string, data map[string]interface{}) {
+	if Hub == nil {
+		return
+	}
+	data["recipient"] = userId
+	Hub.enqueue(data)
+}
+
+// enqueue queues data for SendMessage without waiting, dropping it when the
+// queue is full rather than holding up the handler
+func (ws *WebSockets) enqueue(data map[string]interface{}) {
+	select {
+	case ws.Broadcast <- data:
+	default:
+		log.Println("websocket event dropped, the queue is full")
+	}
+}
+
+// write sends msg to the client within writeWait. Clients it fails for are
+// closed and removed, their connection handler ends once it reads
+func (ws *WebSockets) write(client *Client, msg map[string]interface{}) {
+	client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := client.Conn.WriteJSON(msg); err != nil {
+		log.Println(err)
+		client.Conn.Close()
+		ws.removeClient(client)
 	}
 }
 
@@ -33,6 +96,7 @@ func (ws *WebSockets) WSEndpoint() gin.HandlerFunc {
 		defer func() {
 			client.Conn.Close()
 			ws.removeClient(client)
+			markSeen(client.UserId)
 		}()
 		for {
 			var data map[string]interface{}
@@ -52,21 +116,19 @@ func (ws *WebSockets) WSEndpoint() gin.HandlerFunc {
 	}
 }
 
-// removeClient removes client from websocket pool
+// removeClient removes client from websocket pool of every chat it joined
 func (ws *WebSockets) removeClient(clientObj *Client) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
 	for chatId, clients := range ws.Clients {
-		var done bool
 		for i, client := range clients {
 			if client == clientObj {
 				clients = append(clients[:i], clients[i+1:]...)
 				ws.Clients[chatId] = clients
-				done = true
 				break
 			}
 		}
-		if done {
-			break
-		}
 	}
 }
 
@@ -82,6 +144,29 @@ func (ws *WebSockets) HandleClientMessage(clientObj *Client, data map[string]int
 			return errors.New("chat id type is not string")
 		}
 
+		// only clients with a valid token of a user who isn't suspended get
+		// events, closing the others ends their handler
+		if clientObj.UserId == "" {
+			token, _ := data["token"].(string)
+			if err := ws.identify(clientObj, token); err != nil {
+				log.Println("client not identified ", err)
+				clientObj.Conn.Close()
+				return nil
+			}
+		}
+
+		// and only events of their chats
+		member, err := isChatMember(clientObj.UserId, chatId)
+		if err != nil {
+			log.Println("error while checking chat member: ", err)
+			return nil
+		}
+		if !member {
+			log.Println("client isn't a member of chat ", chatId)
+			return nil
+		}
+
+		ws.mu.Lock()
 		clients, exists := ws.Clients[chatId]
 		if exists {
 			clients = append(clients, clientObj)
@@ -91,12 +176,30 @@ func (ws *WebSockets) HandleClientMessage(clientObj *Client, data map[string]int
 			clients = append(clients, clientObj)
 			ws.Clients[chatId] = clients
 		}
+		ws.mu.Unlock()
 
 		log.Printf("Client added to list %+v", clientObj)
 	} else {
+		// clients only send the events other clients can't get from the
+		// server, and only to the chats they were set up for
+		messageType, _ := data["messageType"].(string)
+		if !clientEvents[messageType] {
+			log.Println("client event of unknown type dropped: ", messageType)
+			return nil
+		}
+		chatId, _ := data["chat"].(string)
+		if !ws.subscribed(clientObj, chatId) {
+			log.Println("message to a chat the client isn't set up for dropped")
+			return nil
+		}
 
-		// broadcast the message/data
-		clientObj.WebSockets.Broadcast <- data
+		// broadcast the event as sent by the client's user, clients only
+		// reach chats, not users
+		ws.enqueue(map[string]interface{}{
+			"messageType": messageType,
+			"chat":        chatId,
+			"sender":      clientObj.UserId,
+		})
 	}
 	return nil
 }
@@ -106,16 +209,45 @@ func (ws *WebSockets) HandleClientMessage(clientObj *Client, data map[string]int
 func (ws *WebSockets) SendMessage() {
 	for {
 		msg := <-ws.Broadcast
-		chatId := msg["chat"].(string)
+		if recipient, ok := msg["recipient"].(string); ok {
+			// the user doesn't get events of users they blocked
+			sender := eventSender(msg)
+			var clientsOfThisUser []*Client
+			for _, client := range ws.userClients(recipient) {
+				ws.mu.Lock()
+				blocked := sender != "" && client.Blocked[sender]
+				ws.mu.Unlock()
+				if !blocked {
+					clientsOfThisUser = append(clientsOfThisUser, client)
+				}
+			}
+			for _, client := range clientsOfThisUser {
+				ws.write(client, msg)
+			}
+			continue
+		}
+
+		chatId, ok := msg["chat"].(string)
+		if !ok {
+			log.Println("message without chat id dropped")
+			continue
+		}
+
+		// get the chat to which the msg should be send, leaving out the
+		// clients that blocked the sender
+		sender := eventSender(msg)
+		var clientsOfThisChat []*Client
+		ws.mu.Lock()
+		for _, client := range ws.Clients[chatId] {
+			if sender == "" || !client.Blocked[sender] {
+				clientsOfThisChat = append(clientsOfThisChat, client)
+			}
+		}
+		ws.mu.Unlock()
 
-		// get the chat to which the msg should be send
-		clientsOfThisChat := ws.Clients[chatId]
 		log.Printf("size of chat %v and chatid is: %v", len(clientsOfThisChat), chatId)
 		for _, client := range clientsOfThisChat {
-			err := client.Conn.WriteJSON(msg)
-			if err != nil {
-				log.Panic(err)
-			}
+			ws.write(client, msg)
 		}
 	}
 }